@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 	"parseflow/internal"
+	"time"
 
 	ip2 "github.com/ip2location/ip2location-go"
 )
@@ -24,6 +25,7 @@ func main() {
 	rawDbChan := make(chan *internal.ParsedLog, config.RawLogChanSize)
 	dbWriteChan := make(chan *internal.Metric, 1000) //metric should <- to this on processing flow
 	metricChan := make(chan *internal.ParsedLog, config.MetricChanSize)
+	alertChan := make(chan internal.Alert, 100)
 
 	app := &internal.App{
 		Dc:             dc,
@@ -33,17 +35,100 @@ func main() {
 		DbRawWriteChan: rawDbChan,
 		DbWriteChan:    dbWriteChan,
 		MetricChan:     metricChan,
+		AlertChan:      alertChan,
 		Config:         config,
+		Parsers:        internal.DefaultParserRegistry(),
+		Routes:         internal.NewRouteNormalizer(config.RouteTemplates),
 	}
 	app.RateLimiter = internal.NewRateLimiterMap(100, 10)
+	logDb, err := app.OpenDb()
+	if err != nil {
+		log.Fatalf("Failed to open database: %v", err)
+	}
+	defer logDb.Close()
+	app.LogDb = logDb
+
+	alertRules := internal.DefaultAlertRules()
+	if config.AlertRulesPath != "" {
+		alertRules, err = internal.LoadAlertRules(config.AlertRulesPath)
+		if err != nil {
+			log.Fatalf("Failed to load alert rules: %v", err)
+		}
+	}
+	app.AlertEngine = internal.NewAlertEngine(alertRules, nil)
+
+	if config.AnomalyThreshold > 0 {
+		app.Anomalies = internal.NewAnomalyDetector(config.AnomalyThreshold)
+		go func() {
+			n, err := app.LoadAnomalyHistory(logDb, time.Now().Add(-config.AnomalyHistory))
+			if err != nil {
+				log.Printf("Failed to load anomaly baselines: %v", err)
+			} else {
+				log.Printf("Anomaly baselines learned from %d snapshots", n)
+			}
+		}()
+	}
+
+	if config.NotifiersPath != "" {
+		notifiers, err := internal.LoadNotifiers(config.NotifiersPath)
+		if err != nil {
+			log.Fatalf("Failed to load notifiers: %v", err)
+		}
+		app.Notifiers = internal.NewNotifiers(notifiers)
+		app.Notifiers.Start()
+	}
+
+	if config.OTLPEndpoint != "" {
+		app.OTLP, err = internal.NewOTLPExporter(internal.OTLPConfig{
+			Endpoint:       config.OTLPEndpoint,
+			Protocol:       config.OTLPProtocol,
+			Headers:        config.OTLPHeaders,
+			ServiceName:    config.OTLPServiceName,
+			MetricInterval: config.OTLPMetricInterval,
+		})
+		if err != nil {
+			log.Fatalf("Failed to configure OTLP export: %v", err)
+		}
+	}
+
+	if config.SLOPath != "" {
+		slos, err := internal.LoadSLOs(config.SLOPath)
+		if err != nil {
+			log.Fatalf("Failed to load SLOs: %v", err)
+		}
+		app.SLOs = internal.NewSLOTracker(slos)
+	}
+
+	if config.RulesPath != "" {
+		rules, err := internal.LoadRules(config.RulesPath)
+		if err != nil {
+			log.Fatalf("Failed to load rules: %v", err)
+		}
+		app.Parsers.Register(rules.Parser())
+		go rules.Watch(config.RulesReloadInterval)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("POST /logdrains", app.LogReceiver)
 	mux.HandleFunc("GET /metrics", app.MetricsHandler)
+	mux.HandleFunc("GET /metrics/endpoints", app.EndpointsHandler)
+	mux.HandleFunc("GET /metrics/prometheus", app.PrometheusHandler)
+	mux.HandleFunc("GET /requests/{id}", app.RequestLogsHandler)
+	mux.HandleFunc("GET /deploys", app.DeploysHandler)
+	mux.HandleFunc("GET /logs", app.FieldLogsHandler)
+	mux.HandleFunc("POST /rules/dry-run", app.RulesDryRunHandler)
+	mux.HandleFunc("POST /alerts/{id}/ack", app.AckAlertHandler)
+	mux.HandleFunc("GET /alerts/history", app.AlertHistoryHandler)
+	mux.HandleFunc("GET /alerts/silences", app.ListSilencesHandler)
+	mux.HandleFunc("POST /alerts/silences", app.CreateSilenceHandler)
+	mux.HandleFunc("DELETE /alerts/silences/{id}", app.DeleteSilenceHandler)
 
 	go app.ParserWorker()
 	go app.FanOut()
 	go app.StartMetricsAggregator()
+	go app.StartAlertEngine(config.AlertEvalInterval)
 	go app.StartDbWriter()
+	go app.StartOTLPExporter()
 
 	err = http.ListenAndServe(":"+config.Port, mux)
 	if err != nil {
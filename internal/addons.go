@@ -0,0 +1,250 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// app[heroku-postgres] and app[heroku-redis]: add-on metrics logs
+
+const (
+	defaultPostgresConnectionLimit = 120 // standard-0
+	defaultRedisConnectionLimit    = 40  // premium-0
+
+	dbConnectionsWarning  = 80.0 // % of the plan limit
+	dbConnectionsCritical = 95.0
+	dbMemoryWarning       = 90.0 // % of instance memory
+)
+
+// DatabaseSample is one metrics line of a postgres or redis add-on, sizes in bytes
+type DatabaseSample struct {
+	Kind               string  `json:"kind"`   // "postgres", "redis"
+	Source             string  `json:"source"` // attachment, DATABASE, HEROKU_POSTGRESQL_VIOLET, REDIS
+	Addon              string  `json:"addon"`
+	DbSize             int64   `json:"db_size"`
+	Tables             int64   `json:"tables"`
+	ActiveConnections  int64   `json:"active_connections"`
+	WaitingConnections int64   `json:"waiting_connections"`
+	ReadIops           float64 `json:"read_iops"`
+	WriteIops          float64 `json:"write_iops"`
+	LoadAvg1m          float64 `json:"load_avg_1m"`
+	LoadAvg5m          float64 `json:"load_avg_5m"`
+	LoadAvg15m         float64 `json:"load_avg_15m"`
+	MemoryTotal        int64   `json:"memory_total"`
+	MemoryFree         int64   `json:"memory_free"`
+	MemoryCached       int64   `json:"memory_cached"`
+	MemoryPostgres     int64   `json:"memory_postgres,omitempty"`
+	MemoryRedis        int64   `json:"memory_redis,omitempty"`
+	IndexCacheHitRate  float64 `json:"index_cache_hit_rate,omitempty"`
+	TableCacheHitRate  float64 `json:"table_cache_hit_rate,omitempty"`
+	HitRate            float64 `json:"hit_rate,omitempty"` // redis keyspace
+	EvictedKeys        int64   `json:"evicted_keys,omitempty"`
+}
+
+// DatabaseHealth is the latest sample of an add-on with usage derived from it
+type DatabaseHealth struct {
+	DatabaseSample
+	ConnectionLimit int64     `json:"connection_limit"`
+	ConnectionUsage float64   `json:"connection_usage"` // 0-100%
+	MemoryUsage     float64   `json:"memory_usage"`     // 0-100%
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+type addonParser struct {
+	source string
+	kind   string
+	procId string
+}
+
+func (p addonParser) Source() string { return p.source }
+
+func (p addonParser) Match(h *SyslogHeader) bool {
+	return h.AppName == "app" && h.ProcId == p.procId
+}
+
+// Non metrics lines (postgres server logs) keep their fields but carry no sample
+func (p addonParser) Parse(h *SyslogHeader, msg string, kv map[string]string) *ParsedLog {
+	l := newSourceLog(h, msg)
+	l.Fields = messageFields(kv)
+	l.Database = parseDatabaseSample(p.kind, kv)
+	return l
+}
+
+func parseDatabaseSample(kind string, kv map[string]string) *DatabaseSample {
+	s := &DatabaseSample{
+		Kind:   kind,
+		Source: kv["source"],
+		Addon:  kv["addon"],
+	}
+	found := false
+	for k, v := range kv {
+		name, ok := strings.CutPrefix(k, "sample#")
+		if !ok {
+			continue
+		}
+		f, ok := parseSampleValue(v)
+		if !ok {
+			continue
+		}
+		found = true
+		switch name {
+		case "db_size":
+			s.DbSize = int64(f)
+		case "tables":
+			s.Tables = int64(f)
+		case "active-connections":
+			s.ActiveConnections = int64(f)
+		case "waiting-connections":
+			s.WaitingConnections = int64(f)
+		case "read-iops":
+			s.ReadIops = f
+		case "write-iops":
+			s.WriteIops = f
+		case "load-avg-1m":
+			s.LoadAvg1m = f
+		case "load-avg-5m":
+			s.LoadAvg5m = f
+		case "load-avg-15m":
+			s.LoadAvg15m = f
+		case "memory-total":
+			s.MemoryTotal = int64(f)
+		case "memory-free":
+			s.MemoryFree = int64(f)
+		case "memory-cached":
+			s.MemoryCached = int64(f)
+		case "memory-postgres":
+			s.MemoryPostgres = int64(f)
+		case "memory-redis":
+			s.MemoryRedis = int64(f)
+		case "index-cache-hit-rate":
+			s.IndexCacheHitRate = f
+		case "table-cache-hit-rate":
+			s.TableCacheHitRate = f
+		case "hit-rate":
+			s.HitRate = f
+		case "evicted-keys":
+			s.EvictedKeys = int64(f)
+		}
+	}
+	if !found {
+		return nil
+	}
+	return s
+}
+
+// "8567944bytes", "4045992kB", "0.99", "12pages"
+func parseSampleValue(v string) (float64, bool) {
+	multiplier := 1.0
+	for _, unit := range []struct {
+		suffix string
+		mult   float64
+	}{
+		{"bytes", 1},
+		{"kB", 1 << 10},
+		{"MB", 1 << 20},
+		{"GB", 1 << 30},
+		{"pages", 1},
+	} {
+		if strings.HasSuffix(v, unit.suffix) {
+			v = strings.TrimSuffix(v, unit.suffix)
+			multiplier = unit.mult
+			break
+		}
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return 0, false
+	}
+	return f * multiplier, true
+}
+
+func (a *App) connectionLimit(kind string) int64 {
+	switch kind {
+	case "postgres":
+		if a.Config != nil {
+			return int64(a.Config.PostgresConnectionLimit)
+		}
+		return defaultPostgresConnectionLimit
+	case "redis":
+		if a.Config != nil {
+			return int64(a.Config.RedisConnectionLimit)
+		}
+		return defaultRedisConnectionLimit
+	}
+	return 0
+}
+
+// called by the aggregator with MetricsMu held
+func (a *App) applyDatabaseLog(l *ParsedLog, now time.Time) {
+	s := l.Database
+	if s == nil {
+		return
+	}
+	key := s.Source
+	if key == "" {
+		key = s.Kind
+	}
+
+	health := DatabaseHealth{
+		DatabaseSample:  *s,
+		ConnectionLimit: a.connectionLimit(s.Kind),
+		UpdatedAt:       now,
+	}
+	if health.ConnectionLimit > 0 {
+		health.ConnectionUsage = float64(s.ActiveConnections) / float64(health.ConnectionLimit) * 100
+	}
+	if s.MemoryTotal > 0 {
+		// page cache is reclaimable, only count what the engine holds
+		health.MemoryUsage = float64(s.MemoryTotal-s.MemoryFree-s.MemoryCached) / float64(s.MemoryTotal) * 100
+	}
+	a.Metric.DatabaseHealth[key] = health
+}
+
+func (a *App) generateDatabaseAlerts(currentTime time.Time) {
+	names := make([]string, 0, len(a.Metric.DatabaseHealth))
+	for name := range a.Metric.DatabaseHealth {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		health := a.Metric.DatabaseHealth[name]
+
+		if health.ConnectionUsage >= dbConnectionsWarning {
+			alert := Alert{
+				Type:      "db_connections",
+				Database:  name,
+				Severity:  "warning",
+				Message:   fmt.Sprintf("%s: %d of %d connections in use", name, health.ActiveConnections, health.ConnectionLimit),
+				Timestamp: currentTime,
+				Resolved:  false,
+			}
+			if health.ConnectionUsage >= dbConnectionsCritical {
+				alert.Severity = "critical"
+			}
+			a.raiseAlert(alert)
+		} else {
+			a.resolveAlert(func(e Alert) bool {
+				return e.Type == "db_connections" && e.Database == name
+			}, currentTime)
+		}
+
+		if health.MemoryUsage >= dbMemoryWarning {
+			a.raiseAlert(Alert{
+				Type:      "db_memory",
+				Database:  name,
+				Severity:  "warning",
+				Message:   fmt.Sprintf("%s: memory usage at %.1f%%", name, health.MemoryUsage),
+				Timestamp: currentTime,
+				Resolved:  false,
+			})
+		} else {
+			a.resolveAlert(func(e Alert) bool {
+				return e.Type == "db_memory" && e.Database == name
+			}, currentTime)
+		}
+	}
+}
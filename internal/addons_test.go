@@ -0,0 +1,153 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestAddonParser(t *testing.T) {
+	t.Run("postgres metrics", func(t *testing.T) {
+		app := &App{ParsedLogChan: make(chan *ParsedLog, 1)}
+		app.ParseLog([]byte(`<134>1 2025-07-19T10:30:45Z host app heroku-postgres - source=HEROKU_POSTGRESQL_VIOLET addon=postgresql-curly-1234 sample#current_transaction=1873 sample#db_size=8567944bytes sample#tables=12 sample#active-connections=42 sample#waiting-connections=1 sample#index-cache-hit-rate=0.99 sample#table-cache-hit-rate=0.98 sample#load-avg-1m=0.5 sample#read-iops=12.5 sample#write-iops=3 sample#memory-total=4045992kB sample#memory-free=1000000kB sample#memory-cached=2000000kB sample#memory-postgres=20000kB`))
+
+		l := <-app.ParsedLogChan
+		if l.Source != SourcePostgres {
+			t.Fatalf("Expected postgres source, got %q", l.Source)
+		}
+		s := l.Database
+		if s == nil {
+			t.Fatal("Expected database sample")
+		}
+		if s.Kind != "postgres" || s.Source != "HEROKU_POSTGRESQL_VIOLET" || s.Addon != "postgresql-curly-1234" {
+			t.Errorf("Unexpected identity %q %q %q", s.Kind, s.Source, s.Addon)
+		}
+		if s.DbSize != 8567944 || s.Tables != 12 || s.ActiveConnections != 42 || s.WaitingConnections != 1 {
+			t.Errorf("Unexpected counters %+v", s)
+		}
+		if s.MemoryTotal != 4045992*1024 || s.MemoryPostgres != 20000*1024 {
+			t.Errorf("Expected kB converted to bytes, got total %d postgres %d", s.MemoryTotal, s.MemoryPostgres)
+		}
+		if s.ReadIops != 12.5 || s.TableCacheHitRate != 0.98 {
+			t.Errorf("Unexpected rates %+v", s)
+		}
+	})
+
+	t.Run("redis metrics", func(t *testing.T) {
+		app := &App{ParsedLogChan: make(chan *ParsedLog, 1)}
+		app.ParseLog([]byte(`<134>1 2025-07-19T10:30:45Z host app heroku-redis - source=REDIS addon=redis-flat-5678 sample#active-connections=7 sample#memory-redis=1048576bytes sample#hit-rate=0.25 sample#evicted-keys=3`))
+
+		l := <-app.ParsedLogChan
+		if l.Source != SourceRedis || l.Database == nil {
+			t.Fatalf("Expected redis sample, got source %q", l.Source)
+		}
+		if l.Database.MemoryRedis != 1048576 || l.Database.HitRate != 0.25 || l.Database.EvictedKeys != 3 {
+			t.Errorf("Unexpected redis values %+v", l.Database)
+		}
+	})
+
+	t.Run("postgres server log", func(t *testing.T) {
+		app := &App{ParsedLogChan: make(chan *ParsedLog, 1)}
+		app.ParseLog([]byte(`<134>1 2025-07-19T10:30:45Z host app heroku-postgres - [DATABASE] [12-1] LOG: checkpoint starting: time`))
+
+		l := <-app.ParsedLogChan
+		if l.Source != SourcePostgres || l.Database != nil {
+			t.Errorf("Expected postgres line without sample, got %q %+v", l.Source, l.Database)
+		}
+	})
+}
+
+func TestParseSampleValue(t *testing.T) {
+	tests := []struct {
+		in   string
+		want float64
+		ok   bool
+	}{
+		{"8567944bytes", 8567944, true},
+		{"2kB", 2048, true},
+		{"1.5MB", 1.5 * 1024 * 1024, true},
+		{"0.99", 0.99, true},
+		{"348836pages", 348836, true},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseSampleValue(tt.in)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseSampleValue(%q) = (%v, %v), expected (%v, %v)", tt.in, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestApp_DatabaseHealthAggregation(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.Config = &Config{PostgresConnectionLimit: 100, RedisConnectionLimit: 20}
+	app.StartMetricsAggregator()
+
+	app.MetricChan <- &ParsedLog{
+		Source: SourcePostgres,
+		Database: &DatabaseSample{
+			Kind: "postgres", Source: "DATABASE", ActiveConnections: 85,
+			MemoryTotal: 1000, MemoryFree: 100, MemoryCached: 800,
+		},
+	}
+	app.MetricChan <- &ParsedLog{
+		Source:   SourceRedis,
+		Database: &DatabaseSample{Kind: "redis", Source: "REDIS", ActiveConnections: 20},
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	metrics := app.GetMetricsSnapshot()
+	pg, ok := metrics.DatabaseHealth["DATABASE"]
+	if !ok {
+		t.Fatalf("Expected DATABASE health, got %v", metrics.DatabaseHealth)
+	}
+	if pg.ConnectionLimit != 100 || pg.ConnectionUsage != 85 {
+		t.Errorf("Expected 85%% of 100 connections, got %v of %d", pg.ConnectionUsage, pg.ConnectionLimit)
+	}
+	if pg.MemoryUsage != 10 {
+		t.Errorf("Expected cache excluded from memory usage, got %v", pg.MemoryUsage)
+	}
+	if metrics.TotalRequests != 0 {
+		t.Errorf("Expected add-on lines to stay out of request counters, got %d", metrics.TotalRequests)
+	}
+
+	severities := map[string]string{}
+	for _, alert := range metrics.ActiveAlerts {
+		if alert.Type == "db_connections" {
+			severities[alert.Database] = alert.Severity
+		}
+	}
+	if severities["DATABASE"] != "warning" || severities["REDIS"] != "critical" {
+		t.Errorf("Expected DATABASE warning and REDIS critical, got %v", severities)
+	}
+}
+
+func TestWriteSnapshotToDb_DatabaseHealth(t *testing.T) {
+	app := createWriterTestApp(t)
+	db := createWriterTestDB(t)
+	defer db.Close()
+	if err := app.initTables(db); err != nil {
+		t.Fatalf("Failed to init tables: %v", err)
+	}
+
+	snapshot := createWriterTestMetric(t)
+	snapshot.DatabaseHealth = map[string]DatabaseHealth{
+		"DATABASE": {DatabaseSample: DatabaseSample{Kind: "postgres", DbSize: 1234, ActiveConnections: 5}},
+	}
+	if err := app.writeSnapshotToDb(db, snapshot); err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	var metricsData string
+	if err := db.QueryRow("SELECT metrics_data FROM metric_snapshots").Scan(&metricsData); err != nil {
+		t.Fatalf("Failed to read snapshot: %v", err)
+	}
+	var stored Metric
+	if err := json.Unmarshal([]byte(metricsData), &stored); err != nil {
+		t.Fatalf("Failed to unmarshal snapshot: %v", err)
+	}
+	if stored.DatabaseHealth["DATABASE"].DbSize != 1234 {
+		t.Errorf("Expected database health in snapshot history, got %+v", stored.DatabaseHealth)
+	}
+}
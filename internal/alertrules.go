@@ -0,0 +1,356 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Declarative alert rules evaluated on a schedule against the rolling windows,
+// loaded from a JSON file:
+//
+//	{
+//	  "rules": [
+//	    {"name": "slow_dyno", "metric": "p95_response_ms", "scope": "dyno", "window": "5m",
+//	     "op": ">", "threshold": 1500, "for": "2m", "severity": "warning"},
+//	    {"name": "checkout_errors", "alert": "endpoint_errors", "metric": "error_rate",
+//	     "scope": "endpoint", "threshold": 20, "severity": "critical"}
+//	  ]
+//	}
+//
+// A rule fires once its condition held for "for". Rules sharing an alert type
+// escalate one alert per scope, the most severe firing rule wins. Scoped rules
+// skip dynos and endpoints without traffic in the window.
+
+const (
+	defaultAlertRuleWindow   = 5 * time.Minute
+	defaultAlertEvalInterval = 15 * time.Second
+	alertScopeGlobal         = "global"
+	alertScopeDyno           = "dyno"
+	alertScopeEndpoint       = "endpoint"
+)
+
+// what the rules replace, the same thresholds over a 5m window
+var defaultAlertRules = []AlertRuleConfig{
+	{Name: "high_error_rate", Metric: "error_rate", Threshold: 5, Severity: "warning", Message: "Error rate is above 5%"},
+	{Name: "high_error_rate_critical", Alert: "high_error_rate", Metric: "error_rate", Threshold: 10, Severity: "critical", Message: "Error rate is above 10%"},
+	{Name: "slow_response", Metric: "p95_response_ms", Threshold: 2000, Severity: "warning", Message: "P95 response time is above 2 seconds"},
+	{Name: "slow_response_critical", Alert: "slow_response", Metric: "p95_response_ms", Threshold: 5000, Severity: "critical", Message: "P95 response time is above 5 seconds"},
+}
+
+func ms(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+var alertRuleMetrics = map[string]func(WindowMetric) float64{
+	"error_rate":          func(w WindowMetric) float64 { return w.ErrorRate },
+	"success_rate":        func(w WindowMetric) float64 { return w.SuccessRate },
+	"requests_per_second": func(w WindowMetric) float64 { return w.RequestsPerSecond },
+	"total_requests":      func(w WindowMetric) float64 { return float64(w.TotalRequests) },
+	"slow_requests":       func(w WindowMetric) float64 { return float64(w.SlowRequestCount) },
+	"status_4xx":          func(w WindowMetric) float64 { return float64(w.Status4xx) },
+	"status_5xx":          func(w WindowMetric) float64 { return float64(w.Status5xx) },
+	"apdex":               func(w WindowMetric) float64 { return w.Apdex },
+	"avg_response_ms":     func(w WindowMetric) float64 { return ms(w.AvgResponseTime) },
+	"p50_response_ms":     func(w WindowMetric) float64 { return ms(w.P50ResponseTime) },
+	"p90_response_ms":     func(w WindowMetric) float64 { return ms(w.P90ResponseTime) },
+	"p95_response_ms":     func(w WindowMetric) float64 { return ms(w.P95ResponseTime) },
+	"p99_response_ms":     func(w WindowMetric) float64 { return ms(w.P99ResponseTime) },
+}
+
+var alertRuleOps = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+}
+
+var alertSeverities = map[string]int{"warning": 1, "critical": 2}
+
+type AlertRuleConfig struct {
+	Name      string  `json:"name"`
+	Alert     string  `json:"alert,omitempty"` // alert type, defaults to the name
+	Metric    string  `json:"metric"`
+	Scope     string  `json:"scope,omitempty"`  // "global" (default), "dyno", "endpoint"
+	Window    string  `json:"window,omitempty"` // "5m", between 10s and 1h
+	Op        string  `json:"op,omitempty"`     // ">" (default), ">=", "<", "<="
+	Threshold float64 `json:"threshold"`
+	For       string  `json:"for,omitempty"` // "2m", empty fires on the first evaluation
+	Severity  string  `json:"severity"`      // "warning", "critical"
+	Message   string  `json:"message,omitempty"`
+}
+
+type AlertRulesFile struct {
+	Rules []AlertRuleConfig `json:"rules"`
+}
+
+type AlertRule struct {
+	AlertRuleConfig
+	window time.Duration
+	hold   time.Duration
+	value  func(WindowMetric) float64
+	cmp    func(v, threshold float64) bool
+}
+
+// CompileAlertRules validates a rules file, errors name the offending rule
+func CompileAlertRules(f *AlertRulesFile) ([]*AlertRule, error) {
+	rules := make([]*AlertRule, 0, len(f.Rules))
+	seen := make(map[string]struct{}, len(f.Rules))
+	for _, rc := range f.Rules {
+		if rc.Name == "" {
+			return nil, errors.New("alert rule without a name")
+		}
+		if _, dup := seen[rc.Name]; dup {
+			return nil, fmt.Errorf("alert rule %q: duplicate name", rc.Name)
+		}
+		seen[rc.Name] = struct{}{}
+
+		r := &AlertRule{AlertRuleConfig: rc, window: defaultAlertRuleWindow}
+		if r.Alert == "" {
+			r.Alert = r.Name
+		}
+		if r.Scope == "" {
+			r.Scope = alertScopeGlobal
+		}
+		if r.Op == "" {
+			r.Op = ">"
+		}
+
+		var ok bool
+		if r.value, ok = alertRuleMetrics[r.Metric]; !ok {
+			return nil, fmt.Errorf("alert rule %q: unknown metric %q", rc.Name, r.Metric)
+		}
+		if r.cmp, ok = alertRuleOps[r.Op]; !ok {
+			return nil, fmt.Errorf("alert rule %q: unknown op %q", rc.Name, r.Op)
+		}
+		if _, ok := alertSeverities[r.Severity]; !ok {
+			return nil, fmt.Errorf("alert rule %q: severity must be warning or critical", rc.Name)
+		}
+		switch r.Scope {
+		case alertScopeGlobal, alertScopeDyno, alertScopeEndpoint:
+		default:
+			return nil, fmt.Errorf("alert rule %q: scope must be global, dyno or endpoint", rc.Name)
+		}
+		if rc.Window != "" {
+			d, err := time.ParseDuration(rc.Window)
+			if err != nil || d < windowBucketSpan || d > maxWindow {
+				return nil, fmt.Errorf("alert rule %q: %w", rc.Name, errBadWindow)
+			}
+			r.window = d
+		}
+		if rc.For != "" {
+			d, err := time.ParseDuration(rc.For)
+			if err != nil || d < 0 {
+				return nil, fmt.Errorf("alert rule %q: bad for %q", rc.Name, rc.For)
+			}
+			r.hold = d
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+func LoadAlertRules(path string) ([]*AlertRule, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f AlertRulesFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	return CompileAlertRules(&f)
+}
+
+func DefaultAlertRules() []*AlertRule {
+	rules, err := CompileAlertRules(&AlertRulesFile{Rules: defaultAlertRules})
+	if err != nil {
+		panic(err)
+	}
+	return rules
+}
+
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// AlertEngine is written by the aggregator and the evaluation loop, both under MetricsMu
+type AlertEngine struct {
+	rules []*AlertRule
+	clock Clock
+
+	// windows per dyno and route, only kept when a rule needs the scope
+	dynos     map[string]*TimeWindows
+	endpoints map[string]*TimeWindows
+
+	pending map[string]time.Time // rule|scope | condition true since
+}
+
+// NewAlertEngine evaluates rules at the times of clock, nil means the system clock
+func NewAlertEngine(rules []*AlertRule, clock Clock) *AlertEngine {
+	if clock == nil {
+		clock = systemClock{}
+	}
+	e := &AlertEngine{
+		rules:   rules,
+		clock:   clock,
+		pending: make(map[string]time.Time),
+	}
+	for _, r := range rules {
+		switch r.Scope {
+		case alertScopeDyno:
+			e.dynos = make(map[string]*TimeWindows)
+		case alertScopeEndpoint:
+			e.endpoints = make(map[string]*TimeWindows)
+		}
+	}
+	return e
+}
+
+// Record adds a router line to the scoped windows, route as folded by EndpointStats
+func (e *AlertEngine) Record(at time.Time, route string, l *ParsedLog) {
+	if e == nil {
+		return
+	}
+	if e.dynos != nil && l.SourceDyno != "" {
+		scopedWindows(e.dynos, l.SourceDyno).Record(at, l)
+	}
+	if e.endpoints != nil {
+		scopedWindows(e.endpoints, route).Record(at, l)
+	}
+}
+
+func scopedWindows(m map[string]*TimeWindows, key string) *TimeWindows {
+	w, ok := m[key]
+	if !ok {
+		w = NewTimeWindows()
+		m[key] = w
+	}
+	return w
+}
+
+type alertTarget struct {
+	alert, dyno, endpoint string
+}
+
+// evaluateAlertRules raises the firing rules and resolves the alerts of rule
+// types nothing fires for anymore
+// called with MetricsMu held
+func (a *App) evaluateAlertRules() {
+	e := a.AlertEngine
+	if e == nil {
+		return
+	}
+	now := e.clock.Now()
+
+	firing := make(map[alertTarget]Alert)
+	types := make(map[string]struct{})
+	for _, r := range e.rules {
+		types[r.Alert] = struct{}{}
+		switch r.Scope {
+		case alertScopeGlobal:
+			if a.Windows != nil {
+				e.check(r, a.Windows, alertTarget{alert: r.Alert}, now, firing, false)
+			}
+		case alertScopeDyno:
+			for dyno, w := range e.dynos {
+				e.check(r, w, alertTarget{alert: r.Alert, dyno: dyno}, now, firing, true)
+			}
+		case alertScopeEndpoint:
+			for route, w := range e.endpoints {
+				e.check(r, w, alertTarget{alert: r.Alert, endpoint: route}, now, firing, true)
+			}
+		}
+	}
+
+	for target, alert := range firing {
+		a.upsertAlert(alert, func(existing Alert) bool {
+			return existing.Type == target.alert && existing.Dyno == target.dyno && existing.Endpoint == target.endpoint
+		})
+	}
+	a.resolveAlert(func(existing Alert) bool {
+		if _, ours := types[existing.Type]; !ours {
+			return false
+		}
+		_, still := firing[alertTarget{existing.Type, existing.Dyno, existing.Endpoint}]
+		return !still
+	}, now)
+
+	e.prune(now)
+}
+
+func (e *AlertEngine) check(r *AlertRule, w *TimeWindows, target alertTarget, now time.Time, firing map[alertTarget]Alert, scoped bool) {
+	key := r.Name + "|" + target.dyno + "|" + target.endpoint
+	view, _ := w.View(now, r.window)
+	value := r.value(view)
+	if (scoped && view.TotalRequests == 0) || !r.cmp(value, r.Threshold) {
+		delete(e.pending, key)
+		return
+	}
+	since, ok := e.pending[key]
+	if !ok {
+		since = now
+		e.pending[key] = since
+	}
+	if now.Sub(since) < r.hold {
+		return
+	}
+	if current, ok := firing[target]; ok && alertSeverities[current.Severity] >= alertSeverities[r.Severity] {
+		return
+	}
+
+	message := r.Message
+	if message == "" {
+		message = fmt.Sprintf("%s is %.4g, %s %g over %s", r.Metric, value, r.Op, r.Threshold, r.window)
+		if scope := strings.TrimSpace(target.dyno + " " + target.endpoint); scope != "" {
+			message = scope + ": " + message
+		}
+	}
+	firing[target] = Alert{
+		Type:      r.Alert,
+		Dyno:      target.dyno,
+		Endpoint:  target.endpoint,
+		Severity:  r.Severity,
+		Message:   message,
+		Timestamp: now,
+	}
+}
+
+// forgets dynos and routes without traffic for a full maxWindow
+func (e *AlertEngine) prune(now time.Time) {
+	for _, m := range []map[string]*TimeWindows{e.dynos, e.endpoints} {
+		for key, w := range m {
+			if w.Idle(now) {
+				delete(m, key)
+			}
+		}
+	}
+}
+
+// StartAlertEngine evaluates the rules, anomalies, SLOs and the log driven alerts
+// every interval
+func (a *App) StartAlertEngine(interval time.Duration) {
+	if interval <= 0 {
+		interval = defaultAlertEvalInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		a.MetricsMu.Lock()
+		if a.Metric != nil {
+			now := time.Now()
+			a.evaluateAlertRules()
+			a.evaluateAnomalies(now)
+			a.evaluateSLOs(now)
+			a.evaluateLogAlerts(now)
+		}
+		a.MetricsMu.Unlock()
+	}
+}
@@ -0,0 +1,212 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) Advance(d time.Duration) { c.now = c.now.Add(d) }
+
+// app with the given rules on a fake clock, in the middle of a window bucket
+func createAlertRulesTestApp(t *testing.T, rules ...AlertRuleConfig) (*App, *fakeClock) {
+	t.Helper()
+	compiled, err := CompileAlertRules(&AlertRulesFile{Rules: rules})
+	if err != nil {
+		t.Fatalf("Failed to compile rules: %v", err)
+	}
+	clock := &fakeClock{now: time.Date(2025, 7, 19, 12, 0, 5, 0, time.UTC)}
+	app := createTestAppForMetrics()
+	app.Metric = &Metric{ActiveAlerts: []Alert{}}
+	app.Windows = NewTimeWindows()
+	app.AlertEngine = NewAlertEngine(compiled, clock)
+	return app, clock
+}
+
+// records n router lines at the fake time
+func recordAlertTraffic(app *App, clock *fakeClock, n int, l ParsedLog) {
+	for i := 0; i < n; i++ {
+		app.Windows.Record(clock.Now(), &l)
+		app.AlertEngine.Record(clock.Now(), l.Path, &l)
+	}
+}
+
+func findRuleAlert(alerts []Alert, alertType, dyno, endpoint string) *Alert {
+	for i := range alerts {
+		if alerts[i].Type == alertType && alerts[i].Dyno == dyno && alerts[i].Endpoint == endpoint && !alerts[i].Resolved {
+			return &alerts[i]
+		}
+	}
+	return nil
+}
+
+func TestCompileAlertRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rule    AlertRuleConfig
+		wantErr string
+	}{
+		{"minimal", AlertRuleConfig{Name: "a", Metric: "error_rate", Threshold: 5, Severity: "warning"}, ""},
+		{"full", AlertRuleConfig{Name: "a", Metric: "p95_response_ms", Scope: "dyno", Window: "1m", Op: "<=", For: "2m", Severity: "critical"}, ""},
+		{"no name", AlertRuleConfig{Metric: "error_rate", Severity: "warning"}, "without a name"},
+		{"unknown metric", AlertRuleConfig{Name: "a", Metric: "cpu", Severity: "warning"}, "unknown metric"},
+		{"unknown op", AlertRuleConfig{Name: "a", Metric: "error_rate", Op: "!=", Severity: "warning"}, "unknown op"},
+		{"severity", AlertRuleConfig{Name: "a", Metric: "error_rate", Severity: "page"}, "severity"},
+		{"scope", AlertRuleConfig{Name: "a", Metric: "error_rate", Scope: "region", Severity: "warning"}, "scope"},
+		{"window", AlertRuleConfig{Name: "a", Metric: "error_rate", Window: "2h", Severity: "warning"}, "window"},
+		{"for", AlertRuleConfig{Name: "a", Metric: "error_rate", For: "soon", Severity: "warning"}, "bad for"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileAlertRules(&AlertRulesFile{Rules: []AlertRuleConfig{tt.rule}})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+
+	t.Run("duplicate", func(t *testing.T) {
+		rule := AlertRuleConfig{Name: "a", Metric: "error_rate", Severity: "warning"}
+		_, err := CompileAlertRules(&AlertRulesFile{Rules: []AlertRuleConfig{rule, rule}})
+		if err == nil || !strings.Contains(err.Error(), "duplicate") {
+			t.Errorf("Expected a duplicate error, got %v", err)
+		}
+	})
+}
+
+func TestLoadAlertRules(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "alerts.json")
+	os.WriteFile(p, []byte(`{"rules": [{"name": "slow_dyno", "metric": "p95_response_ms", "scope": "dyno", "threshold": 1500, "for": "2m", "severity": "warning"}]}`), 0o644)
+
+	rules, err := LoadAlertRules(p)
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	if len(rules) != 1 || rules[0].hold != 2*time.Minute || rules[0].window != defaultAlertRuleWindow || rules[0].Alert != "slow_dyno" {
+		t.Errorf("Unexpected rules %+v", rules[0])
+	}
+}
+
+func TestApp_EvaluateAlertRules_Defaults(t *testing.T) {
+	app, clock := createAlertRulesTestApp(t, defaultAlertRules...)
+
+	// 1 in 10 failing, warning only
+	recordAlertTraffic(app, clock, 9, ParsedLog{Status: 200, ResponseTime: 50 * time.Millisecond})
+	recordAlertTraffic(app, clock, 1, ParsedLog{Status: 500, ResponseTime: 50 * time.Millisecond})
+	app.evaluateAlertRules()
+
+	alert := findRuleAlert(app.Metric.ActiveAlerts, "high_error_rate", "", "")
+	if alert == nil || alert.Severity != "warning" || alert.Message != "Error rate is above 5%" {
+		t.Fatalf("Expected a warning error rate alert, got %+v", app.Metric.ActiveAlerts)
+	}
+
+	// escalates in place once the rate passes 10%
+	recordAlertTraffic(app, clock, 2, ParsedLog{Status: 503, ResponseTime: 50 * time.Millisecond})
+	app.evaluateAlertRules()
+	if alert := findRuleAlert(app.Metric.ActiveAlerts, "high_error_rate", "", ""); alert == nil || alert.Severity != "critical" {
+		t.Errorf("Expected the alert escalated to critical, got %+v", alert)
+	}
+	if len(app.Metric.ActiveAlerts) != 1 {
+		t.Errorf("Expected one error rate alert, got %+v", app.Metric.ActiveAlerts)
+	}
+
+	// the errors leave the 5m window, the alert holds for alertResolveAfter
+	clock.Advance(10 * time.Minute)
+	recordAlertTraffic(app, clock, 10, ParsedLog{Status: 200, ResponseTime: 50 * time.Millisecond})
+	app.evaluateAlertRules()
+	if findRuleAlert(app.Metric.ActiveAlerts, "high_error_rate", "", "") == nil {
+		t.Fatal("Expected the alert to hold right after clearing")
+	}
+	clock.Advance(defaultAlertResolveAfter)
+	app.evaluateAlertRules()
+	if alert := findRuleAlert(app.Metric.ActiveAlerts, "high_error_rate", "", ""); alert != nil {
+		t.Errorf("Expected the alert resolved, got %+v", alert)
+	}
+	if len(app.Metric.ActiveAlerts) != 1 || !app.Metric.ActiveAlerts[0].Resolved || !app.Metric.ActiveAlerts[0].Timestamp.Equal(clock.Now()) {
+		t.Errorf("Expected the resolved alert kept at the fake time, got %+v", app.Metric.ActiveAlerts)
+	}
+}
+
+func TestApp_EvaluateAlertRules_For(t *testing.T) {
+	app, clock := createAlertRulesTestApp(t, AlertRuleConfig{
+		Name: "slow_dyno", Metric: "p95_response_ms", Scope: "dyno", Window: "1m",
+		Threshold: 1000, For: "2m", Severity: "warning",
+	})
+	slow := ParsedLog{Status: 200, SourceDyno: "web.1", ResponseTime: 3 * time.Second}
+	fast := ParsedLog{Status: 200, SourceDyno: "web.2", ResponseTime: 10 * time.Millisecond}
+
+	for i := 0; i < 3; i++ {
+		recordAlertTraffic(app, clock, 5, slow)
+		recordAlertTraffic(app, clock, 5, fast)
+		app.evaluateAlertRules()
+		if i < 2 && len(app.Metric.ActiveAlerts) != 0 {
+			t.Fatalf("Expected the rule pending after %d minutes, got %+v", i, app.Metric.ActiveAlerts)
+		}
+		if i < 2 {
+			clock.Advance(time.Minute)
+		}
+	}
+
+	alert := findRuleAlert(app.Metric.ActiveAlerts, "slow_dyno", "web.1", "")
+	if alert == nil || !strings.HasPrefix(alert.Message, "web.1: p95_response_ms is ") {
+		t.Fatalf("Expected a slow_dyno alert for web.1, got %+v", app.Metric.ActiveAlerts)
+	}
+	if findRuleAlert(app.Metric.ActiveAlerts, "slow_dyno", "web.2", "") != nil {
+		t.Error("Expected no alert for the fast dyno")
+	}
+
+	// a dyno without traffic in the window is not evaluated
+	clock.Advance(2 * time.Minute)
+	app.evaluateAlertRules()
+	clock.Advance(defaultAlertResolveAfter)
+	app.evaluateAlertRules()
+	if findRuleAlert(app.Metric.ActiveAlerts, "slow_dyno", "web.1", "") != nil {
+		t.Error("Expected the alert resolved once web.1 went quiet")
+	}
+
+	// and forgotten after a quiet hour
+	clock.Advance(maxWindow)
+	app.evaluateAlertRules()
+	if len(app.AlertEngine.dynos) != 0 {
+		t.Errorf("Expected idle dynos pruned, got %d", len(app.AlertEngine.dynos))
+	}
+}
+
+func TestApp_EvaluateAlertRules_Endpoint(t *testing.T) {
+	app, clock := createAlertRulesTestApp(t, AlertRuleConfig{
+		Name: "endpoint_errors", Metric: "error_rate", Scope: "endpoint", Threshold: 20, Severity: "critical",
+	})
+	recordAlertTraffic(app, clock, 5, ParsedLog{Status: 200, Path: "/users/:id"})
+	recordAlertTraffic(app, clock, 2, ParsedLog{Status: 500, Path: "/checkout"})
+	recordAlertTraffic(app, clock, 2, ParsedLog{Status: 200, Path: "/checkout"})
+	app.evaluateAlertRules()
+
+	if len(app.Metric.ActiveAlerts) != 1 || findRuleAlert(app.Metric.ActiveAlerts, "endpoint_errors", "", "/checkout") == nil {
+		t.Errorf("Expected one alert for /checkout, got %+v", app.Metric.ActiveAlerts)
+	}
+}
+
+func TestApp_EvaluateAlertRules_LeavesOtherAlerts(t *testing.T) {
+	app, _ := createAlertRulesTestApp(t, defaultAlertRules...)
+	app.Metric.ActiveAlerts = []Alert{{Type: "dynoDown", Dyno: "web.1", Severity: "critical"}}
+
+	app.evaluateAlertRules()
+	if app.Metric.ActiveAlerts[0].Resolved {
+		t.Error("Expected alerts of other types untouched")
+	}
+}
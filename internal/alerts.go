@@ -0,0 +1,316 @@
+package internal
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Alert lifecycle. An alert keeps its ID from the first fire until it resolves,
+// a cleared condition only resolves it after holding for AlertResolveAfter so a
+// metric hovering around its threshold does not flap. Every fire, escalation,
+// resolution and ack is sent to AlertChan and upserted into alert_history by
+// the DbWriter.
+
+const (
+	defaultAlertResolveAfter = 1 * time.Minute
+	defaultAlertHistoryLimit = 50
+	maxAlertHistoryLimit     = 1000
+)
+
+func newAlertID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (a *App) alertResolveAfter() time.Duration {
+	if a.Config != nil && a.Config.AlertResolveAfter > 0 {
+		return a.Config.AlertResolveAfter
+	}
+	return defaultAlertResolveAfter
+}
+
+// hands a lifecycle change to the DbWriter, dropped rather than blocking the aggregator
+func (a *App) persistAlert(alert Alert) {
+	select {
+	case a.AlertChan <- alert:
+	default:
+		if a.AlertChan != nil {
+			log.Printf("Alert channel full, dropped history for alert %s", alert.ID)
+		}
+	}
+}
+
+// Silence mutes the alerts it matches until EndsAt, empty matchers match any
+type Silence struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type,omitempty"`
+	Code      string    `json:"code,omitempty"`
+	Dyno      string    `json:"dyno,omitempty"`
+	Database  string    `json:"database,omitempty"`
+	SLO       string    `json:"slo,omitempty"`
+	Endpoint  string    `json:"endpoint,omitempty"`
+	Metric    string    `json:"metric,omitempty"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	EndsAt    time.Time `json:"ends_at"`
+}
+
+func (s Silence) matches(alert Alert) bool {
+	match := func(matcher, v string) bool { return matcher == "" || matcher == v }
+	return match(s.Type, alert.Type) && match(s.Code, alert.Code) && match(s.Dyno, alert.Dyno) &&
+		match(s.Database, alert.Database) && match(s.SLO, alert.SLO) && match(s.Endpoint, alert.Endpoint) &&
+		match(s.Metric, alert.Metric)
+}
+
+// drops expired silences, called with MetricsMu held
+func (a *App) activeSilences(now time.Time) []Silence {
+	active := a.Silences[:0]
+	for _, s := range a.Silences {
+		if now.Before(s.EndsAt) {
+			active = append(active, s)
+		}
+	}
+	a.Silences = active
+	return active
+}
+
+// called with MetricsMu held
+func (a *App) silenced(alert Alert, now time.Time) bool {
+	for _, s := range a.activeSilences(now) {
+		if s.matches(alert) {
+			return true
+		}
+	}
+	return false
+}
+
+const upsertAlertHistory = `INSERT INTO alert_history (id, type, severity, fired_at, resolved_at, acked_at, alert_data)
+VALUES (?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT(id) DO UPDATE SET severity = excluded.severity, resolved_at = excluded.resolved_at,
+	acked_at = excluded.acked_at, alert_data = excluded.alert_data`
+
+func nullIfZero(t time.Time) any {
+	if t.IsZero() {
+		return nil
+	}
+	return t.UTC()
+}
+
+func (a *App) writeAlertToDb(db *sql.DB, alert Alert) error {
+	data, err := json.Marshal(alert)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(upsertAlertHistory,
+		alert.ID, alert.Type, alert.Severity,
+		alert.FiredAt.UTC(), nullIfZero(alert.ResolvedAt), nullIfZero(alert.AckedAt),
+		string(data),
+	)
+	return err
+}
+
+// newest first, alertType empty for all types
+func (a *App) queryAlertHistory(db *sql.DB, alertType string, limit int) ([]Alert, error) {
+	rows, err := db.Query(
+		"SELECT alert_data FROM alert_history WHERE ? = '' OR type = ? ORDER BY fired_at DESC, id LIMIT ?",
+		alertType, alertType, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	alerts := []Alert{}
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		var alert Alert
+		if err := json.Unmarshal([]byte(data), &alert); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
+
+// POST /alerts/{id}/ack with an optional {"by": "name"}
+func (a *App) AckAlertHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		By string `json:"by"`
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil || (len(body) > 0 && json.Unmarshal(body, &req) != nil) {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	id := r.PathValue("id")
+	a.MetricsMu.Lock()
+	var acked *Alert
+	if a.Metric != nil {
+		for i := range a.Metric.ActiveAlerts {
+			if alert := &a.Metric.ActiveAlerts[i]; alert.ID == id {
+				if !alert.Acked {
+					alert.Acked = true
+					alert.AckedAt = time.Now()
+					alert.AckedBy = req.By
+					a.persistAlert(*alert)
+				}
+				copied := *alert
+				acked = &copied
+				break
+			}
+		}
+	}
+	a.MetricsMu.Unlock()
+
+	if acked == nil {
+		writeJSONError(w, http.StatusNotFound, "alert not found")
+		return
+	}
+	if err := json.NewEncoder(w).Encode(acked); err != nil {
+		http.Error(w, "Failed to encode alert", http.StatusInternalServerError)
+	}
+}
+
+// GET /alerts/silences
+func (a *App) ListSilencesHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	a.MetricsMu.Lock()
+	silences := append([]Silence{}, a.activeSilences(time.Now())...)
+	a.MetricsMu.Unlock()
+
+	if err := json.NewEncoder(w).Encode(map[string]any{"silences": silences}); err != nil {
+		http.Error(w, "Failed to encode silences", http.StatusInternalServerError)
+	}
+}
+
+// POST /alerts/silences {"type": "router_error", "code": "H12", "duration": "2h", "comment": "..."}
+func (a *App) CreateSilenceHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Silence
+		Duration string `json:"duration"`
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil || json.Unmarshal(body, &req) != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	d, err := time.ParseDuration(req.Duration)
+	if err != nil || d <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "invalid duration")
+		return
+	}
+
+	now := time.Now()
+	silence := req.Silence
+	silence.ID = newAlertID()
+	silence.CreatedAt = now
+	silence.EndsAt = now.Add(d)
+
+	a.MetricsMu.Lock()
+	a.Silences = append(a.activeSilences(now), silence)
+	if a.Metric != nil {
+		for i := range a.Metric.ActiveAlerts {
+			if alert := &a.Metric.ActiveAlerts[i]; !alert.Resolved && silence.matches(*alert) {
+				alert.Silenced = true
+			}
+		}
+	}
+	a.MetricsMu.Unlock()
+
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(silence); err != nil {
+		http.Error(w, "Failed to encode silence", http.StatusInternalServerError)
+	}
+}
+
+// DELETE /alerts/silences/{id}
+func (a *App) DeleteSilenceHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	id := r.PathValue("id")
+	now := time.Now()
+	a.MetricsMu.Lock()
+	found := false
+	silences := a.activeSilences(now)
+	for i, s := range silences {
+		if s.ID == id {
+			a.Silences = append(silences[:i], silences[i+1:]...)
+			found = true
+			break
+		}
+	}
+	if found && a.Metric != nil {
+		for i := range a.Metric.ActiveAlerts {
+			alert := &a.Metric.ActiveAlerts[i]
+			alert.Silenced = !alert.Resolved && a.silenced(*alert, now)
+		}
+	}
+	a.MetricsMu.Unlock()
+
+	if !found {
+		writeJSONError(w, http.StatusNotFound, "silence not found")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /alerts/history?type=router_error&limit=50
+func (a *App) AlertHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.LogDb == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "database unavailable")
+		return
+	}
+	limit := defaultAlertHistoryLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxAlertHistoryLimit {
+			writeJSONError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	alerts, err := a.queryAlertHistory(a.LogDb, r.URL.Query().Get("type"), limit)
+	if err != nil {
+		log.Printf("Failed to query alert history: %v", err)
+		http.Error(w, "Failed to query alert history", http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(map[string]any{"alerts": alerts}); err != nil {
+		http.Error(w, "Failed to encode alerts", http.StatusInternalServerError)
+	}
+}
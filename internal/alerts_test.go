@@ -0,0 +1,294 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createAlertsTestApp() *App {
+	app := createTestAppForMetrics()
+	app.Metric = &Metric{ActiveAlerts: []Alert{}}
+	app.AlertChan = make(chan Alert, 10)
+	app.RateLimiter = NewRateLimiterMap(100, 10)
+	return app
+}
+
+func drainAlerts(ch chan Alert) []Alert {
+	var alerts []Alert
+	for {
+		select {
+		case alert := <-ch:
+			alerts = append(alerts, alert)
+		default:
+			return alerts
+		}
+	}
+}
+
+func TestApp_AlertLifecycle(t *testing.T) {
+	app := createAlertsTestApp()
+	now := time.Date(2025, 7, 19, 12, 0, 0, 0, time.UTC)
+	same := func(e Alert) bool { return e.Type == "router_error" && e.Code == "H12" }
+	raise := func(severity string, at time.Time) {
+		app.raiseAlert(Alert{Type: "router_error", Code: "H12", Severity: severity, Timestamp: at})
+	}
+
+	raise("warning", now)
+	first := app.Metric.ActiveAlerts[0]
+	if first.ID == "" || !first.FiredAt.Equal(now) {
+		t.Fatalf("Expected an ID and fire time, got %+v", first)
+	}
+
+	// updates keep the identity, only an escalation is persisted
+	app.Metric.ActiveAlerts[0].Acked = true
+	raise("warning", now.Add(time.Minute))
+	raise("critical", now.Add(2*time.Minute))
+	updated := app.Metric.ActiveAlerts[0]
+	if updated.ID != first.ID || !updated.FiredAt.Equal(now) || !updated.Acked {
+		t.Errorf("Expected ID, fire time and ack kept, got %+v", updated)
+	}
+	if persisted := drainAlerts(app.AlertChan); len(persisted) != 2 || persisted[1].Severity != "critical" {
+		t.Errorf("Expected the fire and the escalation persisted, got %+v", persisted)
+	}
+
+	// hysteresis, a condition flapping back resets the clock
+	clear := now.Add(3 * time.Minute)
+	app.resolveAlert(same, clear)
+	raise("critical", clear.Add(30*time.Second))
+	app.resolveAlert(same, clear.Add(time.Minute))
+	if app.Metric.ActiveAlerts[0].Resolved {
+		t.Fatal("Expected the alert to hold after flapping")
+	}
+	app.resolveAlert(same, clear.Add(2*time.Minute))
+	resolved := app.Metric.ActiveAlerts[0]
+	if !resolved.Resolved || !resolved.ResolvedAt.Equal(clear.Add(2*time.Minute)) {
+		t.Errorf("Expected the alert resolved a minute after clearing, got %+v", resolved)
+	}
+	if persisted := drainAlerts(app.AlertChan); len(persisted) != 1 || !persisted[0].Resolved {
+		t.Errorf("Expected the resolution persisted, got %+v", persisted)
+	}
+
+	// the next fire is a new alert
+	raise("warning", clear.Add(3*time.Minute))
+	if len(app.Metric.ActiveAlerts) != 2 || app.Metric.ActiveAlerts[1].ID == first.ID {
+		t.Errorf("Expected a new alert after resolution, got %+v", app.Metric.ActiveAlerts)
+	}
+}
+
+func TestApp_AlertResolutionPerGenerator(t *testing.T) {
+	app := createAlertsTestApp()
+	app.Config = &Config{AlertResolveAfter: time.Nanosecond}
+	now := time.Now()
+
+	app.Metric.RecentRouterErrors = map[string]int64{"H12": routerErrorWarning}
+	app.Metric.DatabaseHealth = map[string]DatabaseHealth{"DATABASE": {ConnectionUsage: 95, MemoryUsage: 95}}
+	app.Metric.DynoPerformance = map[string]DynoMetric{"web.1": {LastMemoryError: "R14", LastMemoryErrorAt: now}}
+	app.generateAlerts()
+	if len(app.Metric.ActiveAlerts) != 4 {
+		t.Fatalf("Expected 4 alerts, got %+v", app.Metric.ActiveAlerts)
+	}
+
+	app.Metric.RecentRouterErrors = map[string]int64{}
+	app.Metric.DatabaseHealth = map[string]DatabaseHealth{"DATABASE": {}}
+	app.Metric.DynoPerformance = map[string]DynoMetric{"web.1": {}}
+	app.generateAlerts()
+	time.Sleep(time.Millisecond)
+	app.generateAlerts()
+	for _, alert := range app.Metric.ActiveAlerts {
+		if !alert.Resolved {
+			t.Errorf("Expected %s resolved once the condition cleared", alert.Type)
+		}
+	}
+}
+
+func TestApp_EvaluateLogAlerts_WithoutTraffic(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.StartMetricsAggregator()
+	for i := 0; i < routerErrorWarning; i++ {
+		l := createTestParsedLog(503, "GET", "/", "", "web.1", 30*time.Second, true)
+		l.ErrorCode = "H12"
+		app.MetricChan <- l
+	}
+	time.Sleep(100 * time.Millisecond)
+	if alert := findAlert(app.GetMetricsSnapshot().ActiveAlerts, "router_error", ""); alert == nil {
+		t.Fatal("Expected a router_error alert")
+	}
+
+	// no further lines, only the alert engine tick
+	quiet := time.Now().Add(2 * routerErrorWindow)
+	app.MetricsMu.Lock()
+	app.evaluateLogAlerts(quiet)
+	app.evaluateLogAlerts(quiet.Add(defaultAlertResolveAfter))
+	app.MetricsMu.Unlock()
+
+	snapshot := app.GetMetricsSnapshot()
+	if len(snapshot.RecentRouterErrors) != 0 {
+		t.Errorf("Expected the recent errors aged out, got %v", snapshot.RecentRouterErrors)
+	}
+	if alert := findAlert(snapshot.ActiveAlerts, "router_error", ""); alert == nil || !alert.Resolved {
+		t.Errorf("Expected the router_error alert resolved, got %+v", alert)
+	}
+}
+
+func TestApp_AckAlertHandler(t *testing.T) {
+	app := createAlertsTestApp()
+	app.raiseAlert(Alert{Type: "dynoDown", Dyno: "web.1", Severity: "critical", Timestamp: time.Now()})
+	id := app.Metric.ActiveAlerts[0].ID
+	drainAlerts(app.AlertChan)
+
+	ack := func(id, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/alerts/"+id+"/ack", strings.NewReader(body))
+		r.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		app.AckAlertHandler(w, r)
+		return w
+	}
+
+	w := ack(id, `{"by": "oncall"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var alert Alert
+	json.NewDecoder(w.Body).Decode(&alert)
+	if !alert.Acked || alert.AckedBy != "oncall" || alert.AckedAt.IsZero() {
+		t.Errorf("Expected the alert acked by oncall, got %+v", alert)
+	}
+	if persisted := drainAlerts(app.AlertChan); len(persisted) != 1 || !persisted[0].Acked {
+		t.Errorf("Expected the ack persisted, got %+v", persisted)
+	}
+
+	if w := ack(id, ""); w.Code != http.StatusOK {
+		t.Errorf("Expected an empty body to be accepted, got %d", w.Code)
+	}
+	if w := ack("nope", ""); w.Code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", w.Code)
+	}
+	if w := ack(id, "{"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestApp_SilenceHandlers(t *testing.T) {
+	app := createAlertsTestApp()
+	app.raiseAlert(Alert{Type: "router_error", Code: "H12", Severity: "warning", Timestamp: time.Now()})
+	app.raiseAlert(Alert{Type: "router_error", Code: "H10", Severity: "warning", Timestamp: time.Now()})
+
+	w := httptest.NewRecorder()
+	app.CreateSilenceHandler(w, httptest.NewRequest(http.MethodPost, "/alerts/silences",
+		strings.NewReader(`{"type": "router_error", "code": "H12", "duration": "2h", "comment": "known issue"}`)))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("Expected status 201, got %d", w.Code)
+	}
+	var silence Silence
+	json.NewDecoder(w.Body).Decode(&silence)
+	if silence.ID == "" || silence.Code != "H12" || silence.EndsAt.Sub(silence.CreatedAt) != 2*time.Hour {
+		t.Errorf("Unexpected silence %+v", silence)
+	}
+	if !app.Metric.ActiveAlerts[0].Silenced || app.Metric.ActiveAlerts[1].Silenced {
+		t.Errorf("Expected only H12 silenced, got %+v", app.Metric.ActiveAlerts)
+	}
+
+	// updates of a silenced alert stay silenced
+	app.raiseAlert(Alert{Type: "router_error", Code: "H12", Severity: "critical", Timestamp: time.Now()})
+	if !app.Metric.ActiveAlerts[0].Silenced {
+		t.Error("Expected the escalated alert to stay silenced")
+	}
+
+	w = httptest.NewRecorder()
+	app.ListSilencesHandler(w, httptest.NewRequest(http.MethodGet, "/alerts/silences", nil))
+	var list struct {
+		Silences []Silence `json:"silences"`
+	}
+	json.NewDecoder(w.Body).Decode(&list)
+	if len(list.Silences) != 1 || list.Silences[0].ID != silence.ID {
+		t.Errorf("Expected the silence listed, got %+v", list)
+	}
+
+	del := func(id string) int {
+		r := httptest.NewRequest(http.MethodDelete, "/alerts/silences/"+id, nil)
+		r.SetPathValue("id", id)
+		w := httptest.NewRecorder()
+		app.DeleteSilenceHandler(w, r)
+		return w.Code
+	}
+	if code := del(silence.ID); code != http.StatusNoContent {
+		t.Errorf("Expected status 204, got %d", code)
+	}
+	if app.Metric.ActiveAlerts[0].Silenced {
+		t.Error("Expected the alert unsilenced with its silence deleted")
+	}
+	if code := del(silence.ID); code != http.StatusNotFound {
+		t.Errorf("Expected status 404, got %d", code)
+	}
+
+	for _, body := range []string{`{"type": "x"}`, `{"duration": "-1h"}`, `nope`} {
+		w := httptest.NewRecorder()
+		app.CreateSilenceHandler(w, httptest.NewRequest(http.MethodPost, "/alerts/silences", strings.NewReader(body)))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status 400, got %d", body, w.Code)
+		}
+	}
+}
+
+func TestApp_ExpiredSilences(t *testing.T) {
+	app := createAlertsTestApp()
+	now := time.Now()
+	app.Silences = []Silence{{ID: "old", Type: "dynoDown", EndsAt: now.Add(-time.Minute)}}
+
+	if app.silenced(Alert{Type: "dynoDown"}, now) {
+		t.Error("Expected an expired silence not to match")
+	}
+	if len(app.Silences) != 0 {
+		t.Errorf("Expected the expired silence dropped, got %+v", app.Silences)
+	}
+}
+
+func TestApp_AlertHistory(t *testing.T) {
+	app := createQueryTestApp(t)
+	now := time.Now()
+
+	fired := Alert{ID: "a1", Type: "dynoDown", Dyno: "web.1", Severity: "critical", FiredAt: now.Add(-time.Hour), Timestamp: now.Add(-time.Hour)}
+	if err := app.writeAlertToDb(app.LogDb, fired); err != nil {
+		t.Fatalf("Failed to write alert: %v", err)
+	}
+	resolved := fired
+	resolved.Resolved, resolved.ResolvedAt = true, now
+	if err := app.writeAlertToDb(app.LogDb, resolved); err != nil {
+		t.Fatalf("Failed to update alert: %v", err)
+	}
+	other := Alert{ID: "a2", Type: "router_error", Code: "H12", Severity: "warning", FiredAt: now, Timestamp: now}
+	if err := app.writeAlertToDb(app.LogDb, other); err != nil {
+		t.Fatalf("Failed to write alert: %v", err)
+	}
+
+	alerts, err := app.queryAlertHistory(app.LogDb, "", 10)
+	if err != nil {
+		t.Fatalf("Failed to query history: %v", err)
+	}
+	if len(alerts) != 2 || alerts[0].ID != "a2" || alerts[1].ID != "a1" || !alerts[1].Resolved {
+		t.Errorf("Expected both alerts newest first with a1 resolved, got %+v", alerts)
+	}
+
+	w := httptest.NewRecorder()
+	app.AlertHistoryHandler(w, httptest.NewRequest(http.MethodGet, "/alerts/history?type=dynoDown", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	var resp struct {
+		Alerts []Alert `json:"alerts"`
+	}
+	json.NewDecoder(w.Body).Decode(&resp)
+	if len(resp.Alerts) != 1 || resp.Alerts[0].ID != "a1" {
+		t.Errorf("Expected only the dynoDown alert, got %+v", resp.Alerts)
+	}
+
+	w = httptest.NewRecorder()
+	app.AlertHistoryHandler(w, httptest.NewRequest(http.MethodGet, "/alerts/history?limit=0", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
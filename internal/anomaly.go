@@ -0,0 +1,375 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Anomaly detection on traffic, errors and latency. Every series, globally and
+// per dyno, keeps an EWMA baseline of the recent minutes and one per hour of
+// the week (UTC) so a quiet Sunday night is not compared against Monday noon.
+// The seasonal baselines are seeded from the stored snapshots in the
+// background at startup and keep learning once a minute, those of a dyno gone
+// for anomalyDynoIdle are dropped. A value further than AnomalyThreshold standard
+// deviations from its baseline for anomalyHold raises an "anomaly" alert,
+// twice that is critical.
+
+const (
+	defaultAnomalyThreshold = 3.0
+	defaultAnomalyHistory   = 28 * 24 * time.Hour
+	anomalyWindow           = 5 * time.Minute // the "5m" of the snapshots
+	anomalyObserveEvery     = 1 * time.Minute // snapshots are written as often
+	anomalyHold             = 1 * time.Minute
+	anomalyDynoIdle         = 24 * time.Hour // one-off run.N dynos never come back
+	anomalyMinRequests      = 10             // in the window, for rates and latency
+
+	anomalyRecentAlpha   = 0.1  // ~10 minutes of memory
+	anomalySeasonalAlpha = 0.05 // ~20 minutes of the same hour, over past weeks
+	minRecentSamples     = 10
+	minSeasonalSamples   = 30
+
+	hoursPerWeek = 7 * 24
+)
+
+type anomalyMetric struct {
+	minStd      float64 // deviations below this never count
+	drops       bool    // a drop is as interesting as a spike
+	minRequests int64
+}
+
+// the metrics watched, named like the alert rule metrics
+var anomalyMetrics = map[string]anomalyMetric{
+	"requests_per_second": {0.05, true, 0},
+	"error_rate":          {0.5, false, anomalyMinRequests},
+	"p95_response_ms":     {10, false, anomalyMinRequests},
+}
+
+// exponentially weighted mean and variance
+type ewma struct {
+	mean     float64
+	variance float64
+	n        int
+}
+
+func (e *ewma) add(x, alpha float64) {
+	if e.n == 0 {
+		e.mean = x
+	} else {
+		diff := x - e.mean
+		incr := alpha * diff
+		e.mean += incr
+		e.variance = (1 - alpha) * (e.variance + diff*incr)
+	}
+	e.n++
+}
+
+type anomalyBaseline struct {
+	recent   ewma
+	seasonal [hoursPerWeek]ewma
+	seenAt   time.Time
+}
+
+func hourOfWeek(t time.Time) int {
+	t = t.UTC()
+	return int(t.Weekday())*24 + t.Hour()
+}
+
+func (b *anomalyBaseline) observe(at time.Time, x float64) {
+	b.recent.add(x, anomalyRecentAlpha)
+	b.seasonal[hourOfWeek(at)].add(x, anomalySeasonalAlpha)
+	if at.After(b.seenAt) {
+		b.seenAt = at
+	}
+}
+
+// the seasonal baseline of at once learned, the recent one until then
+func (b *anomalyBaseline) expected(at time.Time) (ewma, bool) {
+	if slot := b.seasonal[hourOfWeek(at)]; slot.n >= minSeasonalSamples {
+		return slot, true
+	}
+	return b.recent, b.recent.n >= minRecentSamples
+}
+
+type anomalySeries struct {
+	metric, dyno string
+}
+
+// AnomalyDetector is written by the aggregator and the evaluation loop, both under MetricsMu
+type AnomalyDetector struct {
+	threshold  float64
+	baselines  map[anomalySeries]*anomalyBaseline
+	dynos      map[string]*TimeWindows
+	pending    map[anomalySeries]time.Time // deviating since
+	observedAt time.Time
+}
+
+func NewAnomalyDetector(threshold float64) *AnomalyDetector {
+	if threshold <= 0 {
+		threshold = defaultAnomalyThreshold
+	}
+	return &AnomalyDetector{
+		threshold: threshold,
+		baselines: make(map[anomalySeries]*anomalyBaseline),
+		dynos:     make(map[string]*TimeWindows),
+		pending:   make(map[anomalySeries]time.Time),
+	}
+}
+
+// Record adds a router line to the per dyno windows
+func (d *AnomalyDetector) Record(at time.Time, l *ParsedLog) {
+	if d == nil || l.SourceDyno == "" {
+		return
+	}
+	scopedWindows(d.dynos, l.SourceDyno).Record(at, l)
+}
+
+func (d *AnomalyDetector) baseline(s anomalySeries) *anomalyBaseline {
+	b, ok := d.baselines[s]
+	if !ok {
+		b = &anomalyBaseline{}
+		d.baselines[s] = b
+	}
+	return b
+}
+
+// drops the baselines of dynos not seen for anomalyDynoIdle
+func (d *AnomalyDetector) pruneBaselines(now time.Time) {
+	for s, b := range d.baselines {
+		if s.dyno != "" && now.Sub(b.seenAt) > anomalyDynoIdle {
+			delete(d.baselines, s)
+			delete(d.pending, s)
+		}
+	}
+}
+
+// the current value of every series with enough traffic
+func (d *AnomalyDetector) values(global *TimeWindows, now time.Time) map[anomalySeries]float64 {
+	values := make(map[anomalySeries]float64)
+	add := func(dyno string, w *TimeWindows) {
+		view, _ := w.View(now, anomalyWindow)
+		for name, m := range anomalyMetrics {
+			if view.TotalRequests >= m.minRequests {
+				values[anomalySeries{name, dyno}] = alertRuleMetrics[name](view)
+			}
+		}
+	}
+	if global != nil {
+		add("", global)
+	}
+	for dyno, w := range d.dynos {
+		add(dyno, w)
+	}
+	return values
+}
+
+// evaluateAnomalies raises and resolves the anomaly alerts, then lets the
+// baselines learn the current values
+// called with MetricsMu held
+func (a *App) evaluateAnomalies(now time.Time) {
+	d := a.Anomalies
+	if d == nil {
+		return
+	}
+	values := d.values(a.Windows, now)
+
+	firing := make(map[anomalySeries]Alert)
+	for s, value := range values {
+		if alert, ok := d.check(s, value, now); ok {
+			firing[s] = alert
+		}
+	}
+
+	for s, alert := range firing {
+		a.upsertAlert(alert, func(existing Alert) bool {
+			return existing.Type == "anomaly" && existing.Metric == s.metric && existing.Dyno == s.dyno
+		})
+	}
+	a.resolveAlert(func(existing Alert) bool {
+		if existing.Type != "anomaly" {
+			return false
+		}
+		_, still := firing[anomalySeries{existing.Metric, existing.Dyno}]
+		return !still
+	}, now)
+
+	// outliers are clamped to the threshold so an incident only nudges the
+	// baselines, a lasting shift is still learned over time
+	if now.Sub(d.observedAt) >= anomalyObserveEvery {
+		for s, value := range values {
+			if mean, std, ok := d.expected(s, now); ok {
+				value = math.Max(mean-d.threshold*std, math.Min(value, mean+d.threshold*std))
+			}
+			d.baseline(s).observe(now, value)
+		}
+		d.observedAt = now
+	}
+	for dyno, w := range d.dynos {
+		if w.Idle(now) {
+			delete(d.dynos, dyno)
+		}
+	}
+	d.pruneBaselines(now)
+}
+
+// mean and standard deviation expected at now, the deviation floored per metric
+func (d *AnomalyDetector) expected(s anomalySeries, now time.Time) (float64, float64, bool) {
+	b, ok := d.baselines[s]
+	if !ok {
+		return 0, 0, false
+	}
+	e, ready := b.expected(now)
+	std := math.Max(math.Sqrt(e.variance), math.Max(anomalyMetrics[s.metric].minStd, 0.05*math.Abs(e.mean)))
+	return e.mean, std, ready
+}
+
+func (d *AnomalyDetector) check(s anomalySeries, value float64, now time.Time) (Alert, bool) {
+	mean, std, ok := d.expected(s, now)
+	if !ok {
+		delete(d.pending, s)
+		return Alert{}, false
+	}
+
+	m := anomalyMetrics[s.metric]
+	z := (value - mean) / std
+	if z < d.threshold && (!m.drops || z > -d.threshold) {
+		delete(d.pending, s)
+		return Alert{}, false
+	}
+	since, ok := d.pending[s]
+	if !ok {
+		since = now
+		d.pending[s] = since
+	}
+	if now.Sub(since) < anomalyHold {
+		return Alert{}, false
+	}
+
+	severity := "warning"
+	if math.Abs(z) >= 2*d.threshold {
+		severity = "critical"
+	}
+	message := fmt.Sprintf("%s is %.4g, expected %.4g ± %.2g (%+.1f σ)", s.metric, value, mean, std, z)
+	if s.dyno != "" {
+		message = s.dyno + ": " + message
+	}
+	return Alert{
+		Type:      "anomaly",
+		Metric:    s.metric,
+		Dyno:      s.dyno,
+		Severity:  severity,
+		Message:   message,
+		Timestamp: now,
+	}, true
+}
+
+// LoadHistory seeds the baselines from the snapshots since since, streamed
+// oldest first, and returns how many were learned
+func (d *AnomalyDetector) LoadHistory(db *sql.DB, since time.Time) (int, error) {
+	rows, err := db.Query(
+		"SELECT snapshot_time, metrics_data FROM metric_snapshots WHERE snapshot_time >= ? ORDER BY id",
+		since.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	n := 0
+	var prev *Metric
+	for rows.Next() {
+		var at time.Time
+		var data string
+		if err := rows.Scan(&at, &data); err != nil {
+			return n, err
+		}
+		var m Metric
+		if err := json.Unmarshal([]byte(data), &m); err != nil {
+			continue
+		}
+		m.Timestamp = at
+		d.learnSnapshot(&m, prev)
+		d.pruneBaselines(at)
+		prev = &m
+		n++
+	}
+	if err := rows.Err(); err != nil {
+		return n, err
+	}
+	if prev != nil {
+		d.observedAt = prev.Timestamp
+	}
+	return n, nil
+}
+
+// LoadAnomalyHistory learns the history into a detector of its own, then
+// hands its seasonal baselines to the live one, which keeps learning meanwhile
+func (a *App) LoadAnomalyHistory(db *sql.DB, since time.Time) (int, error) {
+	if a.Anomalies == nil {
+		return 0, nil
+	}
+	seed := NewAnomalyDetector(a.Anomalies.threshold)
+	n, err := seed.LoadHistory(db, since)
+	if err != nil {
+		return n, err
+	}
+
+	a.MetricsMu.Lock()
+	defer a.MetricsMu.Unlock()
+	a.Anomalies.merge(seed)
+	return n, nil
+}
+
+// the history wins the seasonal slots, the live recent baseline wins once ready
+// called with MetricsMu held
+func (d *AnomalyDetector) merge(seed *AnomalyDetector) {
+	for s, b := range seed.baselines {
+		live, ok := d.baselines[s]
+		if !ok {
+			d.baselines[s] = b
+			continue
+		}
+		live.seasonal = b.seasonal
+		if live.recent.n < minRecentSamples {
+			live.recent = b.recent
+		}
+		if b.seenAt.After(live.seenAt) {
+			live.seenAt = b.seenAt
+		}
+	}
+	if seed.observedAt.After(d.observedAt) {
+		d.observedAt = seed.observedAt
+	}
+}
+
+// snapshots carry the global windows and per dyno latency, dyno traffic comes
+// from the request counts of consecutive snapshots
+func (d *AnomalyDetector) learnSnapshot(m, prev *Metric) {
+	if view, ok := m.Windows["5m"]; ok {
+		for name, metric := range anomalyMetrics {
+			if view.TotalRequests >= metric.minRequests {
+				d.baseline(anomalySeries{name, ""}).observe(m.Timestamp, alertRuleMetrics[name](view))
+			}
+		}
+	}
+
+	var elapsed float64
+	if prev != nil {
+		elapsed = m.Timestamp.Sub(prev.Timestamp).Seconds()
+	}
+	for name, dyno := range m.DynoPerformance {
+		if dyno.P95ResponseTime > 0 {
+			d.baseline(anomalySeries{"p95_response_ms", name}).observe(m.Timestamp, ms(dyno.P95ResponseTime))
+		}
+		// a gap or a restart of the counters says nothing about the rate
+		if elapsed <= 0 || elapsed > anomalyWindow.Seconds() {
+			continue
+		}
+		if before, ok := prev.DynoPerformance[name]; ok && dyno.RequestCount >= before.RequestCount {
+			rps := float64(dyno.RequestCount-before.RequestCount) / elapsed
+			d.baseline(anomalySeries{"requests_per_second", name}).observe(m.Timestamp, rps)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package internal
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEWMA(t *testing.T) {
+	var e ewma
+	for i := 0; i < 200; i++ {
+		e.add(float64(10+i%2*2), 0.1) // 10, 12, 10, ...
+	}
+	if math.Abs(e.mean-11) > 0.2 || math.Abs(math.Sqrt(e.variance)-1) > 0.2 {
+		t.Errorf("Expected mean ~11 and deviation ~1, got %f and %f", e.mean, math.Sqrt(e.variance))
+	}
+}
+
+func TestAnomalyBaseline_Seasonal(t *testing.T) {
+	var b anomalyBaseline
+	monday := time.Date(2025, 7, 14, 12, 0, 0, 0, time.UTC)
+	sunday := time.Date(2025, 7, 13, 3, 0, 0, 0, time.UTC)
+
+	// a busy hour and a quiet one, learned over past weeks
+	for i := 0; i < minSeasonalSamples; i++ {
+		b.observe(monday.AddDate(0, 0, -7*(i%4)), 100)
+		b.observe(sunday.AddDate(0, 0, -7*(i%4)), 5)
+	}
+	if e, ok := b.expected(monday.AddDate(0, 0, 7)); !ok || math.Abs(e.mean-100) > 1e-9 {
+		t.Errorf("Expected the Monday noon baseline, got %+v", e)
+	}
+	if e, ok := b.expected(sunday.Add(30 * time.Minute)); !ok || math.Abs(e.mean-5) > 1e-9 {
+		t.Errorf("Expected the Sunday night baseline, got %+v", e)
+	}
+
+	// an hour never seen falls back to the recent baseline
+	if e, ok := b.expected(monday.Add(3 * time.Hour)); !ok || e.n != 2*minSeasonalSamples {
+		t.Errorf("Expected the recent baseline, got %+v", e)
+	}
+}
+
+func createAnomalyTestApp() *App {
+	app := createTestAppForMetrics()
+	app.Metric = &Metric{ActiveAlerts: []Alert{}}
+	app.Windows = NewTimeWindows()
+	app.Anomalies = NewAnomalyDetector(3)
+	return app
+}
+
+func recordAnomalyTraffic(app *App, at time.Time, n int, l ParsedLog) {
+	for i := 0; i < n; i++ {
+		app.Windows.Record(at, &l)
+		app.Anomalies.Record(at, &l)
+	}
+}
+
+func TestApp_EvaluateAnomalies(t *testing.T) {
+	app := createAnomalyTestApp()
+	now := time.Date(2025, 7, 19, 12, 0, 5, 0, time.UTC)
+
+	// error rates of 1-2% until now
+	baseline := app.Anomalies.baseline(anomalySeries{"error_rate", ""})
+	for i := 0; i < minRecentSamples; i++ {
+		baseline.observe(now.Add(-time.Hour), float64(1+i%2))
+	}
+
+	recordAnomalyTraffic(app, now, 10, ParsedLog{Status: 200, SourceDyno: "web.1"})
+	recordAnomalyTraffic(app, now, 10, ParsedLog{Status: 500, SourceDyno: "web.1"})
+	app.evaluateAnomalies(now)
+	if len(app.Metric.ActiveAlerts) != 0 {
+		t.Fatalf("Expected the deviation pending, got %+v", app.Metric.ActiveAlerts)
+	}
+
+	now = now.Add(anomalyHold)
+	app.evaluateAnomalies(now)
+	if len(app.Metric.ActiveAlerts) != 1 {
+		t.Fatalf("Expected one anomaly alert, got %+v", app.Metric.ActiveAlerts)
+	}
+	alert := app.Metric.ActiveAlerts[0]
+	if alert.Type != "anomaly" || alert.Metric != "error_rate" || alert.Dyno != "" || alert.Severity != "critical" {
+		t.Errorf("Unexpected alert %+v", alert)
+	}
+	if !strings.HasPrefix(alert.Message, "error_rate is 50, expected") {
+		t.Errorf("Unexpected message %q", alert.Message)
+	}
+
+	// the outliers only nudged the baseline
+	if mean, _, _ := app.Anomalies.expected(anomalySeries{"error_rate", ""}, now); mean > 5 {
+		t.Errorf("Expected the baseline to stay low, got %f", mean)
+	}
+	// web.1 has no history yet
+	if _, _, ok := app.Anomalies.expected(anomalySeries{"error_rate", "web.1"}, now); ok {
+		t.Error("Expected no baseline for web.1 after two observations")
+	}
+
+	// the errors stop
+	now = now.Add(10 * time.Minute)
+	recordAnomalyTraffic(app, now, 20, ParsedLog{Status: 200, SourceDyno: "web.1"})
+	app.evaluateAnomalies(now)
+	app.evaluateAnomalies(now.Add(defaultAlertResolveAfter))
+	if !app.Metric.ActiveAlerts[0].Resolved {
+		t.Errorf("Expected the anomaly resolved, got %+v", app.Metric.ActiveAlerts[0])
+	}
+}
+
+func TestApp_EvaluateAnomalies_TrafficDrop(t *testing.T) {
+	app := createAnomalyTestApp()
+	now := time.Date(2025, 7, 19, 12, 0, 5, 0, time.UTC)
+	baseline := app.Anomalies.baseline(anomalySeries{"requests_per_second", ""})
+	for i := 0; i < minRecentSamples; i++ {
+		baseline.observe(now.Add(-time.Hour), 10)
+	}
+
+	// 0.1 rps over the window instead of 10, a spike in latency alone is not enough traffic
+	recordAnomalyTraffic(app, now, 30, ParsedLog{Status: 200, ResponseTime: 5 * time.Second})
+	app.evaluateAnomalies(now)
+	app.evaluateAnomalies(now.Add(anomalyHold))
+
+	alert := findAlert(app.Metric.ActiveAlerts, "anomaly", "")
+	if alert == nil || alert.Metric != "requests_per_second" || alert.Severity != "critical" {
+		t.Errorf("Expected a traffic drop anomaly, got %+v", app.Metric.ActiveAlerts)
+	}
+	if len(app.Metric.ActiveAlerts) != 1 {
+		t.Errorf("Expected no latency anomaly without a baseline, got %+v", app.Metric.ActiveAlerts)
+	}
+}
+
+func TestAnomalyDetector_LoadHistory(t *testing.T) {
+	app := createQueryTestApp(t)
+	now := time.Now()
+	for i := 60; i > 0; i-- {
+		at := now.Add(-time.Duration(i) * time.Minute)
+		err := app.writeSnapshotToDb(app.LogDb, &Metric{
+			Timestamp: at,
+			Windows: map[string]WindowMetric{
+				"5m": {TotalRequests: 3000, RequestsPerSecond: 10, ErrorRate: 1, P95ResponseTime: 200 * time.Millisecond},
+			},
+			DynoPerformance: map[string]DynoMetric{
+				"web.1": {RequestCount: int64(60-i) * 600, P95ResponseTime: 250 * time.Millisecond},
+			},
+		})
+		if err != nil {
+			t.Fatalf("Failed to write snapshot: %v", err)
+		}
+	}
+	// older than the history
+	app.writeSnapshotToDb(app.LogDb, &Metric{Timestamp: now.Add(-48 * time.Hour)})
+
+	d := NewAnomalyDetector(3)
+	n, err := d.LoadHistory(app.LogDb, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to load history: %v", err)
+	}
+	if n != 60 {
+		t.Errorf("Expected 60 snapshots learned, got %d", n)
+	}
+
+	for _, tt := range []struct {
+		series anomalySeries
+		want   float64
+	}{
+		{anomalySeries{"requests_per_second", ""}, 10},
+		{anomalySeries{"error_rate", ""}, 1},
+		{anomalySeries{"p95_response_ms", ""}, 200},
+		{anomalySeries{"p95_response_ms", "web.1"}, 250},
+		{anomalySeries{"requests_per_second", "web.1"}, 10},
+	} {
+		mean, _, ok := d.expected(tt.series, now)
+		if !ok || math.Abs(mean-tt.want) > 1e-6 {
+			t.Errorf("%v: expected a baseline of %g, got %g (ready %v)", tt.series, tt.want, mean, ok)
+		}
+	}
+}
+
+func TestAnomalyDetector_LoadHistory_PrunesDynos(t *testing.T) {
+	app := createQueryTestApp(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	app.writeSnapshotToDb(app.LogDb, &Metric{
+		Timestamp:       now.Add(-30 * time.Hour),
+		DynoPerformance: map[string]DynoMetric{"run.1": {P95ResponseTime: time.Second}},
+	})
+	// the time of a row is its snapshot_time
+	_, err := app.LogDb.Exec(
+		"INSERT INTO metric_snapshots (snapshot_time, metrics_data) VALUES (?, ?)",
+		now.Add(-time.Minute),
+		`{"dyno_performance": {"web.1": {"p95_response_time": 250000000}}}`,
+	)
+	if err != nil {
+		t.Fatalf("Failed to write snapshot: %v", err)
+	}
+
+	d := NewAnomalyDetector(3)
+	n, err := d.LoadHistory(app.LogDb, now.Add(-48*time.Hour))
+	if err != nil || n != 2 {
+		t.Fatalf("Expected 2 snapshots learned, got %d (%v)", n, err)
+	}
+	if _, ok := d.baselines[anomalySeries{"p95_response_ms", "run.1"}]; ok {
+		t.Error("Expected the one-off dyno baseline pruned")
+	}
+	if b, ok := d.baselines[anomalySeries{"p95_response_ms", "web.1"}]; !ok || !b.seenAt.Equal(now.Add(-time.Minute)) {
+		t.Errorf("Expected the web.1 baseline seen at the snapshot time, got %v", b)
+	}
+	if !d.observedAt.Equal(now.Add(-time.Minute)) {
+		t.Errorf("Expected observedAt from snapshot_time, got %v", d.observedAt)
+	}
+}
+
+func TestApp_LoadAnomalyHistory(t *testing.T) {
+	app := createQueryTestApp(t)
+	app.Anomalies = NewAnomalyDetector(3)
+	now := time.Now().UTC()
+	for i := 60; i > 0; i-- {
+		app.writeSnapshotToDb(app.LogDb, &Metric{
+			Timestamp: now.Add(-time.Duration(i) * time.Minute),
+			Windows:   map[string]WindowMetric{"5m": {TotalRequests: 3000, RequestsPerSecond: 10}},
+		})
+	}
+
+	// learned live while the history loads
+	rps := anomalySeries{"requests_per_second", ""}
+	live := app.Anomalies.baseline(rps)
+	for i := 0; i < minRecentSamples; i++ {
+		live.observe(now, 20)
+	}
+
+	n, err := app.LoadAnomalyHistory(app.LogDb, now.Add(-24*time.Hour))
+	if err != nil || n != 60 {
+		t.Fatalf("Expected 60 snapshots learned, got %d (%v)", n, err)
+	}
+	if live.recent.mean != 20 {
+		t.Errorf("Expected the live recent baseline kept, got %g", live.recent.mean)
+	}
+	if slot := live.seasonal[hourOfWeek(now.Add(-time.Minute))]; slot.n == 0 || slot.mean == 20 {
+		t.Errorf("Expected the seasonal baseline from the history, got %+v", slot)
+	}
+	if _, ok := app.Anomalies.baselines[anomalySeries{"p95_response_ms", ""}]; !ok {
+		t.Error("Expected baselines only in the history added")
+	}
+}
@@ -0,0 +1,37 @@
+package internal
+
+import "time"
+
+// Apdex from the ClassifyResTime thresholds: satisfied up to HealthyThreshold,
+// tolerating up to MediumThreshold, frustrated beyond or on a 5xx.
+// score = (satisfied + tolerating/2) / total
+
+type ApdexCounts struct {
+	Satisfied  int64 `json:"satisfied"`
+	Tolerating int64 `json:"tolerating"`
+	Frustrated int64 `json:"frustrated"`
+}
+
+func (c *ApdexCounts) Add(status int, responseTime time.Duration) {
+	if status >= 500 {
+		c.Frustrated++
+		return
+	}
+	switch ClassifyResTime(responseTime) {
+	case "healthy":
+		c.Satisfied++
+	case "medium":
+		c.Tolerating++
+	default:
+		c.Frustrated++
+	}
+}
+
+// Score in [0, 1], 1 when nothing was counted yet
+func (c ApdexCounts) Score() float64 {
+	total := c.Satisfied + c.Tolerating + c.Frustrated
+	if total == 0 {
+		return 1
+	}
+	return (float64(c.Satisfied) + float64(c.Tolerating)/2) / float64(total)
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestApdexCounts(t *testing.T) {
+	var c ApdexCounts
+	if c.Score() != 1 {
+		t.Errorf("Expected 1 for no requests, got %f", c.Score())
+	}
+
+	c.Add(200, 100*time.Millisecond) // satisfied
+	c.Add(200, HealthyThreshold)     // satisfied
+	c.Add(404, 400*time.Millisecond) // tolerating
+	c.Add(200, 2*time.Second)        // frustrated
+	c.Add(503, 10*time.Millisecond)  // frustrated, fast but failed
+
+	if c.Satisfied != 2 || c.Tolerating != 1 || c.Frustrated != 2 {
+		t.Errorf("Unexpected counts %+v", c)
+	}
+	if got := c.Score(); math.Abs(got-0.5) > 1e-9 {
+		t.Errorf("Expected apdex 0.5, got %f", got)
+	}
+}
+
+func TestMetricsAggregator_Apdex(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.StartMetricsAggregator()
+
+	app.MetricChan <- createTestParsedLog(200, "GET", "/fast", "", "web.1", 50*time.Millisecond, false)
+	app.MetricChan <- createTestParsedLog(200, "GET", "/fast", "", "web.1", 50*time.Millisecond, false)
+	app.MetricChan <- createTestParsedLog(200, "GET", "/slow", "", "web.2", 3*time.Second, true)
+	app.MetricChan <- createTestParsedLog(200, "GET", "/slow", "", "web.2", 400*time.Millisecond, false)
+	time.Sleep(100 * time.Millisecond)
+
+	snapshot := app.GetMetricsSnapshot()
+	if math.Abs(snapshot.Apdex-0.625) > 1e-9 {
+		t.Errorf("Expected global apdex 0.625, got %f", snapshot.Apdex)
+	}
+	if got := snapshot.DynoPerformance["web.1"].Apdex; got != 1 {
+		t.Errorf("Expected web.1 apdex 1, got %f", got)
+	}
+	if got := snapshot.DynoPerformance["web.2"].Apdex; got != 0.25 {
+		t.Errorf("Expected web.2 apdex 0.25, got %f", got)
+	}
+
+	app.MetricsMu.RLock()
+	endpoints, _ := app.Endpoints.Metrics("slowest")
+	app.MetricsMu.RUnlock()
+	if endpoints[0].Route != "/slow" || endpoints[0].Apdex != 0.25 {
+		t.Errorf("Expected /slow at apdex 0.25, got %+v", endpoints[0])
+	}
+}
@@ -0,0 +1,234 @@
+package internal
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// app[web.N]: structured JSON output of our own dynos
+
+const (
+	defaultFieldLogLimit = 100
+	maxFieldLogLimit     = 1000
+)
+
+var defaultFieldMetrics = []string{"duration_ms"}
+
+// FieldMetric summarises one numeric field of the JSON app logs
+type FieldMetric struct {
+	Count int64   `json:"count"`
+	Sum   float64 `json:"sum"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+}
+
+// Flattens a JSON object payload into dotted keys, {"user":{"id":1}} becomes user.id.
+// Values are string, float64, bool or nil, arrays are kept as their JSON text.
+// Returns nil when msg is not a JSON object.
+func parseJSONPayload(msg string) map[string]any {
+	msg = strings.TrimSpace(msg)
+	if !strings.HasPrefix(msg, "{") || !strings.HasSuffix(msg, "}") {
+		return nil
+	}
+	var payload map[string]any
+	if err := json.Unmarshal([]byte(msg), &payload); err != nil {
+		return nil
+	}
+	attrs := make(map[string]any, len(payload))
+	flattenJSON("", payload, attrs)
+	return attrs
+}
+
+func flattenJSON(prefix string, obj map[string]any, attrs map[string]any) {
+	for k, v := range obj {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch v := v.(type) {
+		case map[string]any:
+			flattenJSON(key, v, attrs)
+		case []any:
+			b, _ := json.Marshal(v)
+			attrs[key] = string(b)
+		default:
+			attrs[key] = v
+		}
+	}
+}
+
+// text and numeric columns of log_fields, numbers are in both so exact matches work
+func fieldColumns(v any) (text, num any) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), v
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return nil, nil
+}
+
+const insertLogField = "INSERT INTO log_fields (log_id, key, value_text, value_num) VALUES (?, ?, ?, ?)"
+
+func writeLogFields(stmt *sql.Stmt, logId int64, attrs map[string]any) error {
+	for k, v := range attrs {
+		text, num := fieldColumns(v)
+		if _, err := stmt.Exec(logId, k, text, num); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *App) fieldMetricNames() []string {
+	if a.Config != nil && a.Config.FieldMetrics != nil {
+		return a.Config.FieldMetrics
+	}
+	return defaultFieldMetrics
+}
+
+// true when l carries a numeric field metrics are derived from
+func (a *App) hasFieldMetrics(l *ParsedLog) bool {
+	for _, name := range a.fieldMetricNames() {
+		if _, ok := l.Attrs[name].(float64); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// called by the aggregator with MetricsMu held
+func (a *App) applyFieldMetrics(l *ParsedLog) {
+	for _, name := range a.fieldMetricNames() {
+		v, ok := l.Attrs[name].(float64)
+		if !ok {
+			continue
+		}
+		fm, exists := a.Metric.FieldMetrics[name]
+		if !exists || v < fm.Min {
+			fm.Min = v
+		}
+		if !exists || v > fm.Max {
+			fm.Max = v
+		}
+		fm.Count++
+		fm.Sum += v
+		fm.Avg = fm.Sum / float64(fm.Count)
+		a.Metric.FieldMetrics[name] = fm
+	}
+}
+
+// fieldFilter selects logs by one JSON field, either an exact value or a numeric range
+type fieldFilter struct {
+	Key      string
+	Value    string
+	Min, Max *float64
+}
+
+func (a *App) queryFieldLogs(db *sql.DB, f fieldFilter, limit int) ([]*ParsedLog, error) {
+	query := bytes.NewBufferString(
+		`SELECT r.log_data FROM raw_logs r JOIN log_fields f ON f.log_id = r.id WHERE f.key = ?`,
+	)
+	args := []any{f.Key}
+	if f.Value != "" {
+		query.WriteString(" AND f.value_text = ?")
+		args = append(args, f.Value)
+	}
+	if f.Min != nil {
+		query.WriteString(" AND f.value_num >= ?")
+		args = append(args, *f.Min)
+	}
+	if f.Max != nil {
+		query.WriteString(" AND f.value_num <= ?")
+		args = append(args, *f.Max)
+	}
+	query.WriteString(" ORDER BY r.timestamp DESC, r.id DESC LIMIT ?")
+	args = append(args, limit)
+
+	rows, err := db.Query(query.String(), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []*ParsedLog{}
+	for rows.Next() {
+		var logData string
+		if err := rows.Scan(&logData); err != nil {
+			return nil, err
+		}
+		var l ParsedLog
+		if err := json.Unmarshal([]byte(logData), &l); err != nil {
+			return nil, err
+		}
+		logs = append(logs, &l)
+	}
+	return logs, rows.Err()
+}
+
+// GET /logs?field=user_id&value=42 or /logs?field=duration_ms&min=500&limit=50
+func (a *App) FieldLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.LogDb == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "database unavailable")
+		return
+	}
+
+	q := r.URL.Query()
+	f := fieldFilter{Key: q.Get("field"), Value: q.Get("value")}
+	if f.Key == "" {
+		writeJSONError(w, http.StatusBadRequest, "field is required")
+		return
+	}
+	for _, bound := range []struct {
+		name string
+		dst  **float64
+	}{{"min", &f.Min}, {"max", &f.Max}} {
+		v := q.Get(bound.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid "+bound.name)
+			return
+		}
+		*bound.dst = &n
+	}
+	limit := defaultFieldLogLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxFieldLogLimit {
+			writeJSONError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	logs, err := a.queryFieldLogs(a.LogDb, f, limit)
+	if err != nil {
+		log.Printf("Failed to query logs by field %s: %v", f.Key, err)
+		http.Error(w, "Failed to query logs", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"field": f.Key,
+		"logs":  logs,
+	}); err != nil {
+		http.Error(w, "Failed to encode logs", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseJSONPayload(t *testing.T) {
+	attrs := parseJSONPayload(`{"level":"info","msg":"checkout","user_id":42,"duration_ms":12.5,"ok":true,"ctx":{"region":"eu","shard":3},"tags":["a","b"],"err":null}`)
+	if attrs == nil {
+		t.Fatal("Expected attrs, got nil")
+	}
+
+	expected := map[string]any{
+		"level":       "info",
+		"msg":         "checkout",
+		"user_id":     42.0,
+		"duration_ms": 12.5,
+		"ok":          true,
+		"ctx.region":  "eu",
+		"ctx.shard":   3.0,
+		"tags":        `["a","b"]`,
+		"err":         nil,
+	}
+	if len(attrs) != len(expected) {
+		t.Errorf("Expected %d attrs, got %d: %v", len(expected), len(attrs), attrs)
+	}
+	for k, v := range expected {
+		got, ok := attrs[k]
+		if !ok || got != v {
+			t.Errorf("attrs[%q] = %v (%T), expected %v (%T)", k, got, got, v, v)
+		}
+	}
+
+	for _, msg := range []string{"", "Hello world", "at=info status=200", `{"broken":`, `["not","an","object"]`} {
+		if attrs := parseJSONPayload(msg); attrs != nil {
+			t.Errorf("parseJSONPayload(%q) = %v, expected nil", msg, attrs)
+		}
+	}
+}
+
+func TestAppParser_JSON(t *testing.T) {
+	h, msg, err := ParseSyslog(`<190>1 2025-07-19T10:30:45Z host app web.1 - {"level":"warn","msg":"slow query","request_id":"abc-123","duration_ms":830}`)
+	if err != nil {
+		t.Fatalf("Unexpected syslog error: %v", err)
+	}
+	l := DefaultParserRegistry().Parse(h, msg, TokenizeKV(msg))
+
+	if l.Source != SourceApp {
+		t.Fatalf("Expected source %q, got %q", SourceApp, l.Source)
+	}
+	if l.Level != "warn" || l.ReqId != "abc-123" {
+		t.Errorf("Expected level warn and request id abc-123, got %q %q", l.Level, l.ReqId)
+	}
+	if l.Attrs["duration_ms"] != 830.0 {
+		t.Errorf("Expected duration_ms 830, got %v", l.Attrs["duration_ms"])
+	}
+	if l.Fields != nil {
+		t.Errorf("Expected no key=value fields for a JSON line, got %v", l.Fields)
+	}
+}
+
+func TestApp_FieldMetrics(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.Config = &Config{FieldMetrics: []string{"duration_ms", "rows"}}
+	app.StartMetricsAggregator()
+
+	go app.FanOut()
+	app.ParsedLogChan <- &ParsedLog{Source: SourceApp, Attrs: map[string]any{"duration_ms": 10.0, "rows": 3.0}}
+	app.ParsedLogChan <- &ParsedLog{Source: SourceApp, Attrs: map[string]any{"duration_ms": 30.0}}
+	app.ParsedLogChan <- &ParsedLog{Source: SourceApp, Attrs: map[string]any{"duration_ms": "fast"}}
+	app.ParsedLogChan <- &ParsedLog{Source: SourceApp, Message: "plain text"}
+
+	time.Sleep(100 * time.Millisecond)
+
+	metrics := app.GetMetricsSnapshot()
+	if metrics.TotalRequests != 0 {
+		t.Errorf("Expected app logs to stay out of request counters, got %d", metrics.TotalRequests)
+	}
+	fm := metrics.FieldMetrics["duration_ms"]
+	if fm.Count != 2 || fm.Min != 10 || fm.Max != 30 || fm.Avg != 20 {
+		t.Errorf("Unexpected duration_ms summary %+v", fm)
+	}
+	if metrics.FieldMetrics["rows"].Count != 1 {
+		t.Errorf("Expected 1 rows sample, got %+v", metrics.FieldMetrics["rows"])
+	}
+}
+
+func TestApp_FieldLogs(t *testing.T) {
+	app := createQueryTestApp(t)
+	now := time.Now()
+
+	batch := []*ParsedLog{
+		{Time: now, Source: SourceApp, Attrs: map[string]any{"user_id": 42.0, "duration_ms": 120.0, "plan": "pro"}},
+		{Time: now.Add(time.Second), Source: SourceApp, Attrs: map[string]any{"user_id": 7.0, "duration_ms": 900.0, "plan": "free"}},
+		{Time: now, Source: SourceRouter, Status: 200},
+	}
+	if err := app.writeBatchToDb(app.LogDb, batch); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+	single := &ParsedLog{Time: now.Add(2 * time.Second), Source: SourceApp, Attrs: map[string]any{"user_id": 42.0, "ok": true}}
+	if err := app.writeLogToDb(app.LogDb, single); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	var count int
+	if err := app.LogDb.QueryRow("SELECT COUNT(*) FROM log_fields").Scan(&count); err != nil {
+		t.Fatalf("Failed to count fields: %v", err)
+	}
+	if count != 8 {
+		t.Errorf("Expected 8 field rows, got %d", count)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /logs", app.FieldLogsHandler)
+
+	tests := []struct {
+		query    string
+		wantCode int
+		wantLogs int
+	}{
+		{"field=user_id&value=42", http.StatusOK, 2},
+		{"field=plan&value=free", http.StatusOK, 1},
+		{"field=ok&value=true", http.StatusOK, 1},
+		{"field=duration_ms&min=500", http.StatusOK, 1},
+		{"field=duration_ms&min=100&max=1000", http.StatusOK, 2},
+		{"field=user_id&limit=1", http.StatusOK, 1},
+		{"field=missing", http.StatusOK, 0},
+		{"value=42", http.StatusBadRequest, 0},
+		{"field=duration_ms&min=abc", http.StatusBadRequest, 0},
+		{"field=user_id&limit=0", http.StatusBadRequest, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/logs?"+tt.query, nil))
+
+			if w.Code != tt.wantCode {
+				t.Fatalf("Expected status %d, got %d", tt.wantCode, w.Code)
+			}
+			if tt.wantCode != http.StatusOK {
+				return
+			}
+			var body struct {
+				Logs []*ParsedLog `json:"logs"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(body.Logs) != tt.wantLogs {
+				t.Errorf("Expected %d logs, got %d", tt.wantLogs, len(body.Logs))
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package internal
 import (
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -16,6 +17,61 @@ type Config struct {
 	BatchSize         int
 	FlushInterval     time.Duration
 	SnapshotInterval  time.Duration
+
+	// plan connection limits, 0 disables the connection alert
+	PostgresConnectionLimit int
+	RedisConnectionLimit    int
+
+	// numeric JSON app log fields summarised into Metric.FieldMetrics
+	FieldMetrics []string
+
+	// heavy hitters reported for endpoints and countries, capacity bounds memory
+	// and defaults to topKCapacityPerSlot counters per slot
+	TopK         int
+	TopKCapacity int
+
+	// percentiles are computed over this rolling window
+	LatencyWindow time.Duration
+
+	// router lines are bucketed by event time, lines this far behind the newest
+	// one are counted as late and left out of the windows
+	AllowedLateness time.Duration
+
+	// route templates for the endpoint metrics, "/users/:id", "/assets/*"
+	RouteTemplates []string
+
+	// distinct routes in the endpoint breakdown, the rest are folded together
+	MaxEndpoints int
+
+	// alert rules file, empty uses the built in error rate and p95 rules
+	AlertRulesPath    string
+	AlertEvalInterval time.Duration
+
+	// a cleared alert only resolves once its condition stayed clear this long
+	AlertResolveAfter time.Duration
+
+	// standard deviations from the baseline for an anomaly alert, 0 disables
+	// detection, the baselines learn from the snapshots of AnomalyHistory
+	AnomalyThreshold float64
+	AnomalyHistory   time.Duration
+
+	// alert notifiers file, empty sends no notifications
+	NotifiersPath string
+
+	// OTLP/HTTP collector the parsed lines and metrics are pushed to, empty
+	// disables the export, see otlp.go
+	OTLPEndpoint       string
+	OTLPProtocol       string
+	OTLPHeaders        []string
+	OTLPServiceName    string
+	OTLPMetricInterval time.Duration
+
+	// SLO definitions file, empty disables SLO tracking
+	SLOPath string
+
+	// extraction rules file, empty disables user rules
+	RulesPath           string
+	RulesReloadInterval time.Duration
 }
 
 func LoadConfig() *Config {
@@ -29,6 +85,40 @@ func LoadConfig() *Config {
 		BatchSize:         getEnvInt("BATCH_SIZE", 100),
 		FlushInterval:     getEnvDuration("FLUSH_INTERVAL", 5*time.Second),
 		SnapshotInterval:  getEnvDuration("SNAPSHOT_INTERVAL", 1*time.Minute),
+
+		PostgresConnectionLimit: getEnvInt("POSTGRES_CONNECTION_LIMIT", defaultPostgresConnectionLimit),
+		RedisConnectionLimit:    getEnvInt("REDIS_CONNECTION_LIMIT", defaultRedisConnectionLimit),
+
+		FieldMetrics: getEnvList("FIELD_METRICS", defaultFieldMetrics),
+
+		TopK:         getEnvInt("TOP_K", defaultTopK),
+		TopKCapacity: getEnvInt("TOP_K_CAPACITY", 0),
+
+		LatencyWindow:   getEnvDuration("LATENCY_WINDOW", defaultLatencyWindow),
+		AllowedLateness: getEnvDuration("ALLOWED_LATENESS", defaultAllowedLateness),
+
+		RouteTemplates: getEnvList("ROUTE_TEMPLATES", nil),
+		MaxEndpoints:   getEnvInt("MAX_ENDPOINTS", defaultMaxEndpoints),
+
+		AlertRulesPath:    getEnv("ALERT_RULES_PATH", ""),
+		AlertEvalInterval: getEnvDuration("ALERT_EVAL_INTERVAL", defaultAlertEvalInterval),
+		AlertResolveAfter: getEnvDuration("ALERT_RESOLVE_AFTER", defaultAlertResolveAfter),
+
+		AnomalyThreshold: getEnvFloat("ANOMALY_THRESHOLD", defaultAnomalyThreshold),
+		AnomalyHistory:   getEnvDuration("ANOMALY_HISTORY", defaultAnomalyHistory),
+
+		NotifiersPath: getEnv("NOTIFIERS_PATH", ""),
+
+		OTLPEndpoint:       getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", ""),
+		OTLPProtocol:       getEnv("OTEL_EXPORTER_OTLP_PROTOCOL", OTLPProtobuf),
+		OTLPHeaders:        getEnvList("OTEL_EXPORTER_OTLP_HEADERS", nil),
+		OTLPServiceName:    getEnv("OTEL_SERVICE_NAME", defaultOTLPServiceName),
+		OTLPMetricInterval: time.Duration(getEnvInt("OTEL_METRIC_EXPORT_INTERVAL", int(defaultOTLPMetricInterval/time.Millisecond))) * time.Millisecond,
+
+		SLOPath: getEnv("SLO_PATH", ""),
+
+		RulesPath:           getEnv("RULES_PATH", ""),
+		RulesReloadInterval: getEnvDuration("RULES_RELOAD_INTERVAL", 10*time.Second),
 	}
 }
 
@@ -48,6 +138,30 @@ func getEnvInt(key string, defaultValue int) int {
 	return defaultValue
 }
 
+func getEnvFloat(key string, defaultValue float64) float64 {
+	if value := os.Getenv(key); value != "" {
+		if f, err := strconv.ParseFloat(value, 64); err == nil {
+			return f
+		}
+	}
+	return defaultValue
+}
+
+// comma separated, blank entries dropped
+func getEnvList(key string, defaultValue []string) []string {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	list := []string{}
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
 func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
 	if value := os.Getenv(key); value != "" {
 		if d, err := time.ParseDuration(value); err == nil {
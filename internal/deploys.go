@@ -0,0 +1,224 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultDeployWindow = 15 * time.Minute
+	maxDeployWindow     = 24 * time.Hour
+	defaultDeployLimit  = 10
+)
+
+// DeployEvent is a release, deploy or rollback announced by app[api]
+type DeployEvent struct {
+	Kind    string `json:"kind"` // "release", "deploy", "rollback"
+	Version string `json:"version,omitempty"`
+	Commit  string `json:"commit,omitempty"`
+	Actor   string `json:"actor,omitempty"`
+}
+
+// "Release v123 created by user@example.com"
+// "Deploy 1a2b3c4 by user@example.com"
+// "Rollback to v122 by user@example.com"
+func parseDeployEvent(msg string) *DeployEvent {
+	words := strings.Fields(msg)
+	if len(words) < 2 {
+		return nil
+	}
+	var e *DeployEvent
+	switch {
+	case words[0] == "Release" && strings.HasPrefix(words[1], "v"):
+		e = &DeployEvent{Kind: "release", Version: words[1]}
+	case words[0] == "Deploy":
+		e = &DeployEvent{Kind: "deploy", Commit: words[1]}
+	case words[0] == "Rollback" && len(words) > 2 && words[1] == "to":
+		e = &DeployEvent{Kind: "rollback", Version: words[2]}
+	default:
+		return nil
+	}
+	for i, w := range words {
+		if w == "by" && i+1 < len(words) {
+			e.Actor = words[i+1]
+			break
+		}
+	}
+	return e
+}
+
+// router traffic inside one side of a deploy window
+type WindowStats struct {
+	From              time.Time     `json:"from"`
+	To                time.Time     `json:"to"`
+	Requests          int64         `json:"requests"`
+	ErrorRate         float64       `json:"error_rate"`
+	P95ResponseTime   time.Duration `json:"p95_response_time"`
+	RequestsPerSecond float64       `json:"requests_per_second"`
+}
+
+type DeployComparison struct {
+	DeployEvent
+	Time   time.Time   `json:"time"`
+	Before WindowStats `json:"before"`
+	After  WindowStats `json:"after"`
+
+	ErrorRateChange float64       `json:"error_rate_change"` // after - before, percentage points
+	P95Change       time.Duration `json:"p95_change"`
+	ThroughputRatio float64       `json:"throughput_ratio"` // after / before, 0 when nothing before
+}
+
+func writeDeployMarker(stmt *sql.Stmt, l *ParsedLog) error {
+	_, err := stmt.Exec(l.Time.UTC(), l.Deploy.Kind, l.Deploy.Version, l.Deploy.Commit, l.Deploy.Actor, l.Message)
+	return err
+}
+
+const insertDeployMarker = "INSERT INTO deploy_markers (event_time, kind, version, commit_sha, actor, message) VALUES (?, ?, ?, ?, ?, ?)"
+
+func (a *App) queryDeployMarkers(db *sql.DB, limit int) ([]DeployComparison, error) {
+	rows, err := db.Query(
+		"SELECT event_time, kind, version, commit_sha, actor FROM deploy_markers ORDER BY event_time DESC, id DESC LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deploys := []DeployComparison{}
+	for rows.Next() {
+		var d DeployComparison
+		if err := rows.Scan(&d.Time, &d.Kind, &d.Version, &d.Commit, &d.Actor); err != nil {
+			return nil, err
+		}
+		deploys = append(deploys, d)
+	}
+	return deploys, rows.Err()
+}
+
+// error rate, p95 and throughput of the stored router lines in [from, to), the
+// p95 from a sketch so a busy window costs no more memory than a quiet one
+func (a *App) windowStats(db *sql.DB, from, to time.Time) (WindowStats, error) {
+	stats := WindowStats{From: from, To: to}
+	rows, err := db.Query(
+		`SELECT json_extract(log_data, '$.Status'), json_extract(log_data, '$.ResponseTime')
+		FROM raw_logs
+		WHERE timestamp >= ? AND timestamp < ? AND json_extract(log_data, '$.Source') = ?`,
+		from.UTC(), to.UTC(), SourceRouter,
+	)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	var errors int64
+	sketch := NewLatencySketch()
+	for rows.Next() {
+		var status int
+		var responseTime int64
+		if err := rows.Scan(&status, &responseTime); err != nil {
+			return stats, err
+		}
+		stats.Requests++
+		if status >= 400 {
+			errors++
+		}
+		sketch.Add(time.Duration(responseTime))
+	}
+	if err := rows.Err(); err != nil {
+		return stats, err
+	}
+
+	if stats.Requests > 0 {
+		stats.ErrorRate = float64(errors) / float64(stats.Requests) * 100
+		stats.P95ResponseTime = sketch.Quantile(0.95)
+	}
+	if elapsed := to.Sub(from).Seconds(); elapsed > 0 {
+		stats.RequestsPerSecond = float64(stats.Requests) / elapsed
+	}
+	return stats, nil
+}
+
+// fills Before/After for a marker, the after window stops at now for recent deploys
+func (a *App) compareDeploy(db *sql.DB, d *DeployComparison, window time.Duration, now time.Time) error {
+	var err error
+	d.Before, err = a.windowStats(db, d.Time.Add(-window), d.Time)
+	if err != nil {
+		return err
+	}
+	end := d.Time.Add(window)
+	if end.After(now) {
+		end = now
+	}
+	d.After, err = a.windowStats(db, d.Time, end)
+	if err != nil {
+		return err
+	}
+
+	d.ErrorRateChange = d.After.ErrorRate - d.Before.ErrorRate
+	d.P95Change = d.After.P95ResponseTime - d.Before.P95ResponseTime
+	if d.Before.RequestsPerSecond > 0 {
+		d.ThroughputRatio = d.After.RequestsPerSecond / d.Before.RequestsPerSecond
+	}
+	return nil
+}
+
+// GET /deploys?window=15m&limit=10
+func (a *App) DeploysHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	if a.LogDb == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "database unavailable")
+		return
+	}
+
+	window := defaultDeployWindow
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 || d > maxDeployWindow {
+			writeJSONError(w, http.StatusBadRequest, "invalid window")
+			return
+		}
+		window = d
+	}
+	limit := defaultDeployLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	deploys, err := a.queryDeployMarkers(a.LogDb, limit)
+	if err != nil {
+		log.Printf("Failed to query deploy markers: %v", err)
+		http.Error(w, "Failed to query deploys", http.StatusInternalServerError)
+		return
+	}
+	now := time.Now()
+	for i := range deploys {
+		if err := a.compareDeploy(a.LogDb, &deploys[i], window, now); err != nil {
+			log.Printf("Failed to compare deploy %s: %v", deploys[i].Version, err)
+			http.Error(w, "Failed to query deploys", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"window":  window.String(),
+		"deploys": deploys,
+	}); err != nil {
+		http.Error(w, "Failed to encode deploys", http.StatusInternalServerError)
+		return
+	}
+}
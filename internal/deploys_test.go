@@ -0,0 +1,183 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDeployEvent(t *testing.T) {
+	tests := []struct {
+		name     string
+		msg      string
+		expected *DeployEvent
+	}{
+		{"release", "Release v123 created by user@example.com", &DeployEvent{Kind: "release", Version: "v123", Actor: "user@example.com"}},
+		{"deploy", "Deploy 1a2b3c4 by user@example.com", &DeployEvent{Kind: "deploy", Commit: "1a2b3c4", Actor: "user@example.com"}},
+		{"rollback", "Rollback to v122 by user@example.com", &DeployEvent{Kind: "rollback", Version: "v122", Actor: "user@example.com"}},
+		{"no actor", "Release v7 created", &DeployEvent{Kind: "release", Version: "v7"}},
+		{"config change", "Set FOO config vars by user@example.com", nil},
+		{"empty", "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseDeployEvent(tt.msg)
+			if tt.expected == nil {
+				if got != nil {
+					t.Errorf("Expected nil, got %+v", got)
+				}
+				return
+			}
+			if got == nil || *got != *tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestParserRegistry_Release(t *testing.T) {
+	h, msg, err := ParseSyslog(`<134>1 2025-07-19T10:30:45Z host app api - Release v123 created by user@example.com`)
+	if err != nil {
+		t.Fatalf("Unexpected syslog error: %v", err)
+	}
+	l := DefaultParserRegistry().Parse(h, msg, map[string]string{"timestamp": h.Timestamp})
+	if l == nil || l.Deploy == nil {
+		t.Fatalf("Expected a deploy event, got %+v", l)
+	}
+	if l.Deploy.Version != "v123" {
+		t.Errorf("Expected version v123, got %s", l.Deploy.Version)
+	}
+}
+
+func writeDeployTestTraffic(t *testing.T, app *App, at time.Time, n int, status int, rt time.Duration) {
+	t.Helper()
+	batch := make([]*ParsedLog, 0, n)
+	for i := 0; i < n; i++ {
+		batch = append(batch, &ParsedLog{
+			Time:         at.Add(time.Duration(i) * time.Second),
+			Source:       SourceRouter,
+			Status:       status,
+			ResponseTime: rt,
+		})
+	}
+	if err := app.writeBatchToDb(app.LogDb, batch); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+}
+
+func TestApp_CompareDeploy(t *testing.T) {
+	app := createQueryTestApp(t)
+	deployAt := time.Date(2025, 7, 19, 10, 30, 0, 0, time.UTC)
+
+	release := &ParsedLog{
+		Time:    deployAt,
+		Source:  SourceRelease,
+		Message: "Release v2 created by user@example.com",
+		Deploy:  &DeployEvent{Kind: "release", Version: "v2", Actor: "user@example.com"},
+	}
+	if err := app.writeBatchToDb(app.LogDb, []*ParsedLog{release}); err != nil {
+		t.Fatalf("Failed to write release: %v", err)
+	}
+
+	writeDeployTestTraffic(t, app, deployAt.Add(-5*time.Minute), 10, 200, 50*time.Millisecond)
+	writeDeployTestTraffic(t, app, deployAt.Add(time.Minute), 8, 200, 200*time.Millisecond)
+	writeDeployTestTraffic(t, app, deployAt.Add(2*time.Minute), 2, 500, 200*time.Millisecond)
+	// outside both windows
+	writeDeployTestTraffic(t, app, deployAt.Add(-time.Hour), 5, 500, time.Second)
+
+	deploys, err := app.queryDeployMarkers(app.LogDb, 10)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(deploys) != 1 {
+		t.Fatalf("Expected 1 deploy marker, got %d", len(deploys))
+	}
+	d := deploys[0]
+	if d.Version != "v2" || d.Actor != "user@example.com" || !d.Time.Equal(deployAt) {
+		t.Errorf("Unexpected marker %+v", d)
+	}
+
+	if err := app.compareDeploy(app.LogDb, &d, 15*time.Minute, deployAt.Add(time.Hour)); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if d.Before.Requests != 10 || d.Before.ErrorRate != 0 {
+		t.Errorf("Expected 10 clean requests before, got %+v", d.Before)
+	}
+	if d.After.Requests != 10 || d.After.ErrorRate != 20 {
+		t.Errorf("Expected 10 requests at 20%% errors after, got %+v", d.After)
+	}
+	if d.ErrorRateChange != 20 {
+		t.Errorf("Expected error rate change of 20, got %f", d.ErrorRateChange)
+	}
+	if d.P95Change != 150*time.Millisecond {
+		t.Errorf("Expected p95 change of 150ms, got %v", d.P95Change)
+	}
+	if d.ThroughputRatio != 1 {
+		t.Errorf("Expected throughput ratio 1, got %f", d.ThroughputRatio)
+	}
+
+	var id, parent, unused int
+	var plan string
+	err = app.LogDb.QueryRow("EXPLAIN QUERY PLAN SELECT id FROM raw_logs WHERE timestamp >= ? AND timestamp < ?", deployAt, deployAt).
+		Scan(&id, &parent, &unused, &plan)
+	if err != nil || !strings.Contains(plan, "idx_raw_logs_timestamp") {
+		t.Errorf("Expected the window scan to use the timestamp index, got %q %v", plan, err)
+	}
+}
+
+func TestApp_DeploysHandler(t *testing.T) {
+	app := createQueryTestApp(t)
+	release := &ParsedLog{
+		Time:   time.Now().Add(-time.Minute),
+		Source: SourceRelease,
+		Deploy: &DeployEvent{Kind: "release", Version: "v9"},
+	}
+	if err := app.writeLogToDb(app.LogDb, release); err != nil {
+		t.Fatalf("Failed to write release: %v", err)
+	}
+
+	t.Run("lists deploys", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		app.DeploysHandler(w, httptest.NewRequest(http.MethodGet, "/deploys?window=5m", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		var body struct {
+			Window  string             `json:"window"`
+			Deploys []DeployComparison `json:"deploys"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if body.Window != "5m0s" || len(body.Deploys) != 1 || body.Deploys[0].Version != "v9" {
+			t.Errorf("Unexpected response %+v", body)
+		}
+	})
+
+	for _, query := range []string{"window=abc", "window=48h", "limit=0"} {
+		t.Run("bad "+query, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.DeploysHandler(w, httptest.NewRequest(http.MethodGet, "/deploys?"+query, nil))
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+		})
+	}
+
+	t.Run("no database", func(t *testing.T) {
+		noDb := createQueryTestApp(t)
+		noDb.LogDb = nil
+		w := httptest.NewRecorder()
+		noDb.DeploysHandler(w, httptest.NewRequest(http.MethodGet, "/deploys", nil))
+
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected status 503, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Per route breakdown of the router traffic. Routes are the normalized paths
+// from routes.go, past MaxEndpoints new routes are folded into otherEndpoint so
+// a scanner walking random paths cannot grow the map.
+
+const (
+	defaultMaxEndpoints  = 200
+	defaultEndpointLimit = 20
+	otherEndpoint        = "(other)"
+)
+
+type EndpointMetric struct {
+	Route           string        `json:"route"`
+	Count           int64         `json:"count"`
+	Status2xx       int64         `json:"status_2xx"`
+	Status3xx       int64         `json:"status_3xx"`
+	Status4xx       int64         `json:"status_4xx"`
+	Status5xx       int64         `json:"status_5xx"`
+	ErrorRate       float64       `json:"error_rate"`
+	P50ResponseTime time.Duration `json:"p50_response_time"`
+	P95ResponseTime time.Duration `json:"p95_response_time"`
+	P99ResponseTime time.Duration `json:"p99_response_time"`
+	AvgBytes        float64       `json:"avg_bytes"`
+	Apdex           float64       `json:"apdex"`
+}
+
+func (m EndpointMetric) errors() int64 {
+	return m.Status4xx + m.Status5xx
+}
+
+// orderings for ?sort=, ties go to the busier route then the name
+var endpointSorts = map[string]func(a, b EndpointMetric) bool{
+	"traffic": func(a, b EndpointMetric) bool { return a.Count > b.Count },
+	"slowest": func(a, b EndpointMetric) bool { return a.P95ResponseTime > b.P95ResponseTime },
+	"errors":  func(a, b EndpointMetric) bool { return a.errors() > b.errors() },
+}
+
+type endpointCounters struct {
+	count     int64
+	status2xx int64
+	status3xx int64
+	status4xx int64
+	status5xx int64
+	bytes     int64
+	apdex     ApdexCounts
+	latency   *LatencySketch
+}
+
+// EndpointStats is written by the aggregator and read by the handler, both under MetricsMu
+type EndpointStats struct {
+	max      int
+	routes   map[string]*endpointCounters
+	overflow int64 // lines folded into otherEndpoint
+}
+
+func NewEndpointStats(max int) *EndpointStats {
+	if max < 1 {
+		max = defaultMaxEndpoints
+	}
+	return &EndpointStats{max: max, routes: make(map[string]*endpointCounters)}
+}
+
+func (a *App) newEndpointStats() *EndpointStats {
+	if a.Config != nil {
+		return NewEndpointStats(a.Config.MaxEndpoints)
+	}
+	return NewEndpointStats(defaultMaxEndpoints)
+}
+
+// Record returns the route l was counted under, otherEndpoint past the limit
+func (e *EndpointStats) Record(route string, l *ParsedLog) string {
+	c, ok := e.routes[route]
+	if !ok {
+		// the overflow bucket does not count towards the limit
+		if len(e.routes) >= e.max {
+			e.overflow++
+			route = otherEndpoint
+			c = e.routes[route]
+		}
+		if c == nil {
+			c = &endpointCounters{latency: NewLatencySketch()}
+			e.routes[route] = c
+		}
+	}
+
+	c.count++
+	switch {
+	case l.Status >= 200 && l.Status < 300:
+		c.status2xx++
+	case l.Status >= 300 && l.Status < 400:
+		c.status3xx++
+	case l.Status >= 400 && l.Status < 500:
+		c.status4xx++
+	case l.Status >= 500:
+		c.status5xx++
+	}
+	c.bytes += int64(l.Size)
+	c.apdex.Add(l.Status, l.ResponseTime)
+	c.latency.Add(l.ResponseTime)
+	return route
+}
+
+// Metrics lists every route ordered by sortBy, false for an unknown ordering
+func (e *EndpointStats) Metrics(sortBy string) ([]EndpointMetric, bool) {
+	less, ok := endpointSorts[sortBy]
+	if !ok {
+		return nil, false
+	}
+
+	metrics := make([]EndpointMetric, 0, len(e.routes))
+	for route, c := range e.routes {
+		m := EndpointMetric{
+			Route:     route,
+			Count:     c.count,
+			Status2xx: c.status2xx,
+			Status3xx: c.status3xx,
+			Status4xx: c.status4xx,
+			Status5xx: c.status5xx,
+			Apdex:     c.apdex.Score(),
+		}
+		if c.count > 0 {
+			m.ErrorRate = float64(m.errors()) / float64(c.count) * 100
+			m.AvgBytes = float64(c.bytes) / float64(c.count)
+		}
+		p := c.latency.Quantiles(0.5, 0.95, 0.99)
+		m.P50ResponseTime, m.P95ResponseTime, m.P99ResponseTime = p[0], p[1], p[2]
+		metrics = append(metrics, m)
+	}
+
+	sort.Slice(metrics, func(i, j int) bool {
+		a, b := metrics[i], metrics[j]
+		switch {
+		case less(a, b):
+			return true
+		case less(b, a):
+			return false
+		case a.Count != b.Count:
+			return a.Count > b.Count
+		}
+		return a.Route < b.Route
+	})
+	return metrics, true
+}
+
+type EndpointsResponse struct {
+	Sort      string           `json:"sort"`
+	Tracked   int              `json:"tracked"`  // distinct routes, the overflow bucket included
+	Overflow  int64            `json:"overflow"` // lines past the cardinality limit
+	Endpoints []EndpointMetric `json:"endpoints"`
+}
+
+// GET /metrics/endpoints?sort=traffic|slowest|errors&limit=20
+func (a *App) EndpointsHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	sortBy := r.URL.Query().Get("sort")
+	if sortBy == "" {
+		sortBy = "traffic"
+	}
+	limit := defaultEndpointLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			writeJSONError(w, http.StatusBadRequest, "invalid limit")
+			return
+		}
+		limit = n
+	}
+
+	a.MetricsMu.RLock()
+	stats := a.Endpoints
+	if stats == nil {
+		stats = NewEndpointStats(defaultMaxEndpoints)
+	}
+	endpoints, ok := stats.Metrics(sortBy)
+	resp := EndpointsResponse{
+		Sort:     sortBy,
+		Tracked:  len(stats.routes),
+		Overflow: stats.overflow,
+	}
+	a.MetricsMu.RUnlock()
+
+	if !ok {
+		writeJSONError(w, http.StatusBadRequest, "sort must be traffic, slowest or errors")
+		return
+	}
+	if len(endpoints) > limit {
+		endpoints = endpoints[:limit]
+	}
+	resp.Endpoints = endpoints
+
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		http.Error(w, "Failed to encode endpoints", http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,135 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestEndpointStats_Metrics(t *testing.T) {
+	e := NewEndpointStats(10)
+	for i := 0; i < 8; i++ {
+		e.Record("/health", &ParsedLog{Status: 200, Size: 100, ResponseTime: 5 * time.Millisecond})
+	}
+	for i := 0; i < 4; i++ {
+		e.Record("/users/:id", &ParsedLog{Status: 200, Size: 2000, ResponseTime: 800 * time.Millisecond})
+	}
+	for i := 0; i < 3; i++ {
+		e.Record("/checkout", &ParsedLog{Status: 503, Size: 0, ResponseTime: 30 * time.Second})
+	}
+	e.Record("/checkout", &ParsedLog{Status: 302, Size: 400, ResponseTime: 100 * time.Millisecond})
+
+	tests := []struct {
+		sort  string
+		order []string
+	}{
+		{"traffic", []string{"/health", "/checkout", "/users/:id"}},
+		{"slowest", []string{"/checkout", "/users/:id", "/health"}},
+		{"errors", []string{"/checkout", "/health", "/users/:id"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.sort, func(t *testing.T) {
+			metrics, ok := e.Metrics(tt.sort)
+			if !ok {
+				t.Fatalf("Expected %q to be a valid ordering", tt.sort)
+			}
+			if len(metrics) != len(tt.order) {
+				t.Fatalf("Expected %d routes, got %d", len(tt.order), len(metrics))
+			}
+			for i, route := range tt.order {
+				if metrics[i].Route != route {
+					t.Errorf("Position %d: expected %s, got %s", i, route, metrics[i].Route)
+				}
+			}
+		})
+	}
+
+	metrics, _ := e.Metrics("errors")
+	checkout := metrics[0]
+	if checkout.Count != 4 || checkout.Status5xx != 3 || checkout.Status3xx != 1 || checkout.ErrorRate != 75 {
+		t.Errorf("Unexpected checkout breakdown %+v", checkout)
+	}
+	if checkout.AvgBytes != 100 {
+		t.Errorf("Expected 100 avg bytes, got %f", checkout.AvgBytes)
+	}
+	assertDurationNear(t, "checkout p95", checkout.P95ResponseTime, 30*time.Second)
+	assertDurationNear(t, "checkout p50", checkout.P50ResponseTime, 30*time.Second)
+
+	if _, ok := e.Metrics("random"); ok {
+		t.Error("Expected an unknown ordering to be rejected")
+	}
+}
+
+func TestEndpointStats_CardinalityLimit(t *testing.T) {
+	e := NewEndpointStats(3)
+	for _, route := range []string{"/a", "/b", "/c", "/d", "/e", "/a"} {
+		e.Record(route, &ParsedLog{Status: 200})
+	}
+
+	metrics, _ := e.Metrics("traffic")
+	counts := make(map[string]int64)
+	for _, m := range metrics {
+		counts[m.Route] = m.Count
+	}
+	if len(counts) != 4 || counts["/a"] != 2 || counts[otherEndpoint] != 2 {
+		t.Errorf("Expected /d and /e folded into %s, got %v", otherEndpoint, counts)
+	}
+	if e.overflow != 2 {
+		t.Errorf("Expected 2 overflowed lines, got %d", e.overflow)
+	}
+}
+
+func TestApp_EndpointsHandler(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.RateLimiter = NewRateLimiterMap(100, 10)
+	app.StartMetricsAggregator()
+
+	for i := 0; i < 3; i++ {
+		app.MetricChan <- createTestParsedLog(200, "GET", "/users/1", "", "web.1", 10*time.Millisecond, false)
+	}
+	app.MetricChan <- createTestParsedLog(500, "GET", "/orders/7", "", "web.1", 2*time.Second, true)
+	time.Sleep(100 * time.Millisecond)
+
+	t.Run("slowest", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		app.EndpointsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics/endpoints?sort=slowest&limit=1", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		var resp EndpointsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if resp.Tracked != 2 || len(resp.Endpoints) != 1 {
+			t.Fatalf("Expected 1 of 2 routes, got %+v", resp)
+		}
+		if resp.Endpoints[0].Route != "/orders/:id" || resp.Endpoints[0].ErrorRate != 100 {
+			t.Errorf("Expected /orders/:id first, got %+v", resp.Endpoints[0])
+		}
+	})
+
+	t.Run("default sort", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		app.EndpointsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics/endpoints", nil))
+
+		var resp EndpointsResponse
+		json.NewDecoder(w.Body).Decode(&resp)
+		if resp.Sort != "traffic" || len(resp.Endpoints) != 2 || resp.Endpoints[0].Route != "/users/:id" {
+			t.Errorf("Expected /users/:id first by traffic, got %+v", resp)
+		}
+	})
+
+	for _, q := range []string{"sort=fastest", "limit=0", "limit=abc"} {
+		t.Run("bad "+q, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.EndpointsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics/endpoints?"+q, nil))
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+		})
+	}
+}
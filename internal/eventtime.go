@@ -0,0 +1,70 @@
+package internal
+
+import "time"
+
+// Router lines are bucketed by their own timestamp rather than by arrival, so a
+// Logplex retry or a drained backlog lands where it happened. The watermark trails
+// the newest event time by the allowed lateness; anything older is counted and
+// left out of the windows.
+
+const (
+	defaultAllowedLateness = 2 * time.Minute
+	maxClockSkew           = 1 * time.Minute // event times further ahead are not trusted
+)
+
+type EventTimeStats struct {
+	Watermark       time.Time     `json:"watermark"`
+	AllowedLateness time.Duration `json:"allowed_lateness"`
+	LateEvents      int64         `json:"late_events"`      // older than the watermark, left out of the windows
+	ZeroTimeEvents  int64         `json:"zero_time_events"` // no parsable timestamp
+	FutureEvents    int64         `json:"future_events"`    // ahead of our clock by more than maxClockSkew
+}
+
+type eventClock struct {
+	lateness     time.Duration
+	maxEventTime time.Time
+}
+
+func (a *App) newEventClock() *eventClock {
+	lateness := defaultAllowedLateness
+	if a.Config != nil && a.Config.AllowedLateness > 0 {
+		lateness = a.Config.AllowedLateness
+	}
+	return &eventClock{lateness: lateness}
+}
+
+// eventTime picks the time l is bucketed at, false when it is behind the watermark.
+// Lines without a usable timestamp go in at the newest event time seen (arrival
+// time before any) and do not move the watermark.
+// called by the aggregator with MetricsMu held
+func (a *App) eventTime(c *eventClock, l *ParsedLog, now time.Time) (time.Time, bool) {
+	stats := &a.Metric.EventTime
+	stats.AllowedLateness = c.lateness
+
+	at := l.Time
+	trusted := true
+	switch {
+	case at.IsZero():
+		stats.ZeroTimeEvents++
+		trusted = false
+	case at.After(now.Add(maxClockSkew)):
+		stats.FutureEvents++
+		trusted = false
+	}
+	if !trusted {
+		if c.maxEventTime.IsZero() {
+			return now, true
+		}
+		return c.maxEventTime, true
+	}
+
+	if at.After(c.maxEventTime) {
+		c.maxEventTime = at
+		stats.Watermark = at.Add(-c.lateness)
+	}
+	if at.Before(stats.Watermark) {
+		stats.LateEvents++
+		return at, false
+	}
+	return at, true
+}
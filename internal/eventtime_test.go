@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApp_EventTime(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.Metric = &Metric{}
+	clock := &eventClock{lateness: time.Minute}
+	now := time.Date(2025, 7, 19, 12, 0, 0, 0, time.UTC)
+
+	// zero time before any event goes in at arrival
+	at, ok := app.eventTime(clock, &ParsedLog{}, now)
+	if !ok || !at.Equal(now) {
+		t.Errorf("Expected a zero timestamp at arrival, got %v %v", at, ok)
+	}
+	if !clock.maxEventTime.IsZero() {
+		t.Errorf("Expected a zero timestamp not to move the watermark, got %v", clock.maxEventTime)
+	}
+
+	// a backlog from ten minutes ago keeps its own time
+	backlog := now.Add(-10 * time.Minute)
+	at, ok = app.eventTime(clock, &ParsedLog{Time: backlog}, now)
+	if !ok || !at.Equal(backlog) {
+		t.Errorf("Expected the event time, got %v %v", at, ok)
+	}
+	if want := backlog.Add(-time.Minute); !app.Metric.EventTime.Watermark.Equal(want) {
+		t.Errorf("Expected watermark %v, got %v", want, app.Metric.EventTime.Watermark)
+	}
+
+	// within the allowed lateness
+	if _, ok := app.eventTime(clock, &ParsedLog{Time: backlog.Add(-30 * time.Second)}, now); !ok {
+		t.Error("Expected a line 30s behind to be on time")
+	}
+	// behind the watermark
+	if _, ok := app.eventTime(clock, &ParsedLog{Time: backlog.Add(-2 * time.Minute)}, now); ok {
+		t.Error("Expected a line 2m behind to be late")
+	}
+
+	// zero time and a skewed clock go in at the newest event time
+	for _, l := range []*ParsedLog{{}, {Time: now.Add(time.Hour)}} {
+		at, ok := app.eventTime(clock, l, now)
+		if !ok || !at.Equal(backlog) {
+			t.Errorf("Expected %v at the newest event time, got %v %v", l.Time, at, ok)
+		}
+	}
+
+	stats := app.Metric.EventTime
+	if stats.LateEvents != 1 || stats.ZeroTimeEvents != 2 || stats.FutureEvents != 1 {
+		t.Errorf("Unexpected counters %+v", stats)
+	}
+	if stats.AllowedLateness != time.Minute {
+		t.Errorf("Expected allowed lateness 1m, got %v", stats.AllowedLateness)
+	}
+}
+
+func TestApp_NewEventClock(t *testing.T) {
+	app := createTestAppForMetrics()
+	if c := app.newEventClock(); c.lateness != defaultAllowedLateness {
+		t.Errorf("Expected the default lateness, got %v", c.lateness)
+	}
+	app.Config = &Config{AllowedLateness: 30 * time.Second}
+	if c := app.newEventClock(); c.lateness != 30*time.Second {
+		t.Errorf("Expected 30s lateness, got %v", c.lateness)
+	}
+}
+
+func TestTimeWindows_RecordOlderThanRing(t *testing.T) {
+	w := NewTimeWindows()
+	now := time.Date(2025, 7, 19, 12, 0, 0, 0, time.UTC)
+
+	w.Record(now, &ParsedLog{Status: 200})
+	// same ring slot one lap earlier must not wipe the newer bucket
+	w.Record(now.Add(-maxWindow), &ParsedLog{Status: 500})
+
+	view, _ := w.View(now, windowBucketSpan)
+	if view.TotalRequests != 1 || view.Status5xx != 0 {
+		t.Errorf("Expected the newer bucket kept, got %+v", view)
+	}
+}
+
+func TestMetricsAggregator_EventTime(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.Config = &Config{AllowedLateness: time.Minute}
+	app.StartMetricsAggregator()
+
+	// a retried backlog from 20 minutes ago, then live traffic, then a straggler
+	backlog := createTestParsedLog(500, "GET", "/test", "", "web.1", 10*time.Millisecond, false)
+	backlog.Time = time.Now().Add(-20 * time.Minute)
+	app.MetricChan <- backlog
+	for i := 0; i < 3; i++ {
+		app.MetricChan <- createTestParsedLog(200, "GET", "/test", "", "web.1", 10*time.Millisecond, false)
+	}
+	late := createTestParsedLog(500, "GET", "/test", "", "web.1", 10*time.Millisecond, false)
+	late.Time = time.Now().Add(-5 * time.Minute)
+	app.MetricChan <- late
+	unparsed := createTestParsedLog(200, "GET", "/test", "", "web.1", 10*time.Millisecond, false)
+	unparsed.Time = time.Time{}
+	app.MetricChan <- unparsed
+	time.Sleep(100 * time.Millisecond)
+
+	snapshot := app.GetMetricsSnapshot()
+	if snapshot.TotalRequests != 6 {
+		t.Errorf("Expected every line in the totals, got %d", snapshot.TotalRequests)
+	}
+	if snapshot.EventTime.LateEvents != 1 || snapshot.EventTime.ZeroTimeEvents != 1 {
+		t.Errorf("Unexpected event time counters %+v", snapshot.EventTime)
+	}
+	// the backlog sits 20 minutes back, the late line is dropped
+	if got := snapshot.Windows["15m"]; got.TotalRequests != 4 || got.Status5xx != 0 {
+		t.Errorf("Expected 4 clean requests in the 15m window, got %+v", got)
+	}
+	if got := snapshot.Windows["1h"]; got.TotalRequests != 5 || got.Status5xx != 1 {
+		t.Errorf("Expected the backlog in the 1h window, got %+v", got)
+	}
+}
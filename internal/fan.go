@@ -5,7 +5,24 @@ import "log"
 func (a *App) FanOut() {
 	for l := range a.ParsedLogChan {
 		// Block on MetricChan - metrics are critical
-		a.MetricChan <- l
+		switch l.Source {
+		case SourceRouter:
+			// set before the DB copy so stored lines carry the route too
+			l.Route = a.routes().Normalize(l.Path)
+			a.MetricChan <- l
+		case SourceRuntime, SourceLifecycle:
+			a.MetricChan <- l
+		case SourcePostgres, SourceRedis:
+			if l.Database != nil {
+				a.MetricChan <- l
+			}
+		case SourceApp:
+			if a.hasFieldMetrics(l) {
+				a.MetricChan <- l
+			}
+		case SourceUnparsed:
+			a.UnparsedCount.Add(1)
+		}
 
 		// Non-blocking send to DB with proper logging
 		select {
@@ -13,5 +30,6 @@ func (a *App) FanOut() {
 		default:
 			log.Printf("WARNING: DbRawWriteChan full, log data may be lost from %s", l.SourceDyno)
 		}
+		a.OTLP.Export(l)
 	}
 }
@@ -88,13 +88,11 @@ func BuildParsedLog(d map[string]string) *ParsedLog {
 	} else {
 		isSlow = false
 	}
-	timestamp, err := time.Parse(time.RFC3339Nano, d["timestamp"])
-	if err != nil {
-		if d["timestamp"] != "" {
-			log.Printf("Invalid timestamp format: %s, error: %v", d["timestamp"], err)
-		}
-		// Return zero time instead of nil
-		timestamp = time.Time{}
+	timestamp := parseLogTime(d["timestamp"])
+
+	errorDesc := d["desc"]
+	if errorDesc == "" && d["code"] != "" {
+		errorDesc = routerErrorDescription(d["code"])
 	}
 
 	return &ParsedLog{
@@ -108,15 +106,30 @@ func BuildParsedLog(d map[string]string) *ParsedLog {
 		Method:       d["method"],
 		Path:         d["path"],
 		Protocol:     d["protocol"],
+		ReqId:        d["request_id"],
 		ResponseTime: responseTime,
 		Status:       status,
 		Success:      success,
 		Threshold:    threshold,
 		IsSlow:       isSlow,
+		ErrorCode:    d["code"],
+		ErrorDesc:    errorDesc,
 	}
 
 }
 
+// Returns zero time instead of failing on a bad timestamp
+func parseLogTime(s string) time.Time {
+	timestamp, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		if s != "" {
+			log.Printf("Invalid timestamp format: %s, error: %v", s, err)
+		}
+		return time.Time{}
+	}
+	return timestamp
+}
+
 func ClassifyResTime(s time.Duration) string {
 	switch {
 	case s <= HealthyThreshold:
@@ -135,18 +135,19 @@ func TestParseDuration(t *testing.T) {
 func TestBuildParsedLog(t *testing.T) {
 	t.Run("valid log data", func(t *testing.T) {
 		data := map[string]string{
-			"bytes":     "1024",
-			"status":    "200",
-			"service":   "150ms",
-			"connect":   "10ms",
-			"timestamp": "2023-07-19T10:30:45.123456789Z",
-			"at":        "info",
-			"dyno":      "web.1",
-			"fwd":       "192.168.1.1",
-			"host":      "example.com",
-			"method":    "GET",
-			"path":      "/api/users",
-			"protocol":  "HTTP/1.1",
+			"bytes":      "1024",
+			"status":     "200",
+			"service":    "150ms",
+			"connect":    "10ms",
+			"timestamp":  "2023-07-19T10:30:45.123456789Z",
+			"at":         "info",
+			"dyno":       "web.1",
+			"fwd":        "192.168.1.1",
+			"host":       "example.com",
+			"method":     "GET",
+			"path":       "/api/users",
+			"protocol":   "HTTP/1.1",
+			"request_id": "abc-123",
 		}
 
 		result := BuildParsedLog(data)
@@ -199,6 +200,10 @@ func TestBuildParsedLog(t *testing.T) {
 		if result.SourceIp != "192.168.1.1" {
 			t.Errorf("Expected IP '192.168.1.1', got %q", result.SourceIp)
 		}
+
+		if result.ReqId != "abc-123" {
+			t.Errorf("Expected request id 'abc-123', got %q", result.ReqId)
+		}
 	})
 
 	t.Run("error status codes", func(t *testing.T) {
@@ -277,6 +282,33 @@ func TestBuildParsedLog(t *testing.T) {
 		}
 	})
 
+	t.Run("router error code", func(t *testing.T) {
+		data := map[string]string{
+			"at":        "error",
+			"code":      "H12",
+			"desc":      "Request timeout",
+			"status":    "503",
+			"timestamp": "2023-07-19T10:30:45Z",
+		}
+
+		result := BuildParsedLog(data)
+
+		if result.ErrorCode != "H12" {
+			t.Errorf("Expected code H12, got %q", result.ErrorCode)
+		}
+		if result.ErrorDesc != "Request timeout" {
+			t.Errorf("Expected desc Request timeout, got %q", result.ErrorDesc)
+		}
+	})
+
+	t.Run("router error code without desc", func(t *testing.T) {
+		result := BuildParsedLog(map[string]string{"at": "error", "code": "H10"})
+
+		if result.ErrorDesc != "App crashed" {
+			t.Errorf("Expected heroku description for H10, got %q", result.ErrorDesc)
+		}
+	})
+
 	t.Run("missing fields", func(t *testing.T) {
 		data := map[string]string{
 			"timestamp": "2023-07-19T10:30:45Z",
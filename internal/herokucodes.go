@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Router error codes as documented by heroku (devcenter "Heroku Error Codes")
+var routerErrorDescriptions = map[string]string{
+	"H10": "App crashed",
+	"H11": "Backlog too deep",
+	"H12": "Request timeout",
+	"H13": "Connection closed without response",
+	"H14": "No web dynos running",
+	"H15": "Idle connection",
+	"H16": "Redirect to herokuapp.com",
+	"H17": "Poorly formatted HTTP response",
+	"H18": "Server Request Interrupted",
+	"H19": "Backend connection timeout",
+	"H20": "App boot timeout",
+	"H21": "Backend connection refused",
+	"H22": "Connection limit reached",
+	"H23": "Endpoint misconfigured",
+	"H24": "Forced close",
+	"H25": "HTTP Restriction",
+	"H26": "Request Error",
+	"H27": "Client Request Interrupted",
+	"H28": "Client Connection Idle",
+	"H31": "Misdirected Request",
+	"H80": "Maintenance mode",
+	"H81": "Blank app",
+	"H82": "Free dyno quota exhausted",
+	"H83": "Planned Service Degradation",
+	"H99": "Platform error",
+}
+
+// caused by the client or by us on purpose, a spike is not worth an alert
+var quietRouterErrors = map[string]struct{}{
+	"H27": {},
+	"H28": {},
+	"H80": {},
+	"H81": {},
+}
+
+const (
+	routerErrorWindow   = 1 * time.Minute
+	routerErrorWarning  = 5
+	routerErrorCritical = 20
+)
+
+func routerErrorDescription(code string) string {
+	if desc, ok := routerErrorDescriptions[code]; ok {
+		return desc
+	}
+	return "Unknown router error"
+}
+
+// RouterErrorTracker keeps the occurrences of each H-code inside routerErrorWindow,
+// fed per router line by the aggregator and pruned on the alert engine tick
+type RouterErrorTracker struct {
+	times map[string][]time.Time
+}
+
+func NewRouterErrorTracker() *RouterErrorTracker {
+	return &RouterErrorTracker{times: make(map[string][]time.Time)}
+}
+
+// Track records code, if any, and ages out the occurrences before the window
+func (t *RouterErrorTracker) Track(code string, now time.Time) {
+	if t == nil {
+		return
+	}
+	if code != "" {
+		t.times[code] = append(t.times[code], now)
+	}
+	cutoff := now.Add(-routerErrorWindow)
+	for c, times := range t.times {
+		i := sort.Search(len(times), func(i int) bool { return times[i].After(cutoff) })
+		if i == len(times) {
+			delete(t.times, c)
+			continue
+		}
+		t.times[c] = times[i:]
+	}
+}
+
+func (t *RouterErrorTracker) Recent() map[string]int64 {
+	recent := make(map[string]int64)
+	if t == nil {
+		return recent
+	}
+	for c, times := range t.times {
+		recent[c] = int64(len(times))
+	}
+	return recent
+}
+
+func (a *App) generateRouterErrorAlerts(currentTime time.Time) {
+	codes := make([]string, 0, len(a.Metric.RecentRouterErrors))
+	for code := range a.Metric.RecentRouterErrors {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	for _, code := range codes {
+		count := a.Metric.RecentRouterErrors[code]
+		if _, quiet := quietRouterErrors[code]; quiet || count < routerErrorWarning {
+			continue
+		}
+		alert := Alert{
+			Type:      "router_error",
+			Code:      code,
+			Severity:  "warning",
+			Message:   fmt.Sprintf("%s %s: %d in the last minute", code, routerErrorDescription(code), count),
+			Timestamp: currentTime,
+			Resolved:  false,
+		}
+		if count >= routerErrorCritical {
+			alert.Severity = "critical"
+		}
+		a.raiseAlert(alert)
+	}
+
+	// codes back under the warning threshold
+	a.resolveAlert(func(e Alert) bool {
+		return e.Type == "router_error" && a.Metric.RecentRouterErrors[e.Code] < routerErrorWarning
+	}, currentTime)
+}
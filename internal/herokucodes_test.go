@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouterErrorDescription(t *testing.T) {
+	if got := routerErrorDescription("H13"); got != "Connection closed without response" {
+		t.Errorf("Expected H13 description, got %q", got)
+	}
+	if got := routerErrorDescription("H00"); got != "Unknown router error" {
+		t.Errorf("Expected fallback description, got %q", got)
+	}
+}
+
+func TestRouterErrorTracker(t *testing.T) {
+	tracker := NewRouterErrorTracker()
+	start := time.Now()
+
+	tracker.Track("H12", start)
+	tracker.Track("H12", start.Add(10*time.Second))
+	tracker.Track("H10", start.Add(20*time.Second))
+
+	recent := tracker.Recent()
+	if recent["H12"] != 2 || recent["H10"] != 1 {
+		t.Fatalf("Expected H12=2 H10=1, got %v", recent)
+	}
+
+	// a request without a code still ages out old errors
+	tracker.Track("", start.Add(65*time.Second))
+	recent = tracker.Recent()
+	if recent["H12"] != 1 {
+		t.Errorf("Expected the first H12 to age out, got %d", recent["H12"])
+	}
+
+	tracker.Track("", start.Add(2*time.Minute))
+	if len(tracker.Recent()) != 0 {
+		t.Errorf("Expected all errors to age out, got %v", tracker.Recent())
+	}
+}
+
+func TestApp_generateRouterErrorAlerts(t *testing.T) {
+	t.Run("spike raises warning with heroku description", func(t *testing.T) {
+		app := createTestAppForMetrics()
+		app.Metric = &Metric{
+			RecentRouterErrors: map[string]int64{"H12": 6, "H10": 1},
+			ActiveAlerts:       []Alert{},
+		}
+
+		app.generateAlerts()
+
+		if len(app.Metric.ActiveAlerts) != 1 {
+			t.Fatalf("Expected 1 alert, got %d", len(app.Metric.ActiveAlerts))
+		}
+		alert := app.Metric.ActiveAlerts[0]
+		if alert.Type != "router_error" || alert.Code != "H12" {
+			t.Errorf("Expected router_error H12, got %s %s", alert.Type, alert.Code)
+		}
+		if alert.Severity != "warning" {
+			t.Errorf("Expected warning severity, got %s", alert.Severity)
+		}
+		if !strings.Contains(alert.Message, "Request timeout") {
+			t.Errorf("Expected heroku description in message, got %q", alert.Message)
+		}
+	})
+
+	t.Run("large spike is critical and updates existing alert", func(t *testing.T) {
+		app := createTestAppForMetrics()
+		app.Metric = &Metric{
+			RecentRouterErrors: map[string]int64{"H13": 25},
+			ActiveAlerts: []Alert{
+				{Type: "router_error", Code: "H13", Severity: "warning"},
+				{Type: "router_error", Code: "H10", Severity: "warning"},
+			},
+		}
+
+		app.generateAlerts()
+
+		if len(app.Metric.ActiveAlerts) != 2 {
+			t.Fatalf("Expected 2 alerts, got %d", len(app.Metric.ActiveAlerts))
+		}
+		if app.Metric.ActiveAlerts[0].Severity != "critical" {
+			t.Errorf("Expected H13 alert to become critical, got %s", app.Metric.ActiveAlerts[0].Severity)
+		}
+	})
+
+	t.Run("client side codes are ignored", func(t *testing.T) {
+		app := createTestAppForMetrics()
+		app.Metric = &Metric{
+			RecentRouterErrors: map[string]int64{"H27": 100},
+			ActiveAlerts:       []Alert{},
+		}
+
+		app.generateAlerts()
+
+		if len(app.Metric.ActiveAlerts) != 0 {
+			t.Errorf("Expected no alert for H27, got %d", len(app.Metric.ActiveAlerts))
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heroku[web.N]: dyno manager lines, state changes, restarts and process exits
+
+const maxDynoTransitions = 10 // per dyno, oldest dropped first
+
+// LifecycleEvent is one dyno manager line
+type LifecycleEvent struct {
+	Kind       string `json:"kind"` // "state", "exit", "start", "restart", "stop"
+	From       string `json:"from,omitempty"`
+	To         string `json:"to,omitempty"`
+	ExitStatus int    `json:"exit_status,omitempty"`
+}
+
+type DynoTransition struct {
+	From string    `json:"from"`
+	To   string    `json:"to"`
+	At   time.Time `json:"at"`
+}
+
+type lifecycleParser struct{}
+
+func (lifecycleParser) Source() string { return SourceLifecycle }
+
+func (lifecycleParser) Match(h *SyslogHeader) bool {
+	return h.AppName == "heroku" && isDynoName(h.ProcId)
+}
+
+func (lifecycleParser) Parse(h *SyslogHeader, msg string, kv map[string]string) *ParsedLog {
+	e := parseLifecycleEvent(msg)
+	if e == nil {
+		return nil
+	}
+	l := newSourceLog(h, msg)
+	l.SourceDyno = h.ProcId
+	l.Lifecycle = e
+	return l
+}
+
+// "State changed from up to crashed"
+// "Process exited with status 137"
+// "Starting process with command `bundle exec puma`"
+// "Restarting", "Cycling"
+// "Stopping all processes with SIGTERM"
+func parseLifecycleEvent(msg string) *LifecycleEvent {
+	switch {
+	case strings.HasPrefix(msg, "State changed from "):
+		from, to, ok := strings.Cut(strings.TrimPrefix(msg, "State changed from "), " to ")
+		if !ok || from == "" || to == "" {
+			return nil
+		}
+		return &LifecycleEvent{Kind: "state", From: from, To: strings.TrimSpace(to)}
+	case strings.HasPrefix(msg, "Process exited with status "):
+		status, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(msg, "Process exited with status ")))
+		if err != nil {
+			return nil
+		}
+		return &LifecycleEvent{Kind: "exit", ExitStatus: status}
+	case strings.HasPrefix(msg, "Starting process with command"):
+		return &LifecycleEvent{Kind: "start"}
+	case msg == "Restarting" || msg == "Cycling":
+		return &LifecycleEvent{Kind: "restart"}
+	case strings.HasPrefix(msg, "Stopping all processes"):
+		return &LifecycleEvent{Kind: "stop"}
+	}
+	return nil
+}
+
+// called by the aggregator with MetricsMu held
+func (a *App) applyLifecycleLog(l *ParsedLog, now time.Time) {
+	e := l.Lifecycle
+	if e == nil || l.SourceDyno == "" {
+		return
+	}
+	dynoMetric, exists := a.Metric.DynoPerformance[l.SourceDyno]
+	if !exists {
+		dynoMetric = DynoMetric{
+			Name:   l.SourceDyno,
+			Status: "healthy",
+		}
+	}
+
+	switch e.Kind {
+	case "exit":
+		dynoMetric.LastExitStatus = e.ExitStatus
+	case "state":
+		// a restart passes through starting, the first boot comes from down
+		if e.To == "starting" && e.From != "down" {
+			dynoMetric.Restarts++
+		}
+		if e.To == "crashed" {
+			dynoMetric.Crashes++
+		}
+		dynoMetric.State = e.To
+		dynoMetric.StateChangedAt = now
+
+		transitions := append([]DynoTransition{}, dynoMetric.Transitions...)
+		transitions = append(transitions, DynoTransition{From: e.From, To: e.To, At: now})
+		if len(transitions) > maxDynoTransitions {
+			transitions = transitions[len(transitions)-maxDynoTransitions:]
+		}
+		dynoMetric.Transitions = transitions
+
+		if e.To == "crashed" {
+			dynoMetric.Status = "down"
+		} else if dynoMetric.Status == "down" {
+			dynoMetric.Status = "healthy"
+		}
+	}
+
+	a.Metric.DynoPerformance[l.SourceDyno] = dynoMetric
+}
+
+// critical dynoDown per crashed dyno, resolved once the dyno leaves the crashed state
+func (a *App) generateDynoDownAlerts(currentTime time.Time) {
+	names := make([]string, 0, len(a.Metric.DynoPerformance))
+	for name := range a.Metric.DynoPerformance {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		dm := a.Metric.DynoPerformance[name]
+		if dm.Status != "down" {
+			a.resolveAlert(func(e Alert) bool {
+				return e.Type == "dynoDown" && e.Dyno == name
+			}, currentTime)
+			continue
+		}
+		msg := fmt.Sprintf("%s crashed", name)
+		if dm.LastExitStatus != 0 {
+			msg += fmt.Sprintf(" (exit status %d)", dm.LastExitStatus)
+		}
+		a.raiseAlert(Alert{
+			Type:      "dynoDown",
+			Dyno:      name,
+			Severity:  "critical",
+			Message:   msg,
+			Timestamp: currentTime,
+			Resolved:  false,
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLifecycleEvent(t *testing.T) {
+	tests := []struct {
+		msg      string
+		expected *LifecycleEvent
+	}{
+		{"State changed from up to crashed", &LifecycleEvent{Kind: "state", From: "up", To: "crashed"}},
+		{"State changed from starting to up", &LifecycleEvent{Kind: "state", From: "starting", To: "up"}},
+		{"Process exited with status 137", &LifecycleEvent{Kind: "exit", ExitStatus: 137}},
+		{"Starting process with command `bundle exec puma -C config/puma.rb`", &LifecycleEvent{Kind: "start"}},
+		{"Restarting", &LifecycleEvent{Kind: "restart"}},
+		{"Cycling", &LifecycleEvent{Kind: "restart"}},
+		{"Stopping all processes with SIGTERM", &LifecycleEvent{Kind: "stop"}},
+		{"State changed from up", nil},
+		{"Process exited with status abc", nil},
+		{"Process running mem=1028M(200.9%)", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.msg, func(t *testing.T) {
+			got := parseLifecycleEvent(tt.msg)
+			if tt.expected == nil {
+				if got != nil {
+					t.Errorf("Expected nil, got %+v", got)
+				}
+				return
+			}
+			if got == nil || *got != *tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestLifecycleParser(t *testing.T) {
+	app := &App{ParsedLogChan: make(chan *ParsedLog, 1)}
+	app.ParseLog([]byte(`<45>1 2025-07-19T10:30:45Z host heroku web.2 - State changed from up to crashed`))
+
+	l := <-app.ParsedLogChan
+	if l.Source != SourceLifecycle {
+		t.Fatalf("Expected source %q, got %q", SourceLifecycle, l.Source)
+	}
+	if l.SourceDyno != "web.2" || l.Lifecycle == nil || l.Lifecycle.To != "crashed" {
+		t.Errorf("Unexpected lifecycle log %+v", l)
+	}
+}
+
+func findAlert(alerts []Alert, alertType, dyno string) *Alert {
+	for i := range alerts {
+		if alerts[i].Type == alertType && alerts[i].Dyno == dyno {
+			return &alerts[i]
+		}
+	}
+	return nil
+}
+
+func TestApp_LifecycleAggregation(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.Config = &Config{AlertResolveAfter: time.Nanosecond}
+	app.StartMetricsAggregator()
+
+	state := func(from, to string) *ParsedLog {
+		return &ParsedLog{Source: SourceLifecycle, SourceDyno: "web.1", Lifecycle: &LifecycleEvent{Kind: "state", From: from, To: to}}
+	}
+
+	app.MetricChan <- state("down", "starting")
+	app.MetricChan <- state("starting", "up")
+	app.MetricChan <- &ParsedLog{Source: SourceLifecycle, SourceDyno: "web.1", Lifecycle: &LifecycleEvent{Kind: "exit", ExitStatus: 137}}
+	app.MetricChan <- state("up", "crashed")
+	// a request logged after the crash must not flip the dyno back to healthy
+	app.MetricChan <- createTestParsedLog(200, "GET", "/test", "", "web.1", 50*time.Millisecond, false)
+
+	time.Sleep(100 * time.Millisecond)
+
+	metrics := app.GetMetricsSnapshot()
+	dm := metrics.DynoPerformance["web.1"]
+	if dm.Status != "down" || dm.State != "crashed" {
+		t.Errorf("Expected web.1 down and crashed, got %q %q", dm.Status, dm.State)
+	}
+	if dm.Crashes != 1 || dm.Restarts != 0 {
+		t.Errorf("Expected 1 crash and no restarts, got %d crashes %d restarts", dm.Crashes, dm.Restarts)
+	}
+	if dm.LastExitStatus != 137 {
+		t.Errorf("Expected last exit status 137, got %d", dm.LastExitStatus)
+	}
+	if len(dm.Transitions) != 3 {
+		t.Errorf("Expected 3 transitions, got %d", len(dm.Transitions))
+	}
+	alert := findAlert(metrics.ActiveAlerts, "dynoDown", "web.1")
+	if alert == nil || alert.Severity != "critical" || alert.Resolved {
+		t.Fatalf("Expected unresolved critical dynoDown alert, got %+v", alert)
+	}
+
+	app.MetricChan <- state("crashed", "starting")
+	app.MetricChan <- state("starting", "up")
+
+	time.Sleep(100 * time.Millisecond)
+
+	metrics = app.GetMetricsSnapshot()
+	dm = metrics.DynoPerformance["web.1"]
+	if dm.Status != "healthy" || dm.Restarts != 1 {
+		t.Errorf("Expected healthy after 1 restart, got %q with %d restarts", dm.Status, dm.Restarts)
+	}
+	alert = findAlert(metrics.ActiveAlerts, "dynoDown", "web.1")
+	if alert == nil || !alert.Resolved {
+		t.Errorf("Expected dynoDown alert resolved, got %+v", alert)
+	}
+}
+
+func TestApp_LifecycleTransitionsCapped(t *testing.T) {
+	app := &App{Metric: &Metric{DynoPerformance: make(map[string]DynoMetric)}}
+	for i := 0; i < maxDynoTransitions+5; i++ {
+		app.applyLifecycleLog(&ParsedLog{
+			SourceDyno: "worker.1",
+			Lifecycle:  &LifecycleEvent{Kind: "state", From: "up", To: "starting"},
+		}, time.Now())
+	}
+
+	dm := app.Metric.DynoPerformance["worker.1"]
+	if len(dm.Transitions) != maxDynoTransitions {
+		t.Errorf("Expected %d transitions, got %d", maxDynoTransitions, len(dm.Transitions))
+	}
+	if dm.Restarts != int64(maxDynoTransitions+5) {
+		t.Errorf("Expected %d restarts, got %d", maxDynoTransitions+5, dm.Restarts)
+	}
+}
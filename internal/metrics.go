@@ -1,7 +1,6 @@
 package internal
 
 import (
-	"sort"
 	"sync"
 	"time"
 )
@@ -24,13 +23,21 @@ the go routine then updates its own struct
 include a mutex lock since visualizer will need to read that struct to render a dashboard.
 
 */
-//Notes for later ; alert clearing on resolution
 
 // MetricsAggregator holds additional data needed for calculations
 type MetricsAggregator struct {
-	responseTimes []time.Duration
-	dynoErrors    map[string]int64 // Track errors per dyno
-	startTime     time.Time
+	dynoErrors map[string]int64 // Track errors per dyno
+	startTime  time.Time
+
+	latency       *LatencySketch  // since start, mergeable across snapshots
+	latencyWindow *windowedSketch // percentiles over LatencyWindow
+	dynoLatency   map[string]*windowedSketch
+	percentilesAt time.Time
+
+	clock *eventClock // watermark for the time bucketed metrics
+
+	apdex     ApdexCounts
+	dynoApdex map[string]*ApdexCounts
 }
 
 // A function to a go routine that will own a metrics instance
@@ -46,14 +53,30 @@ func (a *App) StartMetricsAggregator() {
 		DynoPerformance: make(map[string]DynoMetric),
 		TopEndpoints:    make(map[string]int64),
 		ActiveAlerts:    []Alert{},
+
+		RouterErrors:       make(map[string]int64),
+		RecentRouterErrors: make(map[string]int64),
+		DatabaseHealth:     make(map[string]DatabaseHealth),
+		FieldMetrics:       make(map[string]FieldMetric),
 	}
+	a.Windows = NewTimeWindows()
+	a.Endpoints = a.newEndpointStats()
+	a.HeavyHitters = a.newHeavyHitters()
+	a.RouterErrors = NewRouterErrorTracker()
 	a.MetricsMu.Unlock()
 
 	// Initialize aggregator
 	aggregator := &MetricsAggregator{
-		responseTimes: make([]time.Duration, 0, 1000),
-		dynoErrors:    make(map[string]int64),
-		startTime:     time.Now(),
+		dynoErrors: make(map[string]int64),
+		startTime:  time.Now(),
+
+		latency:       NewLatencySketch(),
+		latencyWindow: a.newLatencyWindow(),
+		dynoLatency:   make(map[string]*windowedSketch),
+
+		clock: a.newEventClock(),
+
+		dynoApdex: make(map[string]*ApdexCounts),
 	}
 
 	//classify requests and increment their counters
@@ -63,6 +86,41 @@ func (a *App) StartMetricsAggregator() {
 		for l := range a.MetricChan {
 			a.MetricsMu.Lock()
 
+			// dyno samples and R-codes only touch DynoPerformance
+			if l.Source == SourceRuntime {
+				a.applyRuntimeLog(l, time.Now())
+				a.generateAlerts()
+				a.Metric.Timestamp = time.Now()
+				a.MetricsMu.Unlock()
+				continue
+			}
+
+			// state changes only touch DynoPerformance
+			if l.Source == SourceLifecycle {
+				a.applyLifecycleLog(l, time.Now())
+				a.generateAlerts()
+				a.Metric.Timestamp = time.Now()
+				a.MetricsMu.Unlock()
+				continue
+			}
+
+			// JSON app logs only touch FieldMetrics
+			if l.Source == SourceApp {
+				a.applyFieldMetrics(l)
+				a.Metric.Timestamp = time.Now()
+				a.MetricsMu.Unlock()
+				continue
+			}
+
+			// add-on metrics only touch DatabaseHealth
+			if l.Source == SourcePostgres || l.Source == SourceRedis {
+				a.applyDatabaseLog(l, time.Now())
+				a.generateAlerts()
+				a.Metric.Timestamp = time.Now()
+				a.MetricsMu.Unlock()
+				continue
+			}
+
 			//classify status code
 			switch {
 			case l.Status >= 200 && l.Status < 300:
@@ -104,23 +162,25 @@ func (a *App) StartMetricsAggregator() {
 				a.Metric.OtherRequests++
 			}
 
-			// Track top 5 endpoints
-			if len(a.Metric.TopEndpoints) < 5 || a.Metric.TopEndpoints[l.Path] > 0 {
-				a.Metric.TopEndpoints[l.Path]++
+			// router H-codes, total and over the last minute
+			if l.ErrorCode != "" {
+				a.Metric.RouterErrors[l.ErrorCode]++
 			}
+			a.RouterErrors.Track(l.ErrorCode, time.Now())
+			a.Metric.RecentRouterErrors = a.RouterErrors.Recent()
 
-			if l.SourceIp != "" && a.GeoDb != nil {
-				ip := l.SourceIp
-				if len(ip) > 2 && ip[0] == '"' && ip[len(ip)-1] == '"' {
-					ip = ip[1 : len(ip)-1]
-				}
+			// Track top K endpoints, keyed by normalized route
+			route := l.Route
+			if route == "" {
+				route = a.routes().Normalize(l.Path)
+			}
+			a.HeavyHitters.endpoints.Add(route)
+			tracked := a.Endpoints.Record(route, l)
 
-				geoRecord := a.fingerPrintIp(ip)
+			if l.SourceIp != "" && a.GeoDb != nil {
+				geoRecord := a.fingerPrintIp(l.SourceIp)
 				if geoRecord.Country_short != "" {
-					// Limit to top 10 countries to control memory usage
-					if len(a.Metric.TopCountries) < 5 || a.Metric.TopCountries[geoRecord.Country_short] > 0 {
-						a.Metric.TopCountries[geoRecord.Country_short]++
-					}
+					a.HeavyHitters.countries.Add(geoRecord.Country_short)
 				}
 			}
 
@@ -137,11 +197,25 @@ func (a *App) StartMetricsAggregator() {
 				}
 
 				dynoMetric.RequestCount++
+				if l.ErrorCode != "" {
+					if dynoMetric.ErrorCodes == nil {
+						dynoMetric.ErrorCodes = make(map[string]int64)
+					}
+					dynoMetric.ErrorCodes[l.ErrorCode]++
+				}
 
 				// calc error rate for focus dyno
 				errorCount := aggregator.dynoErrors[l.SourceDyno]
 				dynoMetric.ErrorRate = (float64(errorCount) / float64(dynoMetric.RequestCount)) * 100
 
+				dynoApdex, ok := aggregator.dynoApdex[l.SourceDyno]
+				if !ok {
+					dynoApdex = &ApdexCounts{}
+					aggregator.dynoApdex[l.SourceDyno] = dynoApdex
+				}
+				dynoApdex.Add(l.Status, l.ResponseTime)
+				dynoMetric.Apdex = dynoApdex.Score()
+
 				if dynoMetric.AvgResponseTime == 0 {
 					dynoMetric.AvgResponseTime = l.ResponseTime
 				} else {
@@ -150,25 +224,51 @@ func (a *App) StartMetricsAggregator() {
 					dynoMetric.AvgResponseTime = time.Duration(float64(dynoMetric.AvgResponseTime)*(1-alpha) + float64(l.ResponseTime)*alpha)
 				}
 
-				// per dyno health
-				if dynoMetric.ErrorRate > 10.0 || dynoMetric.AvgResponseTime > 2*time.Second {
+				// per dyno health, a crashed dyno stays down until its state changes
+				switch {
+				case dynoMetric.Status == "down":
+				case dynoMetric.ErrorRate > 10.0 || dynoMetric.AvgResponseTime > 2*time.Second:
 					dynoMetric.Status = "critical"
-				} else if dynoMetric.ErrorRate > 5.0 || dynoMetric.AvgResponseTime > 1*time.Second {
+				case dynoMetric.ErrorRate > 5.0 || dynoMetric.AvgResponseTime > 1*time.Second:
 					dynoMetric.Status = "warning"
-				} else {
+				default:
 					dynoMetric.Status = "healthy"
 				}
 
 				a.Metric.DynoPerformance[l.SourceDyno] = dynoMetric
 			}
 
-			// response times for percentile calculations
-			aggregator.responseTimes = append(aggregator.responseTimes, l.ResponseTime)
+			// response times for percentile calculations, the windows go by event
+			// time and skip lines behind the watermark
+			now := time.Now()
+			aggregator.latency.Add(l.ResponseTime)
+			aggregator.apdex.Add(l.Status, l.ResponseTime)
+			a.Metric.Apdex = aggregator.apdex.Score()
+			at, onTime := a.eventTime(aggregator.clock, l, now)
+			// error budgets span days, late lines still count against them
+			a.SLOs.Record(at, route, l)
+			if onTime {
+				a.Windows.Record(at, l)
+				a.AlertEngine.Record(at, tracked, l)
+				a.Anomalies.Record(at, l)
+				aggregator.latencyWindow.Add(at, l.ResponseTime)
+				if l.SourceDyno != "" {
+					dynoWindow, ok := aggregator.dynoLatency[l.SourceDyno]
+					if !ok {
+						dynoWindow = a.newLatencyWindow()
+						aggregator.dynoLatency[l.SourceDyno] = dynoWindow
+					}
+					dynoWindow.Add(at, l.ResponseTime)
+				}
+			}
 
-			// Calculate percentiles every 300 requests
-			if len(aggregator.responseTimes) >= 300 {
-				a.calculatePercentiles(aggregator)
+			// percentiles and SLOs at most every percentileInterval, the average is cheap
+			if now.Sub(aggregator.percentilesAt) >= percentileInterval {
+				a.calculatePercentiles(aggregator, now)
+				a.Metric.SLOs = a.SLOs.Status(now)
+				aggregator.percentilesAt = now
 			}
+			a.Metric.AvgResponseTime = aggregator.latencyWindow.Mean(now)
 
 			// success/error rates
 			if a.Metric.TotalRequests > 0 {
@@ -182,14 +282,6 @@ func (a *App) StartMetricsAggregator() {
 				a.Metric.RequestsPerSecond = float64(a.Metric.TotalRequests) / elapsed
 			}
 
-			if len(aggregator.responseTimes) > 0 {
-				var total time.Duration
-				for _, rt := range aggregator.responseTimes {
-					total += rt
-				}
-				a.Metric.AvgResponseTime = total / time.Duration(len(aggregator.responseTimes))
-			}
-
 			a.generateAlerts()
 			a.updateChannelHealth()
 			a.Metric.Timestamp = time.Now()
@@ -201,88 +293,118 @@ func (a *App) StartMetricsAggregator() {
 	initWg.Wait()
 }
 
-func (a *App) calculatePercentiles(aggregator *MetricsAggregator) {
-	if len(aggregator.responseTimes) == 0 {
-		return
-	}
-	sortedTimes := make([]time.Duration, len(aggregator.responseTimes))
-	copy(sortedTimes, aggregator.responseTimes)
-	sort.Slice(sortedTimes, func(i, j int) bool {
-		return sortedTimes[i] < sortedTimes[j]
-	})
-	length := len(sortedTimes)
-	a.Metric.P50ResponseTime = sortedTimes[length*50/100]
-	a.Metric.P95ResponseTime = sortedTimes[length*95/100]
-	a.Metric.P99ResponseTime = sortedTimes[length*99/100]
-	if len(aggregator.responseTimes) > 1000 {
-		aggregator.responseTimes = aggregator.responseTimes[len(aggregator.responseTimes)-500:]
+// percentiles over the latency window, overall and per dyno
+func (a *App) calculatePercentiles(aggregator *MetricsAggregator, now time.Time) {
+	p := aggregator.latencyWindow.Merged(now).Quantiles(0.5, 0.9, 0.95, 0.99, 0.999)
+	a.Metric.P50ResponseTime = p[0]
+	a.Metric.P90ResponseTime = p[1]
+	a.Metric.P95ResponseTime = p[2]
+	a.Metric.P99ResponseTime = p[3]
+	a.Metric.P999ResponseTime = p[4]
+	a.Metric.LatencySketch = aggregator.latency.Clone()
+
+	for name, window := range aggregator.dynoLatency {
+		dynoMetric, ok := a.Metric.DynoPerformance[name]
+		if !ok {
+			continue
+		}
+		p := window.Merged(now).Quantiles(0.5, 0.95, 0.99)
+		dynoMetric.P50ResponseTime = p[0]
+		dynoMetric.P95ResponseTime = p[1]
+		dynoMetric.P99ResponseTime = p[2]
+		a.Metric.DynoPerformance[name] = dynoMetric
 	}
 }
 
 func (a *App) generateAlerts() {
-	currentTime := time.Now()
+	a.generateAlertsAt(time.Now())
+}
 
+// the alerts driven by log lines, also run on the alert engine tick so they
+// resolve once the lines stop, see evaluateLogAlerts
+func (a *App) generateAlertsAt(currentTime time.Time) {
 	//delete alerts
 	var activeAlerts []Alert
 	for _, alert := range a.Metric.ActiveAlerts {
-		if !alert.Resolved || time.Since(alert.Timestamp) < time.Hour {
+		if !alert.Resolved || currentTime.Sub(alert.Timestamp) < time.Hour {
 			activeAlerts = append(activeAlerts, alert)
 		}
 	}
 	a.Metric.ActiveAlerts = activeAlerts
 
-	// high error rate alert
-	if a.Metric.ErrorRate > 5.0 {
-		alert := Alert{
-			Type:      "high_error_rate",
-			Severity:  "warning",
-			Message:   "Error rate is above 5%",
-			Timestamp: currentTime,
-			Resolved:  false,
-		}
-		if a.Metric.ErrorRate > 10.0 {
-			alert.Severity = "critical"
-			alert.Message = "Error rate is above 10%"
-		}
+	// error rate and latency rules run on their own schedule, see alertrules.go
+
+	// spikes of router H-codes
+	a.generateRouterErrorAlerts(currentTime)
+
+	// R14/R15 per dyno
+	a.generateMemoryAlerts(currentTime)
 
-		//avoid same alerts
-		found := false
-		for i, existingAlert := range a.Metric.ActiveAlerts {
-			if existingAlert.Type == "high_error_rate" && !existingAlert.Resolved {
-				a.Metric.ActiveAlerts[i] = alert // Update existing
-				found = true
-				break
+	// postgres/redis thresholds
+	a.generateDatabaseAlerts(currentTime)
+
+	// crashed dynos
+	a.generateDynoDownAlerts(currentTime)
+
+	// error budget burn
+	a.generateSLOAlerts(currentTime)
+}
+
+// ages out the recent router errors and re-runs the line driven alerts
+func (a *App) evaluateLogAlerts(now time.Time) {
+	a.RouterErrors.Track("", now)
+	a.Metric.RecentRouterErrors = a.RouterErrors.Recent()
+	a.generateAlertsAt(now)
+}
+
+// replaces the unresolved alert with the same type and scope, avoids same alerts
+func (a *App) raiseAlert(alert Alert) {
+	a.upsertAlert(alert, func(e Alert) bool {
+		return e.Type == alert.Type && e.Code == alert.Code && e.Dyno == alert.Dyno && e.Database == alert.Database && e.SLO == alert.SLO && e.Endpoint == alert.Endpoint && e.Metric == alert.Metric
+	})
+}
+
+// a raised alert keeps the ID, fire time and ack of the one it updates, see alerts.go
+func (a *App) upsertAlert(alert Alert, same func(Alert) bool) {
+	alert.Silenced = a.silenced(alert, alert.Timestamp)
+	for i, existingAlert := range a.Metric.ActiveAlerts {
+		if same(existingAlert) && !existingAlert.Resolved {
+			alert.ID, alert.FiredAt = existingAlert.ID, existingAlert.FiredAt
+			alert.Acked, alert.AckedAt, alert.AckedBy = existingAlert.Acked, existingAlert.AckedAt, existingAlert.AckedBy
+			a.Metric.ActiveAlerts[i] = alert // Update existing
+			if alert.Severity != existingAlert.Severity {
+				a.persistAlert(alert)
+				a.notifyAlert(AlertFired, alert)
 			}
-		}
-		if !found {
-			a.Metric.ActiveAlerts = append(a.Metric.ActiveAlerts, alert)
+			return
 		}
 	}
+	alert.ID = newAlertID()
+	alert.FiredAt = alert.Timestamp
+	a.Metric.ActiveAlerts = append(a.Metric.ActiveAlerts, alert)
+	a.persistAlert(alert)
+	a.notifyAlert(AlertFired, alert)
+}
 
-	// slow response alert
-	if a.Metric.P95ResponseTime > 2*time.Second {
-		alert := Alert{
-			Type:      "slow_response",
-			Severity:  "warning",
-			Message:   "P95 response time is above 2 seconds",
-			Timestamp: currentTime,
-			Resolved:  false,
-		}
-		if a.Metric.P95ResponseTime > 5*time.Second {
-			alert.Severity = "critical"
-			alert.Message = "P95 response time is above 5 seconds"
+// resolves the unresolved alerts matching same once they stayed clear for alertResolveAfter
+func (a *App) resolveAlert(same func(Alert) bool, now time.Time) {
+	hold := a.alertResolveAfter()
+	for i := range a.Metric.ActiveAlerts {
+		alert := &a.Metric.ActiveAlerts[i]
+		if !same(*alert) || alert.Resolved {
+			continue
 		}
-		found := false
-		for i, existingAlert := range a.Metric.ActiveAlerts {
-			if existingAlert.Type == "slow_response" && !existingAlert.Resolved {
-				a.Metric.ActiveAlerts[i] = alert
-				found = true
-				break
-			}
+		if alert.clearedAt.IsZero() {
+			alert.clearedAt = now
 		}
-		if !found {
-			a.Metric.ActiveAlerts = append(a.Metric.ActiveAlerts, alert)
+		if now.Sub(alert.clearedAt) < hold {
+			continue
 		}
+		alert.Resolved = true
+		alert.ResolvedAt = now
+		alert.Timestamp = now
+		a.persistAlert(*alert)
+		a.notifyAlert(AlertResolved, *alert)
 	}
 }
 
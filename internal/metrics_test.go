@@ -1,6 +1,7 @@
 package internal
 
 import (
+	"math"
 	"sync"
 	"testing"
 	"time"
@@ -224,6 +225,35 @@ func TestApp_StartMetricsAggregator(t *testing.T) {
 		}
 	})
 
+	t.Run("router error code tracking", func(t *testing.T) {
+		app := createTestAppForMetrics()
+		app.StartMetricsAggregator()
+
+		for _, code := range []string{"H12", "H12", "H10"} {
+			l := createTestParsedLog(503, "GET", "/test", "", "web.1", 30*time.Second, false)
+			l.Level = "error"
+			l.ErrorCode = code
+			app.MetricChan <- l
+		}
+		app.MetricChan <- createTestParsedLog(200, "GET", "/test", "", "web.2", 50*time.Millisecond, false)
+
+		time.Sleep(100 * time.Millisecond)
+
+		metrics := app.GetMetricsSnapshot()
+		if metrics.RouterErrors["H12"] != 2 || metrics.RouterErrors["H10"] != 1 {
+			t.Errorf("Expected H12=2 H10=1, got %v", metrics.RouterErrors)
+		}
+		if metrics.RecentRouterErrors["H12"] != 2 {
+			t.Errorf("Expected 2 recent H12, got %d", metrics.RecentRouterErrors["H12"])
+		}
+		if metrics.DynoPerformance["web.1"].ErrorCodes["H12"] != 2 {
+			t.Errorf("Expected web.1 to have 2 H12, got %v", metrics.DynoPerformance["web.1"].ErrorCodes)
+		}
+		if metrics.DynoPerformance["web.2"].ErrorCodes != nil {
+			t.Errorf("Expected web.2 to have no error codes, got %v", metrics.DynoPerformance["web.2"].ErrorCodes)
+		}
+	})
+
 	t.Run("country tracking with geodb", func(t *testing.T) {
 		app := createTestAppForMetrics()
 
@@ -306,16 +336,35 @@ func TestApp_StartMetricsAggregator(t *testing.T) {
 	})
 }
 
+func createTestAggregator(now time.Time, responseTimes []time.Duration) *MetricsAggregator {
+	aggregator := &MetricsAggregator{
+		latency:       NewLatencySketch(),
+		latencyWindow: newWindowedSketch(defaultLatencyWindow, defaultLatencyWindow/latencyWindowSlots),
+		dynoLatency:   make(map[string]*windowedSketch),
+	}
+	for _, rt := range responseTimes {
+		aggregator.latency.Add(rt)
+		aggregator.latencyWindow.Add(now, rt)
+	}
+	return aggregator
+}
+
+// within the sketch's relative accuracy
+func assertDurationNear(t testing.TB, name string, got, expected time.Duration) {
+	t.Helper()
+	if diff := math.Abs(float64(got - expected)); diff > float64(expected)*defaultSketchAlpha*1.01 {
+		t.Errorf("Expected %s to be %v (±%.0f%%), got %v", name, expected, defaultSketchAlpha*100, got)
+	}
+}
+
 func TestApp_calculatePercentiles(t *testing.T) {
+	now := time.Now()
+
 	t.Run("empty response times", func(t *testing.T) {
 		app := createTestAppForMetrics()
 		app.Metric = &Metric{}
 
-		aggregator := &MetricsAggregator{
-			responseTimes: []time.Duration{},
-		}
-
-		app.calculatePercentiles(aggregator)
+		app.calculatePercentiles(createTestAggregator(now, nil), now)
 
 		if app.Metric.P50ResponseTime != 0 {
 			t.Errorf("Expected P50 to be 0 for empty response times, got %v", app.Metric.P50ResponseTime)
@@ -326,45 +375,34 @@ func TestApp_calculatePercentiles(t *testing.T) {
 		app := createTestAppForMetrics()
 		app.Metric = &Metric{}
 
-		responseTimes := make([]time.Duration, 100)
-		for i := 0; i < 100; i++ {
+		responseTimes := make([]time.Duration, 1000)
+		for i := 0; i < 1000; i++ {
 			responseTimes[i] = time.Duration(i+1) * time.Millisecond
 		}
 
-		aggregator := &MetricsAggregator{
-			responseTimes: responseTimes,
-		}
+		app.calculatePercentiles(createTestAggregator(now, responseTimes), now)
 
-		app.calculatePercentiles(aggregator)
-
-		if app.Metric.P50ResponseTime != 51*time.Millisecond {
-			t.Errorf("Expected P50 to be 51ms, got %v", app.Metric.P50ResponseTime)
-		}
-		if app.Metric.P95ResponseTime != 96*time.Millisecond {
-			t.Errorf("Expected P95 to be 96ms, got %v", app.Metric.P95ResponseTime)
-		}
-		if app.Metric.P99ResponseTime != 100*time.Millisecond {
-			t.Errorf("Expected P99 to be 100ms, got %v", app.Metric.P99ResponseTime)
+		assertDurationNear(t, "P50", app.Metric.P50ResponseTime, 500*time.Millisecond)
+		assertDurationNear(t, "P90", app.Metric.P90ResponseTime, 900*time.Millisecond)
+		assertDurationNear(t, "P95", app.Metric.P95ResponseTime, 950*time.Millisecond)
+		assertDurationNear(t, "P99", app.Metric.P99ResponseTime, 990*time.Millisecond)
+		assertDurationNear(t, "P999", app.Metric.P999ResponseTime, 999*time.Millisecond)
+		if app.Metric.LatencySketch == nil || app.Metric.LatencySketch.Count != 1000 {
+			t.Errorf("Expected lifetime sketch with 1000 values, got %+v", app.Metric.LatencySketch)
 		}
 	})
 
-	t.Run("response times pruning", func(t *testing.T) {
+	t.Run("old slots leave the window", func(t *testing.T) {
 		app := createTestAppForMetrics()
 		app.Metric = &Metric{}
 
-		responseTimes := make([]time.Duration, 1500)
-		for i := 0; i < 1500; i++ {
-			responseTimes[i] = time.Duration(i+1) * time.Millisecond
-		}
+		aggregator := createTestAggregator(now.Add(-time.Hour), []time.Duration{10 * time.Second, 10 * time.Second})
+		aggregator.latencyWindow.Add(now, 20*time.Millisecond)
+		app.calculatePercentiles(aggregator, now)
 
-		aggregator := &MetricsAggregator{
-			responseTimes: responseTimes,
-		}
-
-		app.calculatePercentiles(aggregator)
-
-		if len(aggregator.responseTimes) != 500 {
-			t.Errorf("Expected response times to be pruned to 500, got %d", len(aggregator.responseTimes))
+		assertDurationNear(t, "P99", app.Metric.P99ResponseTime, 20*time.Millisecond)
+		if app.Metric.LatencySketch.Count != 2 {
+			t.Errorf("Expected lifetime sketch to keep old values, got %d", app.Metric.LatencySketch.Count)
 		}
 	})
 
@@ -378,148 +416,29 @@ func TestApp_calculatePercentiles(t *testing.T) {
 			30 * time.Millisecond,
 		}
 
-		aggregator := &MetricsAggregator{
-			responseTimes: responseTimes,
-		}
-
-		app.calculatePercentiles(aggregator)
+		app.calculatePercentiles(createTestAggregator(now, responseTimes), now)
 
-		if app.Metric.P50ResponseTime != 20*time.Millisecond {
-			t.Errorf("Expected P50 to be 20ms for small dataset, got %v", app.Metric.P50ResponseTime)
-		}
+		assertDurationNear(t, "P50", app.Metric.P50ResponseTime, 20*time.Millisecond)
 	})
-}
 
-func TestApp_generateAlerts(t *testing.T) {
-	t.Run("high error rate warning alert", func(t *testing.T) {
+	t.Run("per dyno percentiles", func(t *testing.T) {
 		app := createTestAppForMetrics()
-		app.Metric = &Metric{
-			ErrorRate:    7.5,
-			ActiveAlerts: []Alert{},
-		}
-
-		app.generateAlerts()
+		app.Metric = &Metric{DynoPerformance: map[string]DynoMetric{"web.1": {Name: "web.1"}}}
 
-		if len(app.Metric.ActiveAlerts) != 1 {
-			t.Errorf("Expected 1 alert, got %d", len(app.Metric.ActiveAlerts))
+		aggregator := createTestAggregator(now, nil)
+		aggregator.dynoLatency["web.1"] = newWindowedSketch(defaultLatencyWindow, time.Minute)
+		for i := 1; i <= 100; i++ {
+			aggregator.dynoLatency["web.1"].Add(now, time.Duration(i)*time.Millisecond)
 		}
+		app.calculatePercentiles(aggregator, now)
 
-		alert := app.Metric.ActiveAlerts[0]
-		if alert.Type != "high_error_rate" {
-			t.Errorf("Expected high_error_rate alert, got %s", alert.Type)
-		}
-		if alert.Severity != "warning" {
-			t.Errorf("Expected warning severity, got %s", alert.Severity)
-		}
-		if alert.Resolved {
-			t.Error("Expected alert to not be resolved")
-		}
-	})
-
-	t.Run("high error rate critical alert", func(t *testing.T) {
-		app := createTestAppForMetrics()
-		app.Metric = &Metric{
-			ErrorRate:    15.0,
-			ActiveAlerts: []Alert{},
-		}
-
-		app.generateAlerts()
-
-		if len(app.Metric.ActiveAlerts) != 1 {
-			t.Errorf("Expected 1 alert, got %d", len(app.Metric.ActiveAlerts))
-		}
-
-		alert := app.Metric.ActiveAlerts[0]
-		if alert.Severity != "critical" {
-			t.Errorf("Expected critical severity, got %s", alert.Severity)
-		}
-		if alert.Message != "Error rate is above 10%" {
-			t.Errorf("Expected critical error rate message, got %s", alert.Message)
-		}
-	})
-
-	t.Run("slow response warning alert", func(t *testing.T) {
-		app := createTestAppForMetrics()
-		app.Metric = &Metric{
-			P95ResponseTime: 3 * time.Second,
-			ActiveAlerts:    []Alert{},
-		}
-
-		app.generateAlerts()
-
-		if len(app.Metric.ActiveAlerts) != 1 {
-			t.Errorf("Expected 1 alert, got %d", len(app.Metric.ActiveAlerts))
-		}
-
-		alert := app.Metric.ActiveAlerts[0]
-		if alert.Type != "slow_response" {
-			t.Errorf("Expected slow_response alert, got %s", alert.Type)
-		}
-		if alert.Severity != "warning" {
-			t.Errorf("Expected warning severity, got %s", alert.Severity)
-		}
-	})
-
-	t.Run("slow response critical alert", func(t *testing.T) {
-		app := createTestAppForMetrics()
-		app.Metric = &Metric{
-			P95ResponseTime: 6 * time.Second,
-			ActiveAlerts:    []Alert{},
-		}
-
-		app.generateAlerts()
-
-		alert := app.Metric.ActiveAlerts[0]
-		if alert.Severity != "critical" {
-			t.Errorf("Expected critical severity, got %s", alert.Severity)
-		}
-		if alert.Message != "P95 response time is above 5 seconds" {
-			t.Errorf("Expected critical response time message, got %s", alert.Message)
-		}
-	})
-
-	t.Run("multiple alerts", func(t *testing.T) {
-		app := createTestAppForMetrics()
-		app.Metric = &Metric{
-			ErrorRate:       15.0,
-			P95ResponseTime: 6 * time.Second,
-			ActiveAlerts:    []Alert{},
-		}
-
-		app.generateAlerts()
-
-		if len(app.Metric.ActiveAlerts) != 2 {
-			t.Errorf("Expected 2 alerts, got %d", len(app.Metric.ActiveAlerts))
-		}
-	})
-
-	t.Run("update existing alert", func(t *testing.T) {
-		app := createTestAppForMetrics()
-		existingAlert := Alert{
-			Type:      "high_error_rate",
-			Severity:  "warning",
-			Message:   "Error rate is above 5%",
-			Timestamp: time.Now().Add(-1 * time.Hour),
-			Resolved:  false,
-		}
-
-		app.Metric = &Metric{
-			ErrorRate:    15.0,
-			ActiveAlerts: []Alert{existingAlert},
-		}
-
-		app.generateAlerts()
-
-		if len(app.Metric.ActiveAlerts) != 1 {
-			t.Errorf("Expected 1 alert after update, got %d", len(app.Metric.ActiveAlerts))
-		}
-
-		alert := app.Metric.ActiveAlerts[0]
-		if alert.Severity != "critical" {
-			t.Errorf("Expected alert to be updated to critical, got %s", alert.Severity)
-		}
+		dm := app.Metric.DynoPerformance["web.1"]
+		assertDurationNear(t, "web.1 P50", dm.P50ResponseTime, 50*time.Millisecond)
+		assertDurationNear(t, "web.1 P99", dm.P99ResponseTime, 99*time.Millisecond)
 	})
+}
 
+func TestApp_generateAlerts(t *testing.T) {
 	t.Run("alert cleanup", func(t *testing.T) {
 		app := createTestAppForMetrics()
 		oldAlert := Alert{
@@ -774,8 +693,9 @@ func TestMetricsAggregator_Integration(t *testing.T) {
 			t.Errorf("Expected 50%% error rate, got %f", app.Metric.ErrorRate)
 		}
 
-		if app.Metric.TopEndpoints["/api/users"] != 2 {
-			t.Errorf("Expected 2 requests to /api/users, got %d", app.Metric.TopEndpoints["/api/users"])
+		// ranked on read
+		if top := app.GetMetricsSnapshot().TopEndpoints; top["/api/users"] != 2 {
+			t.Errorf("Expected 2 requests to /api/users, got %d", top["/api/users"])
 		}
 
 		web1Dyno := app.Metric.DynoPerformance["web.1"]
@@ -812,18 +732,16 @@ func BenchmarkApp_calculatePercentiles(b *testing.B) {
 	app := createTestAppForMetrics()
 	app.Metric = &Metric{}
 
+	now := time.Now()
 	responseTimes := make([]time.Duration, 1000)
 	for i := 0; i < 1000; i++ {
 		responseTimes[i] = time.Duration(i+1) * time.Millisecond
 	}
-
-	aggregator := &MetricsAggregator{
-		responseTimes: responseTimes,
-	}
+	aggregator := createTestAggregator(now, responseTimes)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		app.calculatePercentiles(aggregator)
+		app.calculatePercentiles(aggregator, now)
 	}
 }
 
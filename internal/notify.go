@@ -0,0 +1,400 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Alert notifications, loaded from a JSON file:
+//
+//	{
+//	  "notifiers": [
+//	    {"name": "ops", "type": "slack", "url": "https://hooks.slack.com/services/...", "min_severity": "critical"},
+//	    {"name": "db-team", "type": "teams", "url": "https://example.webhook.office.com/...", "types": ["db_connections", "db_memory"]},
+//	    {"name": "pager", "type": "webhook", "url": "https://example.com/hook", "headers": {"Authorization": "Bearer ..."}},
+//	    {"name": "mail", "type": "email", "smtp": {"addr": "smtp.example.com:587", "from": "alerts@example.com", "to": ["ops@example.com"]}}
+//	  ]
+//	}
+//
+// Every notifier has its own queue and goroutine so a slow endpoint only
+// delays itself. Fire events of unsilenced alerts are routed by alert type and
+// severity, a resolve goes to every notifier that delivered a fire for the
+// alert whatever its severity by then. A notifier skips events it already
+// delivered for the same alert, sends at most rate_limit per minute and retries
+// failed deliveries with exponential backoff.
+
+const (
+	notifierQueueSize        = 100
+	defaultNotifierRateLimit = 30 // per minute
+	defaultNotifierRetries   = 3
+	defaultNotifierBackoff   = 1 * time.Second
+	notifierTimeout          = 10 * time.Second
+
+	// fires remembered for the resolve, alerts open longer are forgotten
+	notifierDeliveredTTL = 7 * 24 * time.Hour
+	maxNotifierDelivered = 1000
+
+	AlertFired    = "fired"
+	AlertResolved = "resolved"
+)
+
+type SMTPConfig struct {
+	Addr     string   `json:"addr"` // host:port
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+type NotifierConfig struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"` // "webhook", "slack", "teams", "email"
+	URL         string            `json:"url,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+	SMTP        *SMTPConfig       `json:"smtp,omitempty"`
+	Types       []string          `json:"types,omitempty"`        // alert types routed here, empty for all
+	MinSeverity string            `json:"min_severity,omitempty"` // "warning" (default), "critical"
+	RateLimit   int               `json:"rate_limit,omitempty"`   // per minute
+	Retries     *int              `json:"retries,omitempty"`
+	Backoff     string            `json:"backoff,omitempty"` // first retry delay, doubles after
+}
+
+type NotifiersFile struct {
+	Notifiers []NotifierConfig `json:"notifiers"`
+}
+
+// AlertEvent is what the generic webhook receives
+type AlertEvent struct {
+	Event string    `json:"event"` // AlertFired, AlertResolved
+	Alert Alert     `json:"alert"`
+	At    time.Time `json:"at"`
+}
+
+// failures not worth retrying, a 4xx other than 429
+var errPermanent = errors.New("permanent failure")
+
+type Notifier struct {
+	NotifierConfig
+	send    func(ev AlertEvent) error
+	backoff time.Duration
+	retries int
+	queue   chan AlertEvent
+
+	// owned by the delivery goroutine
+	delivered   map[string]deliveredAlert // alert ID | last fire delivered
+	windowStart time.Time
+	windowSent  int
+}
+
+type deliveredAlert struct {
+	severity string
+	at       time.Time
+}
+
+// CompileNotifiers validates a notifiers file, errors name the offending notifier
+func CompileNotifiers(f *NotifiersFile) ([]*Notifier, error) {
+	notifiers := make([]*Notifier, 0, len(f.Notifiers))
+	seen := make(map[string]struct{}, len(f.Notifiers))
+	client := &http.Client{Timeout: notifierTimeout}
+	for _, nc := range f.Notifiers {
+		if nc.Name == "" {
+			return nil, errors.New("notifier without a name")
+		}
+		if _, dup := seen[nc.Name]; dup {
+			return nil, fmt.Errorf("notifier %q: duplicate name", nc.Name)
+		}
+		seen[nc.Name] = struct{}{}
+
+		n := &Notifier{
+			NotifierConfig: nc,
+			backoff:        defaultNotifierBackoff,
+			retries:        defaultNotifierRetries,
+			queue:          make(chan AlertEvent, notifierQueueSize),
+			delivered:      make(map[string]deliveredAlert),
+		}
+		if n.MinSeverity == "" {
+			n.MinSeverity = "warning"
+		}
+		if _, ok := alertSeverities[n.MinSeverity]; !ok {
+			return nil, fmt.Errorf("notifier %q: min_severity must be warning or critical", nc.Name)
+		}
+		if n.RateLimit <= 0 {
+			n.RateLimit = defaultNotifierRateLimit
+		}
+		if nc.Retries != nil {
+			if *nc.Retries < 0 {
+				return nil, fmt.Errorf("notifier %q: retries must not be negative", nc.Name)
+			}
+			n.retries = *nc.Retries
+		}
+		if nc.Backoff != "" {
+			d, err := time.ParseDuration(nc.Backoff)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("notifier %q: bad backoff %q", nc.Name, nc.Backoff)
+			}
+			n.backoff = d
+		}
+
+		switch nc.Type {
+		case "webhook", "slack", "teams":
+			if nc.URL == "" {
+				return nil, fmt.Errorf("notifier %q: url is required", nc.Name)
+			}
+			format := webhookPayload
+			if nc.Type == "slack" {
+				format = slackPayload
+			} else if nc.Type == "teams" {
+				format = teamsPayload
+			}
+			n.send = func(ev AlertEvent) error { return postJSON(client, nc.URL, nc.Headers, format(ev)) }
+		case "email":
+			if nc.SMTP == nil || nc.SMTP.Addr == "" || nc.SMTP.From == "" || len(nc.SMTP.To) == 0 {
+				return nil, fmt.Errorf("notifier %q: smtp addr, from and to are required", nc.Name)
+			}
+			smtpConfig := *nc.SMTP
+			n.send = func(ev AlertEvent) error { return sendEmail(smtpConfig, ev) }
+		default:
+			return nil, fmt.Errorf("notifier %q: type must be webhook, slack, teams or email", nc.Name)
+		}
+		notifiers = append(notifiers, n)
+	}
+	return notifiers, nil
+}
+
+func LoadNotifiers(path string) ([]*Notifier, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f NotifiersFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	return CompileNotifiers(&f)
+}
+
+// Notifiers fans alert events out to the notifier queues
+type Notifiers struct {
+	list []*Notifier
+}
+
+func NewNotifiers(list []*Notifier) *Notifiers {
+	return &Notifiers{list: list}
+}
+
+// Start runs one delivery goroutine per notifier
+func (ns *Notifiers) Start() {
+	for _, n := range ns.list {
+		go n.run()
+	}
+}
+
+// Notify queues ev on every notifier routing it, never blocks
+func (ns *Notifiers) Notify(ev AlertEvent) {
+	if ns == nil {
+		return
+	}
+	for _, n := range ns.list {
+		if !n.routes(ev) {
+			continue
+		}
+		select {
+		case n.queue <- ev:
+		default:
+			log.Printf("Notifier %s: queue full, dropped %s %s", n.Name, ev.Event, ev.Alert.Type)
+		}
+	}
+}
+
+// resolves pass whatever the severity, deliver drops those this notifier never fired
+func (n *Notifier) routes(ev AlertEvent) bool {
+	if len(n.Types) > 0 && !slices.Contains(n.Types, ev.Alert.Type) {
+		return false
+	}
+	return ev.Event == AlertResolved || alertSeverities[ev.Alert.Severity] >= alertSeverities[n.MinSeverity]
+}
+
+func (n *Notifier) run() {
+	for ev := range n.queue {
+		n.deliver(ev, time.Now())
+	}
+}
+
+// deliver sends ev unless it is a duplicate, a resolve of an alert it never
+// fired or over the rate limit, true once sent
+func (n *Notifier) deliver(ev AlertEvent, now time.Time) bool {
+	n.forgetDelivered(now)
+	last, fired := n.delivered[ev.Alert.ID]
+	if ev.Event == AlertResolved {
+		// the entry goes whether the resolve is sent, silenced or dropped
+		if !fired {
+			return false
+		}
+		delete(n.delivered, ev.Alert.ID)
+		if ev.Alert.Silenced {
+			return false
+		}
+	} else if fired && last.severity == ev.Alert.Severity {
+		return false
+	}
+	if now.Sub(n.windowStart) >= time.Minute {
+		n.windowStart, n.windowSent = now, 0
+	}
+	if n.windowSent >= n.RateLimit {
+		log.Printf("Notifier %s: rate limited, dropped %s %s", n.Name, ev.Event, ev.Alert.Type)
+		return false
+	}
+	n.windowSent++
+
+	backoff := n.backoff
+	var err error
+	for attempt := 0; attempt <= n.retries; attempt++ {
+		if attempt > 0 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = n.send(ev); err == nil || errors.Is(err, errPermanent) {
+			break
+		}
+	}
+	if err != nil {
+		log.Printf("Notifier %s: failed to send %s %s: %v", n.Name, ev.Event, ev.Alert.Type, err)
+		return false
+	}
+
+	if ev.Event == AlertFired {
+		n.delivered[ev.Alert.ID] = deliveredAlert{ev.Alert.Severity, now}
+	}
+	return true
+}
+
+// drops fires older than notifierDeliveredTTL, then the oldest past the cap
+func (n *Notifier) forgetDelivered(now time.Time) {
+	for id, d := range n.delivered {
+		if now.Sub(d.at) > notifierDeliveredTTL {
+			delete(n.delivered, id)
+		}
+	}
+	for len(n.delivered) > maxNotifierDelivered {
+		oldest := ""
+		for id, d := range n.delivered {
+			if oldest == "" || d.at.Before(n.delivered[oldest].at) {
+				oldest = id
+			}
+		}
+		delete(n.delivered, oldest)
+	}
+}
+
+// queues a fire or resolve for the notifiers, silenced alerts stay quiet but
+// their resolves still clear what the notifiers remember
+func (a *App) notifyAlert(event string, alert Alert) {
+	if a.Notifiers == nil || (alert.Silenced && event == AlertFired) {
+		return
+	}
+	a.Notifiers.Notify(AlertEvent{Event: event, Alert: alert, At: alert.Timestamp})
+}
+
+func postJSON(client *http.Client, url string, headers map[string]string, payload any) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	switch {
+	case resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode >= 400 && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests:
+		return fmt.Errorf("%w: status %d", errPermanent, resp.StatusCode)
+	}
+	return fmt.Errorf("status %d", resp.StatusCode)
+}
+
+// "FIRING critical dynoDown web.1"
+func alertTitle(ev AlertEvent) string {
+	state := "FIRING"
+	if ev.Event == AlertResolved {
+		state = "RESOLVED"
+	}
+	title := fmt.Sprintf("%s %s %s", state, ev.Alert.Severity, ev.Alert.Type)
+	for _, scope := range []string{ev.Alert.Code, ev.Alert.Dyno, ev.Alert.Database, ev.Alert.SLO, ev.Alert.Endpoint} {
+		if scope != "" {
+			title += " " + scope
+		}
+	}
+	return title
+}
+
+func webhookPayload(ev AlertEvent) any {
+	return ev
+}
+
+func slackPayload(ev AlertEvent) any {
+	return map[string]string{
+		"text": fmt.Sprintf("*%s*\n%s", alertTitle(ev), ev.Alert.Message),
+	}
+}
+
+func teamsPayload(ev AlertEvent) any {
+	color := "FFA500"
+	switch {
+	case ev.Event == AlertResolved:
+		color = "2EB886"
+	case ev.Alert.Severity == "critical":
+		color = "D40000"
+	}
+	return map[string]string{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"summary":    alertTitle(ev),
+		"themeColor": color,
+		"title":      alertTitle(ev),
+		"text":       ev.Alert.Message,
+	}
+}
+
+func sendEmail(c SMTPConfig, ev AlertEvent) error {
+	var auth smtp.Auth
+	if c.Username != "" {
+		host, _, _ := net.SplitHostPort(c.Addr)
+		auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", c.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(c.To, ", "))
+	// the title carries paths and names from the logs, no line breaks in a header
+	subject := strings.NewReplacer("\r", " ", "\n", " ").Replace("[parseflow] " + alertTitle(ev))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", ev.At.Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "%s\r\n\r\nalert %s fired at %s\r\n", ev.Alert.Message, ev.Alert.ID, ev.Alert.FiredAt.Format(time.RFC3339))
+	if ev.Event == AlertResolved {
+		fmt.Fprintf(&msg, "resolved at %s\r\n", ev.Alert.ResolvedAt.Format(time.RFC3339))
+	}
+	return smtp.SendMail(c.Addr, auth, c.From, c.To, []byte(msg.String()))
+}
@@ -0,0 +1,386 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func compileTestNotifier(t *testing.T, nc NotifierConfig) *Notifier {
+	t.Helper()
+	notifiers, err := CompileNotifiers(&NotifiersFile{Notifiers: []NotifierConfig{nc}})
+	if err != nil {
+		t.Fatalf("Failed to compile notifier: %v", err)
+	}
+	return notifiers[0]
+}
+
+// webhook stand-in answering with the given statuses in turn, then 200
+func webhookStandIn(t *testing.T, statuses ...int) (*httptest.Server, func() [][]byte) {
+	t.Helper()
+	var mu sync.Mutex
+	var bodies [][]byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		json.NewDecoder(r.Body).Decode(&body)
+		mu.Lock()
+		bodies = append(bodies, body)
+		n := len(bodies)
+		mu.Unlock()
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() [][]byte {
+		mu.Lock()
+		defer mu.Unlock()
+		return bodies
+	}
+}
+
+func testAlertEvent(event, severity string) AlertEvent {
+	now := time.Date(2025, 7, 19, 12, 0, 0, 0, time.UTC)
+	return AlertEvent{
+		Event: event,
+		Alert: Alert{ID: "a1", Type: "dynoDown", Dyno: "web.1", Severity: severity, Message: "web.1 crashed", FiredAt: now, Timestamp: now},
+		At:    now,
+	}
+}
+
+func TestCompileNotifiers(t *testing.T) {
+	retries := -1
+	tests := []struct {
+		name     string
+		notifier NotifierConfig
+		wantErr  string
+	}{
+		{"webhook", NotifierConfig{Name: "a", Type: "webhook", URL: "http://localhost/hook"}, ""},
+		{"email", NotifierConfig{Name: "a", Type: "email", SMTP: &SMTPConfig{Addr: "localhost:25", From: "a@b", To: []string{"c@d"}}}, ""},
+		{"no name", NotifierConfig{Type: "webhook", URL: "http://localhost"}, "without a name"},
+		{"type", NotifierConfig{Name: "a", Type: "pager"}, "type"},
+		{"no url", NotifierConfig{Name: "a", Type: "slack"}, "url"},
+		{"no smtp", NotifierConfig{Name: "a", Type: "email"}, "smtp"},
+		{"severity", NotifierConfig{Name: "a", Type: "teams", URL: "http://localhost", MinSeverity: "page"}, "min_severity"},
+		{"backoff", NotifierConfig{Name: "a", Type: "webhook", URL: "http://localhost", Backoff: "soon"}, "backoff"},
+		{"retries", NotifierConfig{Name: "a", Type: "webhook", URL: "http://localhost", Retries: &retries}, "retries"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileNotifiers(&NotifiersFile{Notifiers: []NotifierConfig{tt.notifier}})
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadNotifiers(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "notifiers.json")
+	os.WriteFile(p, []byte(`{"notifiers": [{"name": "ops", "type": "slack", "url": "http://localhost/hook", "retries": 0, "backoff": "2s"}]}`), 0o644)
+
+	notifiers, err := LoadNotifiers(p)
+	if err != nil {
+		t.Fatalf("Failed to load notifiers: %v", err)
+	}
+	n := notifiers[0]
+	if n.retries != 0 || n.backoff != 2*time.Second || n.RateLimit != defaultNotifierRateLimit || n.MinSeverity != "warning" {
+		t.Errorf("Unexpected notifier %+v", n)
+	}
+}
+
+func TestNotifier_Payloads(t *testing.T) {
+	for _, tt := range []struct {
+		kind, want string
+	}{
+		{"webhook", `"event":"fired"`},
+		{"slack", `"text":"*FIRING critical dynoDown web.1*\nweb.1 crashed"`},
+		{"teams", `"@type":"MessageCard"`},
+	} {
+		t.Run(tt.kind, func(t *testing.T) {
+			srv, bodies := webhookStandIn(t)
+			n := compileTestNotifier(t, NotifierConfig{Name: "a", Type: tt.kind, URL: srv.URL})
+			if !n.deliver(testAlertEvent(AlertFired, "critical"), time.Now()) {
+				t.Fatal("Expected the event delivered")
+			}
+			if got := bodies(); len(got) != 1 || !strings.Contains(string(got[0]), tt.want) {
+				t.Errorf("Expected a body containing %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestNotifier_Retry(t *testing.T) {
+	srv, bodies := webhookStandIn(t, http.StatusBadGateway, http.StatusTooManyRequests)
+	n := compileTestNotifier(t, NotifierConfig{Name: "a", Type: "webhook", URL: srv.URL, Backoff: "1ms"})
+	if !n.deliver(testAlertEvent(AlertFired, "warning"), time.Now()) || len(bodies()) != 3 {
+		t.Errorf("Expected delivery on the third attempt, got %d attempts", len(bodies()))
+	}
+
+	// a rejected payload is not retried
+	srv, bodies = webhookStandIn(t, http.StatusBadRequest)
+	n = compileTestNotifier(t, NotifierConfig{Name: "a", Type: "webhook", URL: srv.URL, Backoff: "1ms"})
+	if n.deliver(testAlertEvent(AlertFired, "warning"), time.Now()) || len(bodies()) != 1 {
+		t.Errorf("Expected a single failed attempt, got %d", len(bodies()))
+	}
+
+	// retries run out
+	retries := 1
+	srv, bodies = webhookStandIn(t, 500, 500, 500)
+	n = compileTestNotifier(t, NotifierConfig{Name: "a", Type: "webhook", URL: srv.URL, Backoff: "1ms", Retries: &retries})
+	if n.deliver(testAlertEvent(AlertFired, "warning"), time.Now()) || len(bodies()) != 2 {
+		t.Errorf("Expected two failed attempts, got %d", len(bodies()))
+	}
+}
+
+func TestNotifier_DedupAndRateLimit(t *testing.T) {
+	srv, bodies := webhookStandIn(t)
+	n := compileTestNotifier(t, NotifierConfig{Name: "a", Type: "webhook", URL: srv.URL, RateLimit: 3})
+	now := time.Now()
+
+	n.deliver(testAlertEvent(AlertFired, "warning"), now)
+	if n.deliver(testAlertEvent(AlertFired, "warning"), now) {
+		t.Error("Expected a repeated fire skipped")
+	}
+	if !n.deliver(testAlertEvent(AlertFired, "critical"), now) {
+		t.Error("Expected an escalation delivered")
+	}
+	if !n.deliver(testAlertEvent(AlertResolved, "critical"), now) {
+		t.Error("Expected the resolution delivered")
+	}
+
+	// 3 sent this minute
+	other := testAlertEvent(AlertFired, "warning")
+	other.Alert.ID = "a2"
+	if n.deliver(other, now.Add(30*time.Second)) {
+		t.Error("Expected the fourth event rate limited")
+	}
+	if !n.deliver(other, now.Add(time.Minute)) {
+		t.Error("Expected delivery in the next minute")
+	}
+	if len(bodies()) != 4 {
+		t.Errorf("Expected 4 deliveries, got %d", len(bodies()))
+	}
+}
+
+func TestNotifiers_Routing(t *testing.T) {
+	all := compileTestNotifier(t, NotifierConfig{Name: "all", Type: "webhook", URL: "http://localhost"})
+	critical := compileTestNotifier(t, NotifierConfig{Name: "critical", Type: "webhook", URL: "http://localhost", MinSeverity: "critical"})
+	db := compileTestNotifier(t, NotifierConfig{Name: "db", Type: "webhook", URL: "http://localhost", Types: []string{"db_memory"}})
+	ns := NewNotifiers([]*Notifier{all, critical, db})
+
+	ns.Notify(testAlertEvent(AlertFired, "warning"))
+	ns.Notify(testAlertEvent(AlertFired, "critical"))
+	if len(all.queue) != 2 || len(critical.queue) != 1 || len(db.queue) != 0 {
+		t.Errorf("Unexpected routing, all %d, critical %d, db %d", len(all.queue), len(critical.queue), len(db.queue))
+	}
+
+	var nilNotifiers *Notifiers
+	nilNotifiers.Notify(testAlertEvent(AlertFired, "warning"))
+}
+
+func TestNotifier_ResolveFollowsFire(t *testing.T) {
+	srv, bodies := webhookStandIn(t)
+	critical := compileTestNotifier(t, NotifierConfig{Name: "critical", Type: "webhook", URL: srv.URL, MinSeverity: "critical", RateLimit: 2})
+	ns := NewNotifiers([]*Notifier{critical})
+	now := time.Now()
+
+	// fired critical, resolved after it dropped to warning
+	ns.Notify(testAlertEvent(AlertFired, "critical"))
+	ns.Notify(testAlertEvent(AlertFired, "warning"))
+	ns.Notify(testAlertEvent(AlertResolved, "warning"))
+	if len(critical.queue) != 2 {
+		t.Fatalf("Expected the fire and resolve queued, got %d", len(critical.queue))
+	}
+	if !critical.deliver(<-critical.queue, now) || !critical.deliver(<-critical.queue, now) {
+		t.Error("Expected the resolve delivered to the notifier that fired")
+	}
+
+	// never fired here
+	other := testAlertEvent(AlertResolved, "warning")
+	other.Alert.ID = "a2"
+	if critical.deliver(other, now) {
+		t.Error("Expected a resolve without a fire dropped")
+	}
+
+	// a rate limited resolve still forgets the fire
+	critical.delivered["a1"] = deliveredAlert{"critical", now}
+	if critical.deliver(testAlertEvent(AlertResolved, "critical"), now) {
+		t.Error("Expected the resolve rate limited")
+	}
+	if len(critical.delivered) != 0 {
+		t.Errorf("Expected the fire forgotten, got %v", critical.delivered)
+	}
+
+	// a silenced resolve is not sent but forgets the fire too
+	silenced := testAlertEvent(AlertResolved, "critical")
+	silenced.Alert.ID = "a3"
+	critical.delivered["a3"] = deliveredAlert{"critical", now}
+	silenced.Alert.Silenced = true
+	if critical.deliver(silenced, now.Add(time.Minute)) || len(critical.delivered) != 0 {
+		t.Error("Expected a silenced resolve forgotten without sending")
+	}
+	if len(bodies()) != 2 {
+		t.Errorf("Expected 2 deliveries, got %d", len(bodies()))
+	}
+}
+
+func TestNotifier_ForgetDelivered(t *testing.T) {
+	n := compileTestNotifier(t, NotifierConfig{Name: "a", Type: "webhook", URL: "http://localhost"})
+	now := time.Now()
+
+	n.delivered["stale"] = deliveredAlert{"warning", now.Add(-notifierDeliveredTTL - time.Second)}
+	for i := 0; i < maxNotifierDelivered+5; i++ {
+		n.delivered[fmt.Sprintf("a%d", i)] = deliveredAlert{"warning", now.Add(time.Duration(i) * time.Second)}
+	}
+	n.forgetDelivered(now)
+
+	if len(n.delivered) != maxNotifierDelivered {
+		t.Fatalf("Expected %d remembered, got %d", maxNotifierDelivered, len(n.delivered))
+	}
+	if _, ok := n.delivered["stale"]; ok {
+		t.Error("Expected the stale fire expired")
+	}
+	if _, ok := n.delivered["a4"]; ok {
+		t.Error("Expected the oldest fires dropped past the cap")
+	}
+	if _, ok := n.delivered["a5"]; !ok {
+		t.Error("Expected the newer fires kept")
+	}
+}
+
+func TestApp_NotifyAlert(t *testing.T) {
+	app := createAlertsTestApp()
+	app.Config = &Config{AlertResolveAfter: time.Nanosecond}
+	n := compileTestNotifier(t, NotifierConfig{Name: "all", Type: "webhook", URL: "http://localhost"})
+	app.Notifiers = NewNotifiers([]*Notifier{n})
+	now := time.Now()
+	same := func(e Alert) bool { return e.Type == "dynoDown" }
+
+	app.raiseAlert(Alert{Type: "dynoDown", Dyno: "web.1", Severity: "warning", Timestamp: now})
+	app.raiseAlert(Alert{Type: "dynoDown", Dyno: "web.1", Severity: "warning", Timestamp: now})
+	app.raiseAlert(Alert{Type: "dynoDown", Dyno: "web.1", Severity: "critical", Timestamp: now})
+	app.resolveAlert(same, now)
+	app.resolveAlert(same, now.Add(time.Millisecond))
+
+	var events []string
+	for len(n.queue) > 0 {
+		ev := <-n.queue
+		events = append(events, ev.Event+" "+ev.Alert.Severity)
+	}
+	if strings.Join(events, ", ") != "fired warning, fired critical, resolved critical" {
+		t.Errorf("Unexpected events %v", events)
+	}
+
+	// silenced alerts stay quiet
+	app.Silences = []Silence{{ID: "s", Type: "dynoDown", EndsAt: now.Add(time.Hour)}}
+	app.raiseAlert(Alert{Type: "dynoDown", Dyno: "web.1", Severity: "critical", Timestamp: now})
+	if len(n.queue) != 0 {
+		t.Errorf("Expected no event for a silenced alert, got %d", len(n.queue))
+	}
+}
+
+// smtp stand-in accepting one message per connection
+func smtpStandIn(t *testing.T) (string, <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	messages := make(chan string, 10)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+				reply("220 localhost")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+					case strings.HasPrefix(cmd, "DATA"):
+						reply("354 go ahead")
+						var msg strings.Builder
+						for {
+							l, err := r.ReadString('\n')
+							if err != nil || l == ".\r\n" {
+								break
+							}
+							msg.WriteString(l)
+						}
+						messages <- msg.String()
+						reply("250 queued")
+					case strings.HasPrefix(cmd, "QUIT"):
+						reply("221 bye")
+						return
+					default:
+						reply("250 ok")
+					}
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), messages
+}
+
+func TestNotifier_Email(t *testing.T) {
+	addr, messages := smtpStandIn(t)
+	n := compileTestNotifier(t, NotifierConfig{
+		Name: "mail", Type: "email",
+		SMTP: &SMTPConfig{Addr: addr, From: "alerts@example.com", To: []string{"ops@example.com"}},
+	})
+
+	sent := make(chan bool, 1)
+	go func() { sent <- n.deliver(testAlertEvent(AlertFired, "critical"), time.Now()) }()
+	select {
+	case msg := <-messages:
+		if !strings.Contains(msg, "Subject: [parseflow] FIRING critical dynoDown web.1") || !strings.Contains(msg, "web.1 crashed") {
+			t.Errorf("Unexpected message %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected an email")
+	}
+	if !<-sent {
+		t.Error("Expected the email delivered")
+	}
+
+	// a path from the logs must not add headers
+	ev := testAlertEvent(AlertFired, "warning")
+	ev.Alert.ID = "a2"
+	ev.Alert.Endpoint = "/x\r\nBcc: victim@example.com"
+	go func() { sent <- n.deliver(ev, time.Now()) }()
+	select {
+	case msg := <-messages:
+		if strings.Contains(msg, "\r\nBcc:") || !strings.Contains(msg, "Subject: [parseflow] FIRING warning dynoDown web.1 /x  Bcc: victim@example.com\r\n") {
+			t.Errorf("Expected the line breaks dropped from the subject, got %q", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected an email")
+	}
+	<-sent
+}
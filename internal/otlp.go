@@ -0,0 +1,1007 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// OTLP/HTTP export to an OpenTelemetry collector, protobuf or JSON. Parsed lines
+// go out as log records with the HTTP semantic convention attributes, batched by
+// size and interval. The aggregated metrics are pushed every MetricInterval as
+// cumulative sums, gauges and the latency histograms. The requests are handed
+// to a sender of their own so a slow collector never stops the queue from
+// draining, and retried with backoff, or after the Retry-After of the
+// collector, on the statuses the OTLP spec marks retryable. There is no SDK,
+// the messages of opentelemetry-proto are encoded here.
+
+const (
+	OTLPProtobuf = "http/protobuf"
+	OTLPJSON     = "http/json"
+
+	defaultOTLPServiceName    = "parseflow"
+	defaultOTLPMetricInterval = 60 * time.Second
+	defaultOTLPBatchSize      = 512
+	defaultOTLPFlushInterval  = 5 * time.Second
+	defaultOTLPQueueSize      = 10000
+	defaultOTLPRetries        = 3
+	defaultOTLPBackoff        = time.Second
+	otlpTimeout               = 10 * time.Second
+	otlpPendingExports        = 4 // requests waiting for the sender
+	maxOTLPRetryAfter         = time.Minute
+
+	otlpScopeName  = "parseflow"
+	otlpCumulative = 2 // AggregationTemporality
+)
+
+type OTLPConfig struct {
+	Endpoint       string   // base URL, /v1/logs and /v1/metrics are appended
+	Protocol       string   // http/protobuf or http/json
+	Headers        []string // key=value, values may be URL encoded
+	ServiceName    string
+	MetricInterval time.Duration
+	BatchSize      int
+	FlushInterval  time.Duration
+	QueueSize      int
+	Retries        *int // nil or negative for the default, 0 sends once
+	Backoff        time.Duration
+}
+
+type OTLPExporter struct {
+	cfg      OTLPConfig
+	headers  map[string]string
+	client   *http.Client
+	retries  int
+	logs     chan *ParsedLog
+	exports  chan otlpExport
+	dropped  atomic.Int64 // records lost to a full queue or a busy sender
+	start    time.Time    // start of the cumulative sums
+	resource otlpResource
+}
+
+func NewOTLPExporter(cfg OTLPConfig) (*OTLPExporter, error) {
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", cfg.Endpoint)
+	}
+	cfg.Endpoint = strings.TrimSuffix(cfg.Endpoint, "/")
+	switch cfg.Protocol {
+	case "":
+		cfg.Protocol = OTLPProtobuf
+	case OTLPProtobuf, OTLPJSON:
+	default:
+		return nil, fmt.Errorf("unsupported OTLP protocol %q", cfg.Protocol)
+	}
+
+	headers := make(map[string]string, len(cfg.Headers))
+	for _, h := range cfg.Headers {
+		k, v, ok := strings.Cut(h, "=")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid OTLP header %q, expected key=value", h)
+		}
+		if unescaped, err := url.QueryUnescape(v); err == nil {
+			v = unescaped
+		}
+		headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+
+	if cfg.ServiceName == "" {
+		cfg.ServiceName = defaultOTLPServiceName
+	}
+	if cfg.MetricInterval <= 0 {
+		cfg.MetricInterval = defaultOTLPMetricInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultOTLPBatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultOTLPFlushInterval
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultOTLPQueueSize
+	}
+	retries := defaultOTLPRetries
+	if cfg.Retries != nil && *cfg.Retries >= 0 {
+		retries = *cfg.Retries
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultOTLPBackoff
+	}
+
+	return &OTLPExporter{
+		cfg:     cfg,
+		headers: headers,
+		client:  &http.Client{Timeout: otlpTimeout},
+		retries: retries,
+		logs:    make(chan *ParsedLog, cfg.QueueSize),
+		exports: make(chan otlpExport, otlpPendingExports),
+		start:   time.Now(),
+		resource: otlpResource{Attributes: []otlpKeyValue{
+			{"service.name", otlpString(cfg.ServiceName)},
+		}},
+	}, nil
+}
+
+// Export queues l for the next batch, dropped when the queue is full so a slow
+// collector never holds up the pipeline
+func (e *OTLPExporter) Export(l *ParsedLog) {
+	if e == nil {
+		return
+	}
+	select {
+	case e.logs <- l:
+	default:
+		e.dropped.Add(1)
+	}
+}
+
+// a request for the sender, records is the number of log records in it
+type otlpExport struct {
+	path    string
+	msg     otlpMessage
+	records int
+}
+
+// StartOTLPExporter batches the queued records and pushes the metrics until the
+// process exits
+func (a *App) StartOTLPExporter() {
+	e := a.OTLP
+	if e == nil {
+		return
+	}
+	go e.sendExports()
+	flush := time.NewTicker(e.cfg.FlushInterval)
+	defer flush.Stop()
+	metrics := time.NewTicker(e.cfg.MetricInterval)
+	defer metrics.Stop()
+
+	batch := make([]*ParsedLog, 0, e.cfg.BatchSize)
+	var reported int64
+	send := func() {
+		if dropped := e.dropped.Load(); dropped > reported {
+			log.Printf("WARNING: OTLP export behind, %d log records dropped", dropped-reported)
+			reported = dropped
+		}
+		if len(batch) == 0 {
+			return
+		}
+		e.handOff(otlpExport{"/v1/logs", e.logsRequest(batch, time.Now()), len(batch)})
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case l := <-e.logs:
+			batch = append(batch, l)
+			if len(batch) >= e.cfg.BatchSize {
+				send()
+			}
+		case <-flush.C:
+			send()
+		case <-metrics.C:
+			e.handOff(otlpExport{"/v1/metrics", e.metricsRequest(a.otlpMetrics(time.Now())), 0})
+		}
+	}
+}
+
+// handOff passes x to the sender, dropped while it is still busy with the
+// requests before
+func (e *OTLPExporter) handOff(x otlpExport) {
+	select {
+	case e.exports <- x:
+	default:
+		if x.records == 0 {
+			log.Printf("WARNING: OTLP export behind, metrics dropped")
+		}
+		e.dropped.Add(int64(x.records))
+	}
+}
+
+// sendExports posts the handed off requests, retries included
+func (e *OTLPExporter) sendExports() {
+	for x := range e.exports {
+		if err := e.post(x.path, x.msg); err != nil {
+			if x.records > 0 {
+				log.Printf("OTLP: failed to export %d log records: %v", x.records, err)
+			} else {
+				log.Printf("OTLP: failed to export metrics: %v", err)
+			}
+		}
+	}
+}
+
+func (e *OTLPExporter) logsRequest(batch []*ParsedLog, now time.Time) *otlpLogsRequest {
+	records := make([]otlpLogRecord, len(batch))
+	for i, l := range batch {
+		records[i] = otlpLog(l, now)
+	}
+	return &otlpLogsRequest{ResourceLogs: []otlpResourceLogs{{
+		Resource:  e.resource,
+		ScopeLogs: []otlpScopeLogs{{Scope: otlpScope{Name: otlpScopeName}, LogRecords: records}},
+	}}}
+}
+
+func (e *OTLPExporter) metricsRequest(metrics []otlpMetric) *otlpMetricsRequest {
+	return &otlpMetricsRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     e.resource,
+		ScopeMetrics: []otlpScopeMetrics{{Scope: otlpScope{Name: otlpScopeName}, Metrics: metrics}},
+	}}}
+}
+
+func (e *OTLPExporter) exportLogs(batch []*ParsedLog, now time.Time) error {
+	return e.post("/v1/logs", e.logsRequest(batch, now))
+}
+
+func (e *OTLPExporter) exportMetrics(metrics []otlpMetric) error {
+	return e.post("/v1/metrics", e.metricsRequest(metrics))
+}
+
+type otlpMessage interface {
+	proto(p *protoEncoder)
+}
+
+func (e *OTLPExporter) post(path string, msg otlpMessage) error {
+	var body []byte
+	contentType := "application/x-protobuf"
+	if e.cfg.Protocol == OTLPJSON {
+		var err error
+		if body, err = json.Marshal(msg); err != nil {
+			return err
+		}
+		contentType = "application/json"
+	} else {
+		var p protoEncoder
+		msg.proto(&p)
+		body = p.b
+	}
+
+	backoff := e.cfg.Backoff
+	var err error
+	for attempt := 0; attempt <= e.retries; attempt++ {
+		if attempt > 0 {
+			wait := backoff
+			var retryable *otlpRetryable
+			if errors.As(err, &retryable) && retryable.after > 0 {
+				wait = retryable.after
+			}
+			time.Sleep(wait)
+			backoff *= 2
+		}
+		if err = e.send(path, contentType, body); err == nil || errors.Is(err, errPermanent) {
+			break
+		}
+	}
+	return err
+}
+
+// a retryable status, after is the Retry-After of the collector if any
+type otlpRetryable struct {
+	status int
+	after  time.Duration
+}
+
+func (e *otlpRetryable) Error() string {
+	return fmt.Sprintf("status %d", e.status)
+}
+
+// Retry-After in seconds or as an HTTP date, capped at maxOTLPRetryAfter
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	var after time.Duration
+	if secs, err := strconv.Atoi(v); err == nil {
+		after = time.Duration(secs) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		after = t.Sub(now)
+	}
+	return max(0, min(after, maxOTLPRetryAfter))
+}
+
+// only 429, 502, 503 and 504 are worth retrying per the OTLP spec
+func (e *OTLPExporter) send(path, contentType string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, e.cfg.Endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range e.headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return &otlpRetryable{resp.StatusCode, parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())}
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%w: status %d", errPermanent, resp.StatusCode)
+	}
+	return nil
+}
+
+// log records
+
+var syslogSeverityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// syslog severity | OTel SeverityNumber
+var syslogSeverityNumbers = []int{24, 23, 21, 17, 13, 10, 9, 5}
+
+// the level of the line wins over the syslog severity, heroku sends router
+// errors at info
+func otlpSeverity(l *ParsedLog) (int, string) {
+	switch strings.ToLower(l.Level) {
+	case "fatal", "critical", "crit":
+		return 21, l.Level
+	case "error", "err":
+		return 17, l.Level
+	case "warn", "warning":
+		return 13, l.Level
+	case "info":
+		return 9, l.Level
+	case "debug":
+		return 5, l.Level
+	case "trace":
+		return 1, l.Level
+	}
+	if l.Severity < 0 || l.Severity >= len(syslogSeverityNames) {
+		return 0, l.Level
+	}
+	return syslogSeverityNumbers[l.Severity], syslogSeverityNames[l.Severity]
+}
+
+func otlpLog(l *ParsedLog, now time.Time) otlpLogRecord {
+	var attrs otlpAttrs
+	attrs.str("http.request.method", l.Method)
+	attrs.int("http.response.status_code", int64(l.Status))
+	attrs.str("http.route", l.Route)
+	attrs.str("url.path", l.Path)
+	attrs.str("url.scheme", l.Protocol)
+	attrs.str("server.address", l.Host)
+	attrs.str("client.address", l.SourceIp)
+	attrs.int("http.response.body.size", int64(l.Size))
+	attrs.str("error.type", l.ErrorCode)
+	attrs.str("heroku.error.description", l.ErrorDesc)
+	attrs.str("heroku.request_id", l.ReqId)
+	attrs.str("heroku.dyno", l.SourceDyno)
+	if l.Source == SourceRouter {
+		attrs.double("heroku.router.connect_ms", float64(l.ConnectTime)/float64(time.Millisecond))
+		attrs.double("heroku.router.service_ms", float64(l.ResponseTime)/float64(time.Millisecond))
+	}
+	attrs.str("syslog.app_name", l.AppName)
+	attrs.str("syslog.proc_id", l.ProcId)
+	attrs.str("parseflow.source", l.Source)
+	attrs.str("parseflow.rule", l.Rule)
+	for _, k := range sortedKeys(l.Fields) {
+		attrs.str("parseflow.field."+k, l.Fields[k])
+	}
+	for _, k := range sortedKeys(l.Attrs) {
+		attrs = append(attrs, otlpKeyValue{"app." + k, otlpAny(l.Attrs[k])})
+	}
+
+	severity, text := otlpSeverity(l)
+	return otlpLogRecord{
+		TimeUnixNano:         otlpTime(l.Time),
+		ObservedTimeUnixNano: otlpTime(now),
+		SeverityNumber:       severity,
+		SeverityText:         text,
+		Body:                 otlpString(l.Message),
+		Attributes:           attrs,
+	}
+}
+
+func otlpTime(t time.Time) uint64 {
+	if t.IsZero() || t.UnixNano() < 0 {
+		return 0
+	}
+	return uint64(t.UnixNano())
+}
+
+// metrics
+
+// http.request.method only knows the registered methods, the rest are _OTHER
+var otlpMethods = []struct {
+	method string
+	n      func(*Metric) int64
+}{
+	{"GET", func(m *Metric) int64 { return m.GetRequests }},
+	{"POST", func(m *Metric) int64 { return m.PostRequests }},
+	{"PUT", func(m *Metric) int64 { return m.PutRequests }},
+	{"DELETE", func(m *Metric) int64 { return m.DeleteRequests }},
+	{"_OTHER", func(m *Metric) int64 { return m.OtherRequests }},
+}
+
+// db.system.name of the add-on kinds
+var otlpDbSystems = map[string]string{"postgres": "postgresql", "redis": "redis"}
+
+type otlpMetricSet struct {
+	start, now uint64
+	metrics    []otlpMetric
+}
+
+// point of value, attrs as key, value pairs
+func (s *otlpMetricSet) point(value float64, attrs ...string) otlpNumberPoint {
+	return otlpNumberPoint{Attributes: otlpStringAttrs(attrs), TimeUnixNano: s.now, AsDouble: value}
+}
+
+func (s *otlpMetricSet) gauge(name, unit, desc string, points ...otlpNumberPoint) {
+	if len(points) == 0 {
+		return
+	}
+	s.metrics = append(s.metrics, otlpMetric{Name: name, Unit: unit, Description: desc, Gauge: &otlpGauge{DataPoints: points}})
+}
+
+func (s *otlpMetricSet) sum(name, unit, desc string, points ...otlpNumberPoint) {
+	if len(points) == 0 {
+		return
+	}
+	for i := range points {
+		points[i].StartTimeUnixNano = s.start
+	}
+	s.metrics = append(s.metrics, otlpMetric{Name: name, Unit: unit, Description: desc,
+		Sum: &otlpSum{DataPoints: points, AggregationTemporality: otlpCumulative, IsMonotonic: true}})
+}
+
+// histogram point in seconds over promLatencyBuckets
+func (s *otlpMetricSet) histogramPoint(sk *LatencySketch, attrs ...string) otlpHistogramPoint {
+	if sk == nil {
+		sk = NewLatencySketch()
+	}
+	bounds := make([]float64, len(promLatencyBuckets))
+	counts := make([]uint64, len(promLatencyBuckets)+1)
+	var prev uint64
+	for i, c := range sk.CountAtMost(promLatencyBuckets...) {
+		bounds[i] = promLatencyBuckets[i].Seconds()
+		counts[i] = c - prev
+		prev = c
+	}
+	counts[len(counts)-1] = sk.Count - prev
+	return otlpHistogramPoint{
+		Attributes:        otlpStringAttrs(attrs),
+		StartTimeUnixNano: s.start,
+		TimeUnixNano:      s.now,
+		Count:             sk.Count,
+		Sum:               sk.Sum / float64(time.Second),
+		BucketCounts:      counts,
+		ExplicitBounds:    bounds,
+	}
+}
+
+// otlpMetrics maps the snapshot onto OTLP metrics, the totals since start as
+// cumulative sums and the rest as gauges
+func (a *App) otlpMetrics(now time.Time) []otlpMetric {
+	m := a.GetMetricsSnapshot()
+	endpoints, overflow, channels := a.promState()
+	s := &otlpMetricSet{start: otlpTime(a.OTLP.start), now: otlpTime(now)}
+
+	// traffic, per route when the endpoints are tracked so the points add up
+	if len(endpoints) > 0 {
+		var points []otlpHistogramPoint
+		for _, e := range endpoints {
+			points = append(points, s.histogramPoint(e.latency, "http.route", e.Route))
+		}
+		s.metrics = append(s.metrics, otlpMetric{Name: "http.server.request.duration", Unit: "s", Description: "Router response times.",
+			Histogram: &otlpHistogram{DataPoints: points, AggregationTemporality: otlpCumulative}})
+	} else {
+		s.metrics = append(s.metrics, otlpMetric{Name: "http.server.request.duration", Unit: "s", Description: "Router response times.",
+			Histogram: &otlpHistogram{DataPoints: []otlpHistogramPoint{s.histogramPoint(m.LatencySketch)}, AggregationTemporality: otlpCumulative}})
+	}
+	s.sum("parseflow.requests", "{request}", "Router requests by status class.",
+		s.point(float64(m.Status2xx), "parseflow.status_class", "2xx"),
+		s.point(float64(m.Status3xx), "parseflow.status_class", "3xx"),
+		s.point(float64(m.Status4xx), "parseflow.status_class", "4xx"),
+		s.point(float64(m.Status5xx), "parseflow.status_class", "5xx"))
+	var methods []otlpNumberPoint
+	for _, c := range otlpMethods {
+		methods = append(methods, s.point(float64(c.n(m)), "http.request.method", c.method))
+	}
+	s.sum("parseflow.requests.by_method", "{request}", "Router requests by HTTP method.", methods...)
+	s.sum("parseflow.requests.slow", "{request}", "Router requests over the slow threshold.", s.point(float64(m.SlowRequestCount)))
+	var routerErrors []otlpNumberPoint
+	for _, code := range sortedKeys(m.RouterErrors) {
+		routerErrors = append(routerErrors, s.point(float64(m.RouterErrors[code]), "error.type", code))
+	}
+	s.sum("parseflow.router.errors", "{error}", "Heroku router errors by H-code.", routerErrors...)
+
+	s.gauge("parseflow.requests.rate", "{request}/s", "Router requests per second since start.", s.point(m.RequestsPerSecond))
+	s.gauge("parseflow.error_rate", "%", "Share of 4xx and 5xx responses since start.", s.point(m.ErrorRate))
+	s.gauge("parseflow.apdex", "1", "Apdex score since start.", s.point(m.Apdex))
+	s.gauge("parseflow.response_time.percentile", "s", "Response time percentiles over the latency window.",
+		s.point(m.P50ResponseTime.Seconds(), "parseflow.percentile", "50"),
+		s.point(m.P90ResponseTime.Seconds(), "parseflow.percentile", "90"),
+		s.point(m.P95ResponseTime.Seconds(), "parseflow.percentile", "95"),
+		s.point(m.P99ResponseTime.Seconds(), "parseflow.percentile", "99"),
+		s.point(m.P999ResponseTime.Seconds(), "parseflow.percentile", "99.9"))
+
+	var rates, errorRates, p95s []otlpNumberPoint
+	for _, name := range sortedKeys(m.Windows) {
+		w := m.Windows[name]
+		rates = append(rates, s.point(w.RequestsPerSecond, "parseflow.window", name))
+		errorRates = append(errorRates, s.point(w.ErrorRate, "parseflow.window", name))
+		p95s = append(p95s, s.point(w.P95ResponseTime.Seconds(), "parseflow.window", name))
+	}
+	s.gauge("parseflow.window.requests.rate", "{request}/s", "Router requests per second over the window.", rates...)
+	s.gauge("parseflow.window.error_rate", "%", "Share of 4xx and 5xx responses over the window.", errorRates...)
+	s.gauge("parseflow.window.response_time.p95", "s", "95th percentile response time over the window.", p95s...)
+
+	// dynos
+	var dynoRequests, dynoErrorRates, dynoP95s, memory, load, restarts, crashes []otlpNumberPoint
+	for _, dyno := range sortedKeys(m.DynoPerformance) {
+		d := m.DynoPerformance[dyno]
+		dynoRequests = append(dynoRequests, s.point(float64(d.RequestCount), "heroku.dyno", dyno))
+		dynoErrorRates = append(dynoErrorRates, s.point(d.ErrorRate, "heroku.dyno", dyno))
+		dynoP95s = append(dynoP95s, s.point(d.P95ResponseTime.Seconds(), "heroku.dyno", dyno))
+		if d.MemoryTotal > 0 || d.MemoryQuota > 0 {
+			memory = append(memory,
+				s.point(d.MemoryTotal*bytesPerMB, "heroku.dyno", dyno, "parseflow.memory.kind", "total"),
+				s.point(d.MemoryRSS*bytesPerMB, "heroku.dyno", dyno, "parseflow.memory.kind", "rss"),
+				s.point(d.MemorySwap*bytesPerMB, "heroku.dyno", dyno, "parseflow.memory.kind", "swap"),
+				s.point(d.MemoryQuota*bytesPerMB, "heroku.dyno", dyno, "parseflow.memory.kind", "quota"))
+			load = append(load, s.point(d.LoadAvg1m, "heroku.dyno", dyno))
+		}
+		restarts = append(restarts, s.point(float64(d.Restarts), "heroku.dyno", dyno))
+		crashes = append(crashes, s.point(float64(d.Crashes), "heroku.dyno", dyno))
+	}
+	s.sum("parseflow.dyno.requests", "{request}", "Router requests served by the dyno.", dynoRequests...)
+	s.gauge("parseflow.dyno.error_rate", "%", "Share of 4xx and 5xx responses of the dyno.", dynoErrorRates...)
+	s.gauge("parseflow.dyno.response_time.p95", "s", "95th percentile response time of the dyno.", dynoP95s...)
+	s.gauge("parseflow.dyno.memory", "By", "Memory of the dyno from log-runtime-metrics.", memory...)
+	s.gauge("parseflow.dyno.load_average.1m", "1", "Load average of the dyno from log-runtime-metrics.", load...)
+	s.sum("parseflow.dyno.restarts", "{restart}", "Restarts of the dyno.", restarts...)
+	s.sum("parseflow.dyno.crashes", "{crash}", "Crashes of the dyno.", crashes...)
+
+	// endpoints
+	var endpointRequests, endpointBytes []otlpNumberPoint
+	for _, e := range endpoints {
+		endpointRequests = append(endpointRequests,
+			s.point(float64(e.Status2xx), "http.route", e.Route, "parseflow.status_class", "2xx"),
+			s.point(float64(e.Status3xx), "http.route", e.Route, "parseflow.status_class", "3xx"),
+			s.point(float64(e.Status4xx), "http.route", e.Route, "parseflow.status_class", "4xx"),
+			s.point(float64(e.Status5xx), "http.route", e.Route, "parseflow.status_class", "5xx"))
+		endpointBytes = append(endpointBytes, s.point(float64(e.bytes), "http.route", e.Route))
+	}
+	s.sum("parseflow.endpoint.requests", "{request}", "Router requests by route and status class.", endpointRequests...)
+	s.sum("parseflow.endpoint.response.size", "By", "Response bytes by route.", endpointBytes...)
+	if len(endpoints) > 0 {
+		s.sum("parseflow.endpoint.overflow", "{request}", "Router requests folded into the (other) route.", s.point(float64(overflow)))
+	}
+
+	// add-ons
+	var active, waiting, usage []otlpNumberPoint
+	for _, name := range sortedKeys(m.DatabaseHealth) {
+		d := m.DatabaseHealth[name]
+		system := otlpDbSystems[d.Kind]
+		if system == "" {
+			system = d.Kind
+		}
+		active = append(active, s.point(float64(d.ActiveConnections), "db.system.name", system, "heroku.addon", name))
+		waiting = append(waiting, s.point(float64(d.WaitingConnections), "db.system.name", system, "heroku.addon", name))
+		usage = append(usage, s.point(d.ConnectionUsage, "db.system.name", system, "heroku.addon", name))
+	}
+	s.gauge("parseflow.db.connections.active", "{connection}", "Active connections of the add-on.", active...)
+	s.gauge("parseflow.db.connections.waiting", "{connection}", "Waiting connections of the add-on.", waiting...)
+	s.gauge("parseflow.db.connections.usage", "%", "Connections used of the plan limit.", usage...)
+
+	// SLOs
+	var compliance, budget []otlpNumberPoint
+	for _, slo := range m.SLOs {
+		compliance = append(compliance, s.point(slo.Compliance, "parseflow.slo", slo.Name))
+		budget = append(budget, s.point(slo.BudgetRemaining, "parseflow.slo", slo.Name))
+	}
+	s.gauge("parseflow.slo.compliance", "%", "Share of good requests over the SLO window.", compliance...)
+	s.gauge("parseflow.slo.error_budget.remaining", "%", "Error budget left over the SLO window.", budget...)
+
+	// alerts
+	firing := 0
+	for _, alert := range m.ActiveAlerts {
+		if !alert.Resolved && !alert.Silenced {
+			firing++
+		}
+	}
+	s.gauge("parseflow.alerts.active", "{alert}", "Firing alerts that are not silenced.", s.point(float64(firing)))
+
+	// the pipeline itself
+	s.sum("parseflow.logs.unparsed", "{record}", "Log lines no parser claimed.", s.point(float64(m.UnparsedLogs)))
+	s.sum("parseflow.events.late", "{record}", "Router lines behind the watermark.", s.point(float64(m.EventTime.LateEvents)))
+	s.sum("parseflow.otlp.dropped", "{record}", "Log records dropped by a full OTLP queue.", s.point(float64(a.OTLP.dropped.Load())))
+	s.gauge("parseflow.processing.rate", "{record}/s", "Log lines processed per second.", s.point(m.ChannelHealth.ProcessingRate))
+	var lengths []otlpNumberPoint
+	for _, c := range channels {
+		lengths = append(lengths, s.point(float64(c.length), "parseflow.channel", c.name))
+	}
+	s.gauge("parseflow.channel.length", "{item}", "Items queued in the pipeline channel.", lengths...)
+	return s.metrics
+}
+
+// opentelemetry-proto messages, the json tags follow the OTLP JSON encoding:
+// lowerCamelCase names, 64 bit integers as strings and enums as numbers
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *int64   `json:"intValue,omitempty,string"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func otlpString(s string) otlpValue { return otlpValue{StringValue: &s} }
+
+// value of a JSON app log attribute, the flattened payload only holds scalars
+func otlpAny(v any) otlpValue {
+	switch v := v.(type) {
+	case string:
+		return otlpString(v)
+	case bool:
+		return otlpValue{BoolValue: &v}
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<53 {
+			i := int64(v)
+			return otlpValue{IntValue: &i}
+		}
+		return otlpValue{DoubleValue: &v}
+	case int:
+		i := int64(v)
+		return otlpValue{IntValue: &i}
+	case int64:
+		return otlpValue{IntValue: &v}
+	case nil:
+		return otlpValue{}
+	}
+	return otlpString(fmt.Sprint(v))
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+// otlpAttrs leaves out the unset fields of a record
+type otlpAttrs []otlpKeyValue
+
+func (a *otlpAttrs) str(key, v string) {
+	if v != "" {
+		*a = append(*a, otlpKeyValue{key, otlpString(v)})
+	}
+}
+
+func (a *otlpAttrs) int(key string, v int64) {
+	if v != 0 {
+		*a = append(*a, otlpKeyValue{key, otlpValue{IntValue: &v}})
+	}
+}
+
+func (a *otlpAttrs) double(key string, v float64) {
+	if v != 0 {
+		*a = append(*a, otlpKeyValue{key, otlpValue{DoubleValue: &v}})
+	}
+}
+
+func otlpStringAttrs(kv []string) []otlpKeyValue {
+	var attrs []otlpKeyValue
+	for i := 0; i+1 < len(kv); i += 2 {
+		attrs = append(attrs, otlpKeyValue{kv[i], otlpString(kv[i+1])})
+	}
+	return attrs
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpLogsRequest struct {
+	ResourceLogs []otlpResourceLogs `json:"resourceLogs"`
+}
+
+type otlpResourceLogs struct {
+	Resource  otlpResource    `json:"resource"`
+	ScopeLogs []otlpScopeLogs `json:"scopeLogs"`
+}
+
+type otlpScopeLogs struct {
+	Scope      otlpScope       `json:"scope"`
+	LogRecords []otlpLogRecord `json:"logRecords"`
+}
+
+type otlpLogRecord struct {
+	TimeUnixNano         uint64         `json:"timeUnixNano,string"`
+	ObservedTimeUnixNano uint64         `json:"observedTimeUnixNano,string"`
+	SeverityNumber       int            `json:"severityNumber,omitempty"`
+	SeverityText         string         `json:"severityText,omitempty"`
+	Body                 otlpValue      `json:"body"`
+	Attributes           []otlpKeyValue `json:"attributes,omitempty"`
+}
+
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpMetric struct {
+	Name        string         `json:"name"`
+	Description string         `json:"description,omitempty"`
+	Unit        string         `json:"unit,omitempty"`
+	Gauge       *otlpGauge     `json:"gauge,omitempty"`
+	Sum         *otlpSum       `json:"sum,omitempty"`
+	Histogram   *otlpHistogram `json:"histogram,omitempty"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otlpNumberPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano,omitempty,string"`
+	TimeUnixNano      uint64         `json:"timeUnixNano,string"`
+	AsDouble          float64        `json:"asDouble"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano uint64         `json:"startTimeUnixNano,string"`
+	TimeUnixNano      uint64         `json:"timeUnixNano,string"`
+	Count             uint64         `json:"count,string"`
+	Sum               float64        `json:"sum"`
+	BucketCounts      otlpCounts     `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+}
+
+// repeated fixed64, strings in JSON
+type otlpCounts []uint64
+
+func (c otlpCounts) MarshalJSON() ([]byte, error) {
+	s := make([]string, len(c))
+	for i, v := range c {
+		s[i] = strconv.FormatUint(v, 10)
+	}
+	return json.Marshal(s)
+}
+
+// protobuf wire format, field numbers from opentelemetry-proto v1
+
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+type protoEncoder struct {
+	b []byte
+}
+
+func (p *protoEncoder) key(field, wire int) {
+	p.b = binary.AppendUvarint(p.b, uint64(field)<<3|uint64(wire))
+}
+
+func (p *protoEncoder) varint(field int, v uint64) {
+	if v != 0 {
+		p.key(field, wireVarint)
+		p.b = binary.AppendUvarint(p.b, v)
+	}
+}
+
+func (p *protoEncoder) fixed64(field int, v uint64) {
+	if v != 0 {
+		p.key(field, wireFixed64)
+		p.b = binary.LittleEndian.AppendUint64(p.b, v)
+	}
+}
+
+// doubles are written even when zero, they are oneof or optional fields
+func (p *protoEncoder) double(field int, v float64) {
+	p.key(field, wireFixed64)
+	p.b = binary.LittleEndian.AppendUint64(p.b, math.Float64bits(v))
+}
+
+func (p *protoEncoder) bytes(field int, b []byte) {
+	p.key(field, wireBytes)
+	p.b = binary.AppendUvarint(p.b, uint64(len(b)))
+	p.b = append(p.b, b...)
+}
+
+func (p *protoEncoder) string(field int, s string) {
+	if s != "" {
+		p.bytes(field, []byte(s))
+	}
+}
+
+func (p *protoEncoder) message(field int, m otlpMessage) {
+	var sub protoEncoder
+	m.proto(&sub)
+	p.bytes(field, sub.b)
+}
+
+func (p *protoEncoder) packedFixed64(field int, vs []uint64) {
+	b := make([]byte, 0, 8*len(vs))
+	for _, v := range vs {
+		b = binary.LittleEndian.AppendUint64(b, v)
+	}
+	p.bytes(field, b)
+}
+
+func (p *protoEncoder) packedDouble(field int, vs []float64) {
+	b := make([]byte, 0, 8*len(vs))
+	for _, v := range vs {
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(v))
+	}
+	p.bytes(field, b)
+}
+
+func (v otlpValue) proto(p *protoEncoder) {
+	switch {
+	case v.StringValue != nil:
+		p.bytes(1, []byte(*v.StringValue))
+	case v.BoolValue != nil:
+		p.key(2, wireVarint)
+		if *v.BoolValue {
+			p.b = append(p.b, 1)
+		} else {
+			p.b = append(p.b, 0)
+		}
+	case v.IntValue != nil:
+		p.key(3, wireVarint)
+		p.b = binary.AppendUvarint(p.b, uint64(*v.IntValue))
+	case v.DoubleValue != nil:
+		p.double(4, *v.DoubleValue)
+	}
+}
+
+func (kv otlpKeyValue) proto(p *protoEncoder) {
+	p.string(1, kv.Key)
+	p.message(2, kv.Value)
+}
+
+func protoAttrs(p *protoEncoder, field int, attrs []otlpKeyValue) {
+	for _, kv := range attrs {
+		p.message(field, kv)
+	}
+}
+
+func (r otlpResource) proto(p *protoEncoder) {
+	protoAttrs(p, 1, r.Attributes)
+}
+
+func (s otlpScope) proto(p *protoEncoder) {
+	p.string(1, s.Name)
+	p.string(2, s.Version)
+}
+
+func (r *otlpLogsRequest) proto(p *protoEncoder) {
+	for _, rl := range r.ResourceLogs {
+		p.message(1, rl)
+	}
+}
+
+func (rl otlpResourceLogs) proto(p *protoEncoder) {
+	p.message(1, rl.Resource)
+	for _, sl := range rl.ScopeLogs {
+		p.message(2, sl)
+	}
+}
+
+func (sl otlpScopeLogs) proto(p *protoEncoder) {
+	p.message(1, sl.Scope)
+	for _, r := range sl.LogRecords {
+		p.message(2, r)
+	}
+}
+
+func (r otlpLogRecord) proto(p *protoEncoder) {
+	p.fixed64(1, r.TimeUnixNano)
+	p.varint(2, uint64(r.SeverityNumber))
+	p.string(3, r.SeverityText)
+	p.message(5, r.Body)
+	protoAttrs(p, 6, r.Attributes)
+	p.fixed64(11, r.ObservedTimeUnixNano)
+}
+
+func (r *otlpMetricsRequest) proto(p *protoEncoder) {
+	for _, rm := range r.ResourceMetrics {
+		p.message(1, rm)
+	}
+}
+
+func (rm otlpResourceMetrics) proto(p *protoEncoder) {
+	p.message(1, rm.Resource)
+	for _, sm := range rm.ScopeMetrics {
+		p.message(2, sm)
+	}
+}
+
+func (sm otlpScopeMetrics) proto(p *protoEncoder) {
+	p.message(1, sm.Scope)
+	for _, m := range sm.Metrics {
+		p.message(2, m)
+	}
+}
+
+func (m otlpMetric) proto(p *protoEncoder) {
+	p.string(1, m.Name)
+	p.string(2, m.Description)
+	p.string(3, m.Unit)
+	switch {
+	case m.Gauge != nil:
+		p.message(5, m.Gauge)
+	case m.Sum != nil:
+		p.message(7, m.Sum)
+	case m.Histogram != nil:
+		p.message(9, m.Histogram)
+	}
+}
+
+func (g *otlpGauge) proto(p *protoEncoder) {
+	for _, dp := range g.DataPoints {
+		p.message(1, dp)
+	}
+}
+
+func (s *otlpSum) proto(p *protoEncoder) {
+	for _, dp := range s.DataPoints {
+		p.message(1, dp)
+	}
+	p.varint(2, uint64(s.AggregationTemporality))
+	if s.IsMonotonic {
+		p.varint(3, 1)
+	}
+}
+
+func (h *otlpHistogram) proto(p *protoEncoder) {
+	for _, dp := range h.DataPoints {
+		p.message(1, dp)
+	}
+	p.varint(2, uint64(h.AggregationTemporality))
+}
+
+func (dp otlpNumberPoint) proto(p *protoEncoder) {
+	p.fixed64(2, dp.StartTimeUnixNano)
+	p.fixed64(3, dp.TimeUnixNano)
+	p.double(4, dp.AsDouble)
+	protoAttrs(p, 7, dp.Attributes)
+}
+
+func (dp otlpHistogramPoint) proto(p *protoEncoder) {
+	p.fixed64(2, dp.StartTimeUnixNano)
+	p.fixed64(3, dp.TimeUnixNano)
+	p.fixed64(4, dp.Count)
+	p.double(5, dp.Sum)
+	p.packedFixed64(6, dp.BucketCounts)
+	p.packedDouble(7, dp.ExplicitBounds)
+	protoAttrs(p, 9, dp.Attributes)
+}
@@ -0,0 +1,539 @@
+package internal
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type collectorRequest struct {
+	path, contentType, auth string
+	body                    []byte
+}
+
+// fakeCollector records the export requests, answering with the statuses in
+// order and 200 once they run out
+type fakeCollector struct {
+	mu         sync.Mutex
+	requests   []collectorRequest
+	statuses   []int
+	retryAfter string // sent with every failure
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, collectorRequest{r.URL.Path, r.Header.Get("Content-Type"), r.Header.Get("Authorization"), body})
+	if len(c.statuses) > 0 {
+		if c.retryAfter != "" {
+			w.Header().Set("Retry-After", c.retryAfter)
+		}
+		w.WriteHeader(c.statuses[0])
+		c.statuses = c.statuses[1:]
+	}
+}
+
+func (c *fakeCollector) received() []collectorRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]collectorRequest(nil), c.requests...)
+}
+
+func startFakeCollector(t *testing.T, statuses ...int) (*fakeCollector, string) {
+	c := &fakeCollector{statuses: statuses}
+	srv := httptest.NewServer(c)
+	t.Cleanup(srv.Close)
+	return c, srv.URL
+}
+
+func newTestOTLPExporter(t *testing.T, cfg OTLPConfig) *OTLPExporter {
+	t.Helper()
+	cfg.Backoff = time.Millisecond
+	e, err := NewOTLPExporter(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+	return e
+}
+
+func testRouterLog() *ParsedLog {
+	l := createTestParsedLog(503, "GET", "/users/42", "203.0.113.9", "web.2", 120*time.Millisecond, false)
+	l.Source = SourceRouter
+	l.Route = "/users/:id"
+	l.Host = "example.herokuapp.com"
+	l.Protocol = "https"
+	l.Size = 512
+	l.ErrorCode = "H12"
+	l.Level = "error"
+	l.Severity = 6
+	l.Message = "at=error code=H12 desc=\"Request timeout\""
+	return l
+}
+
+type protoField struct {
+	num, wire int
+	v         uint64 // varint and fixed64
+	b         []byte // length delimited
+}
+
+func decodeProto(t *testing.T, b []byte) []protoField {
+	t.Helper()
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatalf("Bad field key")
+		}
+		b = b[n:]
+		f := protoField{num: int(key >> 3), wire: int(key & 7)}
+		switch f.wire {
+		case wireVarint:
+			f.v, n = binary.Uvarint(b)
+			b = b[n:]
+		case wireFixed64:
+			f.v = binary.LittleEndian.Uint64(b)
+			b = b[8:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			f.b = b[n : n+int(size)]
+			b = b[n+int(size):]
+		default:
+			t.Fatalf("Unexpected wire type %d", f.wire)
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func protoGet(fields []protoField, num int) []protoField {
+	var out []protoField
+	for _, f := range fields {
+		if f.num == num {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// attributes of a proto message as key | AnyValue fields
+func protoAttrMap(t *testing.T, fields []protoField, num int) map[string][]protoField {
+	attrs := make(map[string][]protoField)
+	for _, kv := range protoGet(fields, num) {
+		kvFields := decodeProto(t, kv.b)
+		attrs[string(protoGet(kvFields, 1)[0].b)] = decodeProto(t, protoGet(kvFields, 2)[0].b)
+	}
+	return attrs
+}
+
+func TestOTLPExporter_ExportLogs_JSON(t *testing.T) {
+	collector, endpoint := startFakeCollector(t)
+	e := newTestOTLPExporter(t, OTLPConfig{
+		Endpoint: endpoint + "/",
+		Protocol: OTLPJSON,
+		Headers:  []string{"Authorization=Bearer%20secret"},
+	})
+	app := &ParsedLog{Source: SourceApp, SourceDyno: "web.1", Severity: 3, Message: "payment failed",
+		Attrs: map[string]any{"order_id": float64(7), "amount": 9.5, "retry": true}}
+	if err := e.exportLogs([]*ParsedLog{testRouterLog(), app}, time.Now()); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	reqs := collector.received()
+	if len(reqs) != 1 || reqs[0].path != "/v1/logs" || reqs[0].contentType != "application/json" || reqs[0].auth != "Bearer secret" {
+		t.Fatalf("Unexpected requests %+v", reqs)
+	}
+	var body struct {
+		ResourceLogs []struct {
+			Resource  struct{ Attributes []otlpKeyValue }
+			ScopeLogs []struct {
+				LogRecords []struct {
+					TimeUnixNano   string
+					SeverityNumber int
+					SeverityText   string
+					Body           otlpValue
+					Attributes     []otlpKeyValue
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(reqs[0].body, &body); err != nil {
+		t.Fatalf("Bad JSON: %v", err)
+	}
+	rl := body.ResourceLogs[0]
+	if v := rl.Resource.Attributes[0]; v.Key != "service.name" || *v.Value.StringValue != defaultOTLPServiceName {
+		t.Errorf("Unexpected resource %+v", rl.Resource)
+	}
+	records := rl.ScopeLogs[0].LogRecords
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+
+	router := records[0]
+	if router.SeverityNumber != 17 || router.SeverityText != "error" || router.TimeUnixNano == "" || router.TimeUnixNano == "0" {
+		t.Errorf("Unexpected record %+v", router)
+	}
+	attrs := make(map[string]otlpValue)
+	for _, kv := range router.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	for key, want := range map[string]string{
+		"http.request.method": "GET",
+		"http.route":          "/users/:id",
+		"url.path":            "/users/42",
+		"url.scheme":          "https",
+		"server.address":      "example.herokuapp.com",
+		"client.address":      "203.0.113.9",
+		"error.type":          "H12",
+		"heroku.dyno":         "web.2",
+	} {
+		if v, ok := attrs[key]; !ok || v.StringValue == nil || *v.StringValue != want {
+			t.Errorf("%s: expected %q, got %+v", key, want, v)
+		}
+	}
+	if v := attrs["http.response.status_code"]; v.IntValue == nil || *v.IntValue != 503 {
+		t.Errorf("Expected status 503 as an int, got %+v", v)
+	}
+	if v := attrs["heroku.router.service_ms"]; v.DoubleValue == nil || *v.DoubleValue != 120 {
+		t.Errorf("Expected a service time of 120ms, got %+v", v)
+	}
+
+	appRecord := records[1]
+	if appRecord.SeverityNumber != 17 || appRecord.SeverityText != "err" || *appRecord.Body.StringValue != "payment failed" {
+		t.Errorf("Unexpected app record %+v", appRecord)
+	}
+	attrs = make(map[string]otlpValue)
+	for _, kv := range appRecord.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if v := attrs["app.order_id"]; v.IntValue == nil || *v.IntValue != 7 {
+		t.Errorf("Expected order_id as an int, got %+v", v)
+	}
+	if v := attrs["app.amount"]; v.DoubleValue == nil || *v.DoubleValue != 9.5 {
+		t.Errorf("Expected amount as a double, got %+v", v)
+	}
+	if v := attrs["app.retry"]; v.BoolValue == nil || !*v.BoolValue {
+		t.Errorf("Expected retry as a bool, got %+v", v)
+	}
+}
+
+func TestOTLPExporter_ExportLogs_Protobuf(t *testing.T) {
+	collector, endpoint := startFakeCollector(t)
+	e := newTestOTLPExporter(t, OTLPConfig{Endpoint: endpoint})
+	l := testRouterLog()
+	if err := e.exportLogs([]*ParsedLog{l, l}, time.Now()); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	reqs := collector.received()
+	if len(reqs) != 1 || reqs[0].contentType != "application/x-protobuf" {
+		t.Fatalf("Unexpected requests %+v", reqs)
+	}
+	resourceLogs := decodeProto(t, protoGet(decodeProto(t, reqs[0].body), 1)[0].b)
+	resource := decodeProto(t, protoGet(resourceLogs, 1)[0].b)
+	if v := protoAttrMap(t, resource, 1)["service.name"]; string(v[0].b) != defaultOTLPServiceName {
+		t.Errorf("Unexpected resource %+v", resource)
+	}
+	scopeLogs := decodeProto(t, protoGet(resourceLogs, 2)[0].b)
+	if scope := decodeProto(t, protoGet(scopeLogs, 1)[0].b); string(protoGet(scope, 1)[0].b) != otlpScopeName {
+		t.Errorf("Unexpected scope %+v", scope)
+	}
+	records := protoGet(scopeLogs, 2)
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+
+	record := decodeProto(t, records[0].b)
+	if got := protoGet(record, 1)[0].v; got != uint64(l.Time.UnixNano()) {
+		t.Errorf("Expected time_unix_nano %d, got %d", l.Time.UnixNano(), got)
+	}
+	if got := protoGet(record, 2)[0].v; got != 17 {
+		t.Errorf("Expected severity ERROR, got %d", got)
+	}
+	if body := decodeProto(t, protoGet(record, 5)[0].b); string(protoGet(body, 1)[0].b) != l.Message {
+		t.Errorf("Unexpected body %+v", body)
+	}
+	if len(protoGet(record, 11)) != 1 {
+		t.Error("Expected observed_time_unix_nano")
+	}
+	attrs := protoAttrMap(t, record, 6)
+	if v := attrs["http.request.method"]; len(v) != 1 || v[0].num != 1 || string(v[0].b) != "GET" {
+		t.Errorf("Unexpected method %+v", v)
+	}
+	if v := attrs["http.response.status_code"]; len(v) != 1 || v[0].num != 3 || v[0].v != 503 {
+		t.Errorf("Unexpected status code %+v", v)
+	}
+	if v := attrs["heroku.router.service_ms"]; len(v) != 1 || v[0].num != 4 || math.Float64frombits(v[0].v) != 120 {
+		t.Errorf("Unexpected service time %+v", v)
+	}
+}
+
+func TestOTLPExporter_Retry(t *testing.T) {
+	collector, endpoint := startFakeCollector(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	e := newTestOTLPExporter(t, OTLPConfig{Endpoint: endpoint})
+	if err := e.exportLogs([]*ParsedLog{testRouterLog()}, time.Now()); err != nil {
+		t.Fatalf("Expected the export to succeed on the third attempt: %v", err)
+	}
+	if n := len(collector.received()); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+
+	collector, endpoint = startFakeCollector(t, http.StatusBadRequest)
+	e = newTestOTLPExporter(t, OTLPConfig{Endpoint: endpoint})
+	if err := e.exportLogs([]*ParsedLog{testRouterLog()}, time.Now()); !errors.Is(err, errPermanent) {
+		t.Errorf("Expected a permanent failure, got %v", err)
+	}
+	if n := len(collector.received()); n != 1 {
+		t.Errorf("Expected no retry of a 400, got %d attempts", n)
+	}
+
+	collector, endpoint = startFakeCollector(t, 503, 503, 503, 503, 503)
+	retries := 2
+	e = newTestOTLPExporter(t, OTLPConfig{Endpoint: endpoint, Retries: &retries})
+	if err := e.exportLogs([]*ParsedLog{testRouterLog()}, time.Now()); err == nil {
+		t.Error("Expected the export to fail once the retries ran out")
+	}
+	if n := len(collector.received()); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+
+	collector, endpoint = startFakeCollector(t, 503, 503)
+	retries = 0
+	e = newTestOTLPExporter(t, OTLPConfig{Endpoint: endpoint, Retries: &retries})
+	if err := e.exportLogs([]*ParsedLog{testRouterLog()}, time.Now()); err == nil {
+		t.Error("Expected the export to fail without retries")
+	}
+	if n := len(collector.received()); n != 1 {
+		t.Errorf("Expected 1 attempt with retries off, got %d", n)
+	}
+}
+
+func TestOTLPExporter_RetryAfter(t *testing.T) {
+	collector, endpoint := startFakeCollector(t, http.StatusTooManyRequests)
+	collector.retryAfter = "1"
+	e := newTestOTLPExporter(t, OTLPConfig{Endpoint: endpoint})
+	start := time.Now()
+	if err := e.exportLogs([]*ParsedLog{testRouterLog()}, time.Now()); err != nil {
+		t.Fatalf("Expected the export to succeed on the second attempt: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Errorf("Expected the retry after the collector's Retry-After, got %v", elapsed)
+	}
+
+	now := time.Date(2025, 7, 19, 12, 0, 0, 0, time.UTC)
+	for v, want := range map[string]time.Duration{
+		"":                              0,
+		"5":                             5 * time.Second,
+		"-5":                            0,
+		"3600":                          maxOTLPRetryAfter,
+		"Sat, 19 Jul 2025 12:00:30 GMT": 30 * time.Second,
+		"soon":                          0,
+	} {
+		if got := parseRetryAfter(v, now); got != want {
+			t.Errorf("Retry-After %q: expected %v, got %v", v, want, got)
+		}
+	}
+}
+
+func TestApp_StartOTLPExporter_SlowCollector(t *testing.T) {
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(release) })
+
+	app := createTestAppForMetrics()
+	app.OTLP = newTestOTLPExporter(t, OTLPConfig{Endpoint: srv.URL, BatchSize: 1})
+	go app.StartOTLPExporter()
+	for i := 0; i < 10; i++ {
+		app.OTLP.Export(testRouterLog())
+	}
+
+	// at most one request stuck at the collector, the pending ones waiting, the
+	// rest dropped
+	time.Sleep(100 * time.Millisecond)
+	if n := len(app.OTLP.logs); n != 0 {
+		t.Errorf("Expected the queue drained, %d records left", n)
+	}
+	if n := app.OTLP.dropped.Load(); n < 10-1-otlpPendingExports || n > 10-otlpPendingExports {
+		t.Errorf("Expected about %d records dropped, got %d", 10-1-otlpPendingExports, n)
+	}
+}
+
+func TestApp_StartOTLPExporter_Batching(t *testing.T) {
+	collector, endpoint := startFakeCollector(t)
+	app := createTestAppForMetrics()
+	app.OTLP = newTestOTLPExporter(t, OTLPConfig{
+		Endpoint:      endpoint,
+		Protocol:      OTLPJSON,
+		BatchSize:     2,
+		FlushInterval: 200 * time.Millisecond,
+		QueueSize:     3,
+	})
+	for i := 0; i < 5; i++ {
+		app.OTLP.Export(testRouterLog())
+	}
+	if n := app.OTLP.dropped.Load(); n != 2 {
+		t.Errorf("Expected 2 records dropped by the full queue, got %d", n)
+	}
+	go app.StartOTLPExporter()
+
+	// a full batch goes out at once, the rest with the next flush
+	time.Sleep(100 * time.Millisecond)
+	if reqs := collector.received(); len(reqs) != 1 {
+		t.Fatalf("Expected the full batch sent, got %d requests", len(reqs))
+	}
+	time.Sleep(200 * time.Millisecond)
+	reqs := collector.received()
+	if len(reqs) != 2 {
+		t.Fatalf("Expected the remainder flushed, got %d requests", len(reqs))
+	}
+	var body otlpLogsRequest
+	if err := json.Unmarshal(reqs[1].body, &body); err != nil {
+		t.Fatalf("Bad JSON: %v", err)
+	}
+	if n := len(body.ResourceLogs[0].ScopeLogs[0].LogRecords); n != 1 {
+		t.Errorf("Expected 1 record in the flush, got %d", n)
+	}
+
+	var nilExporter *OTLPExporter
+	nilExporter.Export(testRouterLog())
+}
+
+func TestApp_OTLPMetrics(t *testing.T) {
+	collector, endpoint := startFakeCollector(t)
+	app := createPrometheusTestApp()
+	app.OTLP = newTestOTLPExporter(t, OTLPConfig{Endpoint: endpoint, Protocol: OTLPJSON})
+	app.MetricChan <- createTestParsedLog(200, "GET", "/users/1", "", "web.1", 40*time.Millisecond, false)
+	app.MetricChan <- createTestParsedLog(503, "PATCH", "/checkout", "", "web.2", 6*time.Second, true)
+	time.Sleep(100 * time.Millisecond)
+
+	metrics := app.otlpMetrics(time.Now())
+	byName := make(map[string]otlpMetric)
+	for _, m := range metrics {
+		if _, dup := byName[m.Name]; dup {
+			t.Errorf("Metric %s sent twice", m.Name)
+		}
+		byName[m.Name] = m
+	}
+
+	duration := byName["http.server.request.duration"]
+	if duration.Histogram == nil || duration.Histogram.AggregationTemporality != otlpCumulative || len(duration.Histogram.DataPoints) != 2 {
+		t.Fatalf("Expected a cumulative histogram per route, got %+v", duration)
+	}
+	for _, dp := range duration.Histogram.DataPoints {
+		var total uint64
+		for _, c := range dp.BucketCounts {
+			total += c
+		}
+		if total != dp.Count || len(dp.BucketCounts) != len(dp.ExplicitBounds)+1 || dp.Attributes[0].Key != "http.route" {
+			t.Errorf("Inconsistent histogram point %+v", dp)
+		}
+		if dp.StartTimeUnixNano == 0 || dp.StartTimeUnixNano > dp.TimeUnixNano {
+			t.Errorf("Expected a start before the point, got %d and %d", dp.StartTimeUnixNano, dp.TimeUnixNano)
+		}
+	}
+
+	requests := byName["parseflow.requests"]
+	if requests.Sum == nil || !requests.Sum.IsMonotonic || requests.Sum.DataPoints[3].AsDouble != 1 {
+		t.Errorf("Expected one 5xx in a monotonic sum, got %+v", requests)
+	}
+	methods := byName["parseflow.requests.by_method"].Sum.DataPoints
+	if other := methods[len(methods)-1]; *other.Attributes[0].Value.StringValue != "_OTHER" || other.AsDouble != 1 {
+		t.Errorf("Expected PATCH counted as _OTHER, got %+v", other)
+	}
+	if g := byName["parseflow.dyno.requests"]; g.Sum == nil || len(g.Sum.DataPoints) != 2 {
+		t.Errorf("Expected a point per dyno, got %+v", g)
+	}
+
+	// both encodings carry every metric
+	if err := app.OTLP.exportMetrics(metrics); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	app.OTLP.cfg.Protocol = OTLPProtobuf
+	if err := app.OTLP.exportMetrics(metrics); err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+	reqs := collector.received()
+	if len(reqs) != 2 || reqs[0].path != "/v1/metrics" {
+		t.Fatalf("Unexpected requests %+v", reqs)
+	}
+
+	var body struct {
+		ResourceMetrics []struct {
+			ScopeMetrics []struct {
+				Metrics []struct {
+					Name      string
+					Histogram *struct {
+						DataPoints []struct {
+							Count        string
+							BucketCounts []string
+						}
+					}
+				}
+			}
+		}
+	}
+	if err := json.Unmarshal(reqs[0].body, &body); err != nil {
+		t.Fatalf("Bad JSON: %v", err)
+	}
+	jsonMetrics := body.ResourceMetrics[0].ScopeMetrics[0].Metrics
+	if len(jsonMetrics) != len(metrics) || jsonMetrics[0].Histogram == nil || jsonMetrics[0].Histogram.DataPoints[0].Count != "1" {
+		t.Errorf("Unexpected JSON metrics %+v", jsonMetrics)
+	}
+
+	scopeMetrics := decodeProto(t, protoGet(decodeProto(t, protoGet(decodeProto(t, reqs[1].body), 1)[0].b), 2)[0].b)
+	protoMetrics := protoGet(scopeMetrics, 2)
+	if len(protoMetrics) != len(metrics) {
+		t.Fatalf("Expected %d metrics, got %d", len(metrics), len(protoMetrics))
+	}
+	first := decodeProto(t, protoMetrics[0].b)
+	if string(protoGet(first, 1)[0].b) != "http.server.request.duration" || len(protoGet(first, 9)) != 1 {
+		t.Errorf("Expected the histogram first, got %+v", first)
+	}
+	point := decodeProto(t, protoGet(decodeProto(t, protoGet(first, 9)[0].b), 1)[0].b)
+	if counts := protoGet(point, 6)[0].b; len(counts) != 8*(len(promLatencyBuckets)+1) {
+		t.Errorf("Expected packed bucket counts, got %d bytes", len(counts))
+	}
+}
+
+func TestOTLPSeverity(t *testing.T) {
+	for _, tt := range []struct {
+		level    string
+		severity int
+		number   int
+		text     string
+	}{
+		{"", 6, 9, "info"},
+		{"", 3, 17, "err"},
+		{"", 0, 24, "emerg"},
+		{"error", 6, 17, "error"},
+		{"WARN", 6, 13, "WARN"},
+		{"", 42, 0, ""},
+	} {
+		number, text := otlpSeverity(&ParsedLog{Level: tt.level, Severity: tt.severity})
+		if number != tt.number || text != tt.text {
+			t.Errorf("%q/%d: expected %d %q, got %d %q", tt.level, tt.severity, tt.number, tt.text, number, text)
+		}
+	}
+}
+
+func TestNewOTLPExporter_Invalid(t *testing.T) {
+	for _, cfg := range []OTLPConfig{
+		{Endpoint: "collector:4318"},
+		{Endpoint: "http://collector:4318", Protocol: "grpc"},
+		{Endpoint: "http://collector:4318", Headers: []string{"no-value"}},
+	} {
+		if _, err := NewOTLPExporter(cfg); err == nil {
+			t.Errorf("Expected %+v rejected", cfg)
+		}
+	}
+}
@@ -6,33 +6,113 @@ import (
 )
 
 func (a *App) ParseLog(logByte []byte) map[string]string {
-	logString := string(logByte)
-	fields := strings.Fields(logString)
-	logParts := make(map[string]string)
+	parsedlog, logParts := a.parseLine(string(logByte))
+	if parsedlog == nil {
+		return logParts
+	}
+
+	// Always send parsed logs (BuildParsedLog now returns valid struct with defaults)
+	a.ParsedLogChan <- parsedlog
 
-	for _, f := range fields {
-		if strings.Contains(f, "=") {
-			parts := strings.SplitN(f, "=", 2)
-			k := parts[0]
-			v := parts[1]
-			logParts[k] = v
+	return logParts
+
+}
 
+// parseLine runs one line through the parsers, nil when it is malformed
+func (a *App) parseLine(logString string) (*ParsedLog, map[string]string) {
+	hdr, msg, err := ParseSyslog(logString)
+	if err != nil {
+		// not a drain line, fall back to "<timestamp> <source>: <msg>"
+		msg = logString
+	}
+	logParts := TokenizeKV(msg)
+	if hdr == nil {
+		f := strings.SplitN(logString, " ", 2)
+		if len(f) < 2 {
+			log.Println("Malformed Request Received")
+			return nil, map[string]string{}
+		}
+		hdr = &SyslogHeader{Timestamp: f[0]}
+		msg = f[1]
+		if tag, rest := nextSyslogToken(f[1]); tag != "" {
+			var ok bool
+			hdr.AppName, hdr.ProcId, ok = parseSourceTag(tag)
+			if ok {
+				msg = rest
+			}
 		}
 	}
-	f := strings.SplitN(logString, " ", 2)
-	if len(f) < 2 {
-		log.Println("Malformed Request Received")
-		return map[string]string{}
+	logParts["timestamp"] = hdr.Timestamp
+	return a.parsers().Parse(hdr, msg, logParts), logParts
+}
+
+// TokenizeKV collects the key=value pairs of a line. Values may be double quoted,
+// in which case the quotes are stripped and \" and \\ are unescaped.
+// Words that are not pairs are skipped, quoted spans included.
+func TokenizeKV(s string) map[string]string {
+	kv := make(map[string]string)
+	i := 0
+	for i < len(s) {
+		for i < len(s) && isKVSpace(s[i]) {
+			i++
+		}
+		start := i
+		for i < len(s) && !isKVSpace(s[i]) && s[i] != '=' && s[i] != '"' {
+			i++
+		}
+		if i < len(s) && s[i] == '=' && i > start {
+			key := s[start:i]
+			var value string
+			value, i = readKVValue(s, i+1)
+			kv[key] = value
+			continue
+		}
+		// not a pair, skip the rest of the word
+		_, i = readKVValue(s, i)
 	}
-	timeStampstr := f[0]
-	logParts["timestamp"] = timeStampstr
-	parsedlog := BuildParsedLog(logParts)
+	return kv
+}
 
-	// Always send parsed logs (BuildParsedLog now returns valid struct with defaults)
-	a.ParsedLogChan <- parsedlog
+// reads up to the next unquoted whitespace, unquoting along the way
+func readKVValue(s string, i int) (string, int) {
+	var b strings.Builder
+	inQuote := false
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case inQuote && c == '\\' && i+1 < len(s) && (s[i+1] == '"' || s[i+1] == '\\'):
+			i++
+			b.WriteByte(s[i])
+		case c == '"':
+			inQuote = !inQuote
+		case !inQuote && isKVSpace(c):
+			return b.String(), i
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), i
+}
 
-	return logParts
+func isKVSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
 
+func applySyslogHeader(l *ParsedLog, h *SyslogHeader) {
+	l.Facility = h.Facility
+	l.Severity = h.Severity
+	l.Hostname = h.Hostname
+	l.AppName = h.AppName
+	l.ProcId = h.ProcId
+	l.MsgId = h.MsgId
+	l.StructuredData = h.StructuredData
+}
+
+func (a *App) parsers() *ParserRegistry {
+	if a.Parsers != nil {
+		return a.Parsers
+	}
+	return defaultParsers
 }
 
 // Reads from RawLogChan and Parses
@@ -2,6 +2,7 @@ package internal
 
 import (
 	"bytes"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -24,10 +25,10 @@ func TestApp_ParseLog(t *testing.T) {
 			"timestamp":  "2025-07-19T10:30:45.123456+00:00",
 			"at":         "info",
 			"method":     "GET",
-			"path":       `"/api/users"`,
+			"path":       "/api/users",
 			"host":       "myapp.herokuapp.com",
 			"request_id": "req-123",
-			"fwd":        `"192.168.1.1"`,
+			"fwd":        "192.168.1.1",
 			"dyno":       "web.1",
 			"connect":    "10ms",
 			"service":    "150ms",
@@ -72,12 +73,12 @@ func TestApp_ParseLog(t *testing.T) {
 
 		result := app.ParseLog([]byte(logEntry))
 
-		if result["path"] != `"/api/users/create"` {
-			t.Errorf("Expected path %q, got %q", `"/api/users/create"`, result["path"])
+		if result["path"] != "/api/users/create" {
+			t.Errorf("Expected path %q, got %q", "/api/users/create", result["path"])
 		}
 
-		if result["fwd"] != `"203.0.113.1"` {
-			t.Errorf("Expected fwd %q, got %q", `"203.0.113.1"`, result["fwd"])
+		if result["fwd"] != "203.0.113.1" {
+			t.Errorf("Expected fwd %q, got %q", "203.0.113.1", result["fwd"])
 		}
 
 		// Consume the channel
@@ -93,7 +94,7 @@ func TestApp_ParseLog(t *testing.T) {
 
 		result := app.ParseLog([]byte(logEntry))
 
-		if result["path"] != `"/api/users?id=123&sort=name"` {
+		if result["path"] != "/api/users?id=123&sort=name" {
 			t.Errorf("Expected path with query params, got %q", result["path"])
 		}
 
@@ -204,6 +205,50 @@ func TestApp_ParseLog(t *testing.T) {
 		<-app.ParsedLogChan
 	})
 
+	t.Run("rfc5424 drain line", func(t *testing.T) {
+		app := &App{
+			ParsedLogChan: make(chan *ParsedLog, 1),
+		}
+
+		logEntry := `<158>1 2025-07-19T10:30:45.123456+00:00 host heroku router - at=info method=GET path="/api" dyno=web.2 status=200`
+
+		result := app.ParseLog([]byte(logEntry))
+
+		if result["timestamp"] != "2025-07-19T10:30:45.123456+00:00" {
+			t.Errorf("Expected header timestamp, got %q", result["timestamp"])
+		}
+
+		parsedLog := <-app.ParsedLogChan
+		if parsedLog.Time.IsZero() {
+			t.Error("Expected timestamp to be parsed from syslog header")
+		}
+		if parsedLog.Facility != 19 || parsedLog.Severity != 6 {
+			t.Errorf("Expected facility 19 severity 6, got %d %d", parsedLog.Facility, parsedLog.Severity)
+		}
+		if parsedLog.AppName != "heroku" || parsedLog.ProcId != "router" {
+			t.Errorf("Expected heroku/router, got %q/%q", parsedLog.AppName, parsedLog.ProcId)
+		}
+		if parsedLog.Hostname != "host" {
+			t.Errorf("Expected hostname host, got %q", parsedLog.Hostname)
+		}
+		if parsedLog.Status != 200 || parsedLog.SourceDyno != "web.2" {
+			t.Errorf("Expected router fields from message, got status %d dyno %q", parsedLog.Status, parsedLog.SourceDyno)
+		}
+	})
+
+	t.Run("legacy line source tag", func(t *testing.T) {
+		app := &App{
+			ParsedLogChan: make(chan *ParsedLog, 1),
+		}
+
+		app.ParseLog([]byte(`2025-07-19T10:30:45.123456+00:00 app[web.1]: Hello world`))
+
+		parsedLog := <-app.ParsedLogChan
+		if parsedLog.AppName != "app" || parsedLog.ProcId != "web.1" {
+			t.Errorf("Expected app/web.1, got %q/%q", parsedLog.AppName, parsedLog.ProcId)
+		}
+	})
+
 	t.Run("log with equals in value", func(t *testing.T) {
 		app := &App{
 			ParsedLogChan: make(chan *ParsedLog, 1),
@@ -215,7 +260,7 @@ func TestApp_ParseLog(t *testing.T) {
 		result := app.ParseLog([]byte(logEntry))
 
 		// SplitN with limit 2 should handle this correctly
-		if result["path"] != `"/api?query=test=value"` {
+		if result["path"] != "/api?query=test=value" {
 			t.Errorf("Expected path with equals in query, got %q", result["path"])
 		}
 
@@ -367,10 +412,10 @@ func TestParseLogStructure(t *testing.T) {
 			"timestamp":  "2025-07-19T10:30:45.123456+00:00",
 			"at":         "info",
 			"method":     "GET",
-			"path":       `"/test"`,
+			"path":       "/test",
 			"host":       "example.com",
 			"request_id": "abc123",
-			"fwd":        `"1.2.3.4"`,
+			"fwd":        "1.2.3.4",
 			"dyno":       "web.1",
 			"connect":    "5ms",
 			"service":    "100ms",
@@ -432,41 +477,66 @@ func BenchmarkParseLogFields(b *testing.B) {
 	}
 }
 
+// Edge cases and error conditions, also the seed corpus for the fuzz tests
+var parseLogEdgeCases = []struct {
+	name        string
+	input       string
+	expectError bool
+	description string
+}{
+	{
+		name:        "unicode characters",
+		input:       `2025-07-19T10:30:45.123456+00:00 heroku[router]: at=info method=GET path="/api/测试" status=200`,
+		expectError: false,
+		description: "should handle unicode characters in values",
+	},
+	{
+		name:        "very long log line",
+		input:       `2025-07-19T10:30:45.123456+00:00 heroku[router]: at=info method=GET path="/api/` + strings.Repeat("x", 1000) + `" status=200`,
+		expectError: false,
+		description: "should handle very long log lines",
+	},
+	{
+		name:        "log with newlines",
+		input:       "2025-07-19T10:30:45.123456+00:00 heroku[router]: at=info method=GET\npath=\"/api\" status=200",
+		expectError: false,
+		description: "should handle logs with embedded newlines",
+	},
+	{
+		name:        "multiple equals in value",
+		input:       `2025-07-19T10:30:45.123456+00:00 heroku[router]: at=info path="/api?a=b=c=d" status=200`,
+		expectError: false,
+		description: "should handle multiple equals signs in values",
+	},
+	{
+		name:        "quoted value with spaces",
+		input:       `2025-07-19T10:30:45.123456+00:00 heroku[router]: at=error code=H12 desc="Request timeout" method=GET status=503`,
+		expectError: false,
+		description: "should keep quoted values with spaces together",
+	},
+	{
+		name:        "escaped quotes in value",
+		input:       `2025-07-19T10:30:45.123456+00:00 heroku[router]: at=info path="/say \"hi\"" status=200`,
+		expectError: false,
+		description: "should unescape quotes inside quoted values",
+	},
+	{
+		name:        "unterminated quote",
+		input:       `2025-07-19T10:30:45.123456+00:00 heroku[router]: at=info path="/api status=200`,
+		expectError: false,
+		description: "should read an unterminated quote to the end of the line",
+	},
+	{
+		name:        "rfc5424 line",
+		input:       `<158>1 2025-07-19T10:30:45.123456+00:00 host heroku router - at=info method=GET path="/" status=200`,
+		expectError: false,
+		description: "should parse drain lines with a syslog header",
+	},
+}
+
 // Test edge cases and error conditions
 func TestParseLogEdgeCases(t *testing.T) {
-	tests := []struct {
-		name        string
-		input       string
-		expectError bool
-		description string
-	}{
-		{
-			name:        "unicode characters",
-			input:       `2025-07-19T10:30:45.123456+00:00 heroku[router]: at=info method=GET path="/api/测试" status=200`,
-			expectError: false,
-			description: "should handle unicode characters in values",
-		},
-		{
-			name:        "very long log line",
-			input:       `2025-07-19T10:30:45.123456+00:00 heroku[router]: at=info method=GET path="/api/` + strings.Repeat("x", 1000) + `" status=200`,
-			expectError: false,
-			description: "should handle very long log lines",
-		},
-		{
-			name:        "log with newlines",
-			input:       "2025-07-19T10:30:45.123456+00:00 heroku[router]: at=info method=GET\npath=\"/api\" status=200",
-			expectError: false,
-			description: "should handle logs with embedded newlines",
-		},
-		{
-			name:        "multiple equals in value",
-			input:       `2025-07-19T10:30:45.123456+00:00 heroku[router]: at=info path="/api?a=b=c=d" status=200`,
-			expectError: false,
-			description: "should handle multiple equals signs in values",
-		},
-	}
-
-	for _, tt := range tests {
+	for _, tt := range parseLogEdgeCases {
 		t.Run(tt.name, func(t *testing.T) {
 			app := &App{
 				ParsedLogChan: make(chan *ParsedLog, 1),
@@ -496,3 +566,89 @@ func TestParseLogEdgeCases(t *testing.T) {
 		})
 	}
 }
+
+func TestTokenizeKV(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]string
+	}{
+		{"plain pairs", `at=info method=GET`, map[string]string{"at": "info", "method": "GET"}},
+		{"quoted with spaces", `code=H12 desc="Request timeout" status=503`, map[string]string{"code": "H12", "desc": "Request timeout", "status": "503"}},
+		{"escaped quote and backslash", `msg="say \"hi\" \\o/"`, map[string]string{"msg": `say "hi" \o/`}},
+		{"other escapes kept", `path="/a\nb"`, map[string]string{"path": `/a\nb`}},
+		{"empty values", `method= path=""`, map[string]string{"method": "", "path": ""}},
+		{"equals in value", `path=/a?b=c=d`, map[string]string{"path": "/a?b=c=d"}},
+		{"free text skipped", `Hello "quoted a=b" world x=1`, map[string]string{"x": "1"}},
+		{"empty key skipped", `=foo a=1`, map[string]string{"a": "1"}},
+		{"unterminated quote", `a="b c`, map[string]string{"a": "b c"}},
+		{"tabs and newlines", "a=1\tb=2\nc=3", map[string]string{"a": "1", "b": "2", "c": "3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := TokenizeKV(tt.input)
+			if len(got) != len(tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("Key %q: expected %q, got %q", k, v, got[k])
+				}
+			}
+		})
+	}
+}
+
+func FuzzTokenizeKV(f *testing.F) {
+	for _, tt := range parseLogEdgeCases {
+		f.Add(tt.input)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		for k, v := range TokenizeKV(input) {
+			if k == "" {
+				t.Errorf("Empty key in %q", input)
+			}
+			if strings.ContainsAny(k, " \t\n\r=\"") {
+				t.Errorf("Key %q contains a separator", k)
+			}
+			if !strings.Contains(input, k+"=") {
+				t.Errorf("Key %q not followed by = in input", k)
+			}
+			if len(v) > len(input) {
+				t.Errorf("Value %q longer than input", v)
+			}
+		}
+	})
+}
+
+func FuzzParseLog(f *testing.F) {
+	for _, tt := range parseLogEdgeCases {
+		f.Add([]byte(tt.input))
+	}
+
+	f.Fuzz(func(t *testing.T, input []byte) {
+		app := &App{
+			ParsedLogChan: make(chan *ParsedLog, 1),
+		}
+		log.SetOutput(io.Discard)
+		defer log.SetOutput(os.Stderr)
+
+		result := app.ParseLog(input)
+
+		select {
+		case l := <-app.ParsedLogChan:
+			if l.Source == "" {
+				t.Errorf("Parsed log without a source for %q", input)
+			}
+			if _, ok := result["timestamp"]; !ok {
+				t.Errorf("Expected timestamp key for %q", input)
+			}
+		default:
+			if len(result) != 0 {
+				t.Errorf("Expected empty result when nothing was sent, got %v", result)
+			}
+		}
+	})
+}
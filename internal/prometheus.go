@@ -0,0 +1,429 @@
+package internal
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Prometheus text exposition (0.0.4) and OpenMetrics 1.0 of the live metrics at
+// GET /metrics/prometheus. Counters are the totals since start, the latency
+// histograms come from the sketches since start with bucket counts within the
+// sketch error, the overall one refreshed with the percentiles. Rates,
+// percentiles and health are gauges. OpenMetrics is served when the scraper
+// asks for it in Accept or with ?format=openmetrics.
+
+const (
+	promTextContentType        = "text/plain; version=0.0.4; charset=utf-8"
+	promOpenMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// histogram bounds, up to the 30s router timeout
+var promLatencyBuckets = []time.Duration{
+	5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond, 50 * time.Millisecond,
+	100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second, 30 * time.Second,
+}
+
+type promWriter struct {
+	b           strings.Builder
+	openMetrics bool
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// family starts a metric family, counter names carry their _total suffix
+func (w *promWriter) family(name, typ, help string) {
+	if w.openMetrics && typ == "counter" {
+		name = strings.TrimSuffix(name, "_total")
+	}
+	fmt.Fprintf(&w.b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// sample writes name{labels} value, labels as name, value pairs
+func (w *promWriter) sample(name string, value float64, labels ...string) {
+	w.b.WriteString(name)
+	if len(labels) > 0 {
+		w.b.WriteByte('{')
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				w.b.WriteByte(',')
+			}
+			fmt.Fprintf(&w.b, `%s="%s"`, labels[i], promLabelEscaper.Replace(labels[i+1]))
+		}
+		w.b.WriteByte('}')
+	}
+	w.b.WriteByte(' ')
+	w.b.WriteString(promValue(value))
+	w.b.WriteByte('\n')
+}
+
+func promValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// histogram samples of s in seconds, the family written by the caller
+func (w *promWriter) histogram(name string, s *LatencySketch, labels ...string) {
+	if s == nil {
+		s = NewLatencySketch()
+	}
+	counts := s.CountAtMost(promLatencyBuckets...)
+	for i, bound := range promLatencyBuckets {
+		w.sample(name+"_bucket", float64(counts[i]), append(slices.Clone(labels), "le", promValue(bound.Seconds()))...)
+	}
+	w.sample(name+"_bucket", float64(s.Count), append(slices.Clone(labels), "le", "+Inf")...)
+	w.sample(name+"_sum", s.Sum/float64(time.Second), labels...)
+	w.sample(name+"_count", float64(s.Count), labels...)
+}
+
+func (w *promWriter) gauge(name, help string, value float64) {
+	w.family(name, "gauge", help)
+	w.sample(name, value)
+}
+
+func (w *promWriter) counter(name, help string, value float64) {
+	w.family(name, "counter", help)
+	w.sample(name, value)
+}
+
+const bytesPerMB = 1024 * 1024
+
+type promEndpoint struct {
+	EndpointMetric
+	bytes   int64
+	latency *LatencySketch
+}
+
+type promChannel struct {
+	name          string
+	length, limit int
+}
+
+// what the exposition needs besides the snapshot, taken under MetricsMu
+func (a *App) promState() ([]promEndpoint, int64, []promChannel) {
+	a.MetricsMu.RLock()
+	defer a.MetricsMu.RUnlock()
+
+	var endpoints []promEndpoint
+	var overflow int64
+	if a.Endpoints != nil {
+		metrics, _ := a.Endpoints.Metrics("traffic")
+		for _, m := range metrics {
+			c := a.Endpoints.routes[m.Route]
+			endpoints = append(endpoints, promEndpoint{m, c.bytes, c.latency.Clone()})
+		}
+		overflow = a.Endpoints.overflow
+	}
+
+	channels := []promChannel{
+		{"raw_logs", len(a.RawLogChan), cap(a.RawLogChan)},
+		{"parsed_logs", len(a.ParsedLogChan), cap(a.ParsedLogChan)},
+		{"metrics", len(a.MetricChan), cap(a.MetricChan)},
+		{"db_raw_writes", len(a.DbRawWriteChan), cap(a.DbRawWriteChan)},
+		{"db_snapshot_writes", len(a.DbWriteChan), cap(a.DbWriteChan)},
+		{"alerts", len(a.AlertChan), cap(a.AlertChan)},
+	}
+	return endpoints, overflow, channels
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// writeExposition renders every family of the snapshot
+func (a *App) writeExposition(w *promWriter, m *Metric) {
+	endpoints, overflow, channels := a.promState()
+
+	// traffic since start
+	w.counter("parseflow_requests_total", "Router requests processed.", float64(m.TotalRequests))
+	w.family("parseflow_responses_total", "counter", "Router requests by status class.")
+	for _, c := range []struct {
+		class string
+		n     int64
+	}{{"2xx", m.Status2xx}, {"3xx", m.Status3xx}, {"4xx", m.Status4xx}, {"5xx", m.Status5xx}} {
+		w.sample("parseflow_responses_total", float64(c.n), "class", c.class)
+	}
+	w.family("parseflow_requests_by_method_total", "counter", "Router requests by HTTP method.")
+	for _, c := range []struct {
+		method string
+		n      int64
+	}{{"GET", m.GetRequests}, {"POST", m.PostRequests}, {"PUT", m.PutRequests}, {"DELETE", m.DeleteRequests}, {"other", m.OtherRequests}} {
+		w.sample("parseflow_requests_by_method_total", float64(c.n), "method", c.method)
+	}
+	w.counter("parseflow_slow_requests_total", "Router requests over the slow threshold.", float64(m.SlowRequestCount))
+	w.family("parseflow_router_errors_total", "counter", "Heroku router errors by H-code.")
+	for _, code := range sortedKeys(m.RouterErrors) {
+		w.sample("parseflow_router_errors_total", float64(m.RouterErrors[code]), "code", code)
+	}
+	w.family("parseflow_router_errors_recent", "gauge", "Heroku router errors by H-code over the last minute.")
+	for _, code := range sortedKeys(m.RecentRouterErrors) {
+		w.sample("parseflow_router_errors_recent", float64(m.RecentRouterErrors[code]), "code", code)
+	}
+
+	w.gauge("parseflow_requests_per_second", "Router requests per second since start.", m.RequestsPerSecond)
+	w.gauge("parseflow_success_rate_percent", "Share of 2xx responses since start.", m.SuccessRate)
+	w.gauge("parseflow_error_rate_percent", "Share of 4xx and 5xx responses since start.", m.ErrorRate)
+	w.gauge("parseflow_apdex", "Apdex score since start.", m.Apdex)
+	w.gauge("parseflow_response_time_avg_seconds", "Mean response time over the latency window.", m.AvgResponseTime.Seconds())
+	w.family("parseflow_response_time_percentile_seconds", "gauge", "Response time percentiles over the latency window.")
+	for _, p := range []struct {
+		name string
+		d    time.Duration
+	}{{"50", m.P50ResponseTime}, {"90", m.P90ResponseTime}, {"95", m.P95ResponseTime}, {"99", m.P99ResponseTime}, {"99.9", m.P999ResponseTime}} {
+		w.sample("parseflow_response_time_percentile_seconds", p.d.Seconds(), "percentile", p.name)
+	}
+	w.family("parseflow_response_time_seconds", "histogram", "Router response times since start.")
+	w.histogram("parseflow_response_time_seconds", m.LatencySketch)
+
+	// rolling windows
+	windows := sortedKeys(m.Windows)
+	for _, f := range []struct {
+		name, help string
+		value      func(WindowMetric) float64
+	}{
+		{"parseflow_window_requests_per_second", "Router requests per second over the window.", func(v WindowMetric) float64 { return v.RequestsPerSecond }},
+		{"parseflow_window_error_rate_percent", "Share of 4xx and 5xx responses over the window.", func(v WindowMetric) float64 { return v.ErrorRate }},
+		{"parseflow_window_apdex", "Apdex score over the window.", func(v WindowMetric) float64 { return v.Apdex }},
+		{"parseflow_window_response_time_p95_seconds", "95th percentile response time over the window.", func(v WindowMetric) float64 { return v.P95ResponseTime.Seconds() }},
+	} {
+		w.family(f.name, "gauge", f.help)
+		for _, name := range windows {
+			w.sample(f.name, f.value(m.Windows[name]), "window", name)
+		}
+	}
+
+	// dynos
+	dynos := sortedKeys(m.DynoPerformance)
+	dynoFamily := func(name, typ, help string, value func(DynoMetric) float64) {
+		w.family(name, typ, help)
+		for _, dyno := range dynos {
+			w.sample(name, value(m.DynoPerformance[dyno]), "dyno", dyno)
+		}
+	}
+	dynoFamily("parseflow_dyno_requests_total", "counter", "Router requests served by the dyno.", func(d DynoMetric) float64 { return float64(d.RequestCount) })
+	dynoFamily("parseflow_dyno_error_rate_percent", "gauge", "Share of 4xx and 5xx responses of the dyno.", func(d DynoMetric) float64 { return d.ErrorRate })
+	dynoFamily("parseflow_dyno_apdex", "gauge", "Apdex score of the dyno.", func(d DynoMetric) float64 { return d.Apdex })
+	dynoFamily("parseflow_dyno_response_time_avg_seconds", "gauge", "Moving average response time of the dyno.", func(d DynoMetric) float64 { return d.AvgResponseTime.Seconds() })
+	w.family("parseflow_dyno_response_time_percentile_seconds", "gauge", "Response time percentiles of the dyno over the latency window.")
+	for _, dyno := range dynos {
+		d := m.DynoPerformance[dyno]
+		w.sample("parseflow_dyno_response_time_percentile_seconds", d.P50ResponseTime.Seconds(), "dyno", dyno, "percentile", "50")
+		w.sample("parseflow_dyno_response_time_percentile_seconds", d.P95ResponseTime.Seconds(), "dyno", dyno, "percentile", "95")
+		w.sample("parseflow_dyno_response_time_percentile_seconds", d.P99ResponseTime.Seconds(), "dyno", dyno, "percentile", "99")
+	}
+	w.family("parseflow_dyno_status", "gauge", "Health of the dyno, 1 for its current status.")
+	for _, dyno := range dynos {
+		w.sample("parseflow_dyno_status", 1, "dyno", dyno, "status", m.DynoPerformance[dyno].Status)
+	}
+	w.family("parseflow_dyno_errors_total", "counter", "Heroku H and R errors of the dyno by code.")
+	for _, dyno := range dynos {
+		codes := m.DynoPerformance[dyno].ErrorCodes
+		for _, code := range sortedKeys(codes) {
+			w.sample("parseflow_dyno_errors_total", float64(codes[code]), "dyno", dyno, "code", code)
+		}
+	}
+	w.family("parseflow_dyno_memory_bytes", "gauge", "Memory of the dyno from log-runtime-metrics.")
+	for _, dyno := range dynos {
+		d := m.DynoPerformance[dyno]
+		for _, s := range []struct {
+			kind string
+			mb   float64
+		}{{"total", d.MemoryTotal}, {"rss", d.MemoryRSS}, {"swap", d.MemorySwap}, {"quota", d.MemoryQuota}} {
+			w.sample("parseflow_dyno_memory_bytes", s.mb*bytesPerMB, "dyno", dyno, "kind", s.kind)
+		}
+	}
+	w.family("parseflow_dyno_load_average", "gauge", "Load average of the dyno from log-runtime-metrics.")
+	for _, dyno := range dynos {
+		d := m.DynoPerformance[dyno]
+		w.sample("parseflow_dyno_load_average", d.LoadAvg1m, "dyno", dyno, "period", "1m")
+		w.sample("parseflow_dyno_load_average", d.LoadAvg5m, "dyno", dyno, "period", "5m")
+		w.sample("parseflow_dyno_load_average", d.LoadAvg15m, "dyno", dyno, "period", "15m")
+	}
+	dynoFamily("parseflow_dyno_restarts_total", "counter", "Restarts of the dyno.", func(d DynoMetric) float64 { return float64(d.Restarts) })
+	dynoFamily("parseflow_dyno_crashes_total", "counter", "Crashes of the dyno.", func(d DynoMetric) float64 { return float64(d.Crashes) })
+
+	// endpoints
+	w.family("parseflow_endpoint_requests_total", "counter", "Router requests by normalized route and status class.")
+	for _, e := range endpoints {
+		w.sample("parseflow_endpoint_requests_total", float64(e.Status2xx), "route", e.Route, "class", "2xx")
+		w.sample("parseflow_endpoint_requests_total", float64(e.Status3xx), "route", e.Route, "class", "3xx")
+		w.sample("parseflow_endpoint_requests_total", float64(e.Status4xx), "route", e.Route, "class", "4xx")
+		w.sample("parseflow_endpoint_requests_total", float64(e.Status5xx), "route", e.Route, "class", "5xx")
+	}
+	w.family("parseflow_endpoint_response_bytes_total", "counter", "Response bytes by normalized route.")
+	for _, e := range endpoints {
+		w.sample("parseflow_endpoint_response_bytes_total", float64(e.bytes), "route", e.Route)
+	}
+	w.family("parseflow_endpoint_apdex", "gauge", "Apdex score by normalized route.")
+	for _, e := range endpoints {
+		w.sample("parseflow_endpoint_apdex", e.Apdex, "route", e.Route)
+	}
+	w.family("parseflow_endpoint_response_time_seconds", "histogram", "Response times by normalized route since start.")
+	for _, e := range endpoints {
+		w.histogram("parseflow_endpoint_response_time_seconds", e.latency, "route", e.Route)
+	}
+	w.counter("parseflow_endpoint_overflow_total", "Router requests folded into the (other) route past the route limit.", float64(overflow))
+
+	// countries, the tracked heavy hitters
+	w.family("parseflow_country_requests", "gauge", "Estimated router requests by client country, top countries only.")
+	for _, country := range sortedKeys(m.TopCountries) {
+		w.sample("parseflow_country_requests", float64(m.TopCountries[country]), "country", country)
+	}
+
+	// add-ons
+	databases := sortedKeys(m.DatabaseHealth)
+	for _, f := range []struct {
+		name, help string
+		value      func(DatabaseHealth) float64
+	}{
+		{"parseflow_database_connections_active", "Active connections of the add-on.", func(d DatabaseHealth) float64 { return float64(d.ActiveConnections) }},
+		{"parseflow_database_connections_waiting", "Waiting connections of the add-on.", func(d DatabaseHealth) float64 { return float64(d.WaitingConnections) }},
+		{"parseflow_database_connection_usage_percent", "Connections used of the plan limit.", func(d DatabaseHealth) float64 { return d.ConnectionUsage }},
+		{"parseflow_database_memory_usage_percent", "Memory used of the add-on.", func(d DatabaseHealth) float64 { return d.MemoryUsage }},
+		{"parseflow_database_size_bytes", "Size of the database.", func(d DatabaseHealth) float64 { return float64(d.DbSize) }},
+		{"parseflow_database_load_average_1m", "Load average of the add-on server.", func(d DatabaseHealth) float64 { return d.LoadAvg1m }},
+		{"parseflow_database_read_iops", "Read operations per second.", func(d DatabaseHealth) float64 { return d.ReadIops }},
+		{"parseflow_database_write_iops", "Write operations per second.", func(d DatabaseHealth) float64 { return d.WriteIops }},
+		{"parseflow_database_cache_hit_rate", "Table cache hit rate, the keyspace hit rate for redis.", func(d DatabaseHealth) float64 {
+			if d.Kind == "redis" {
+				return d.HitRate
+			}
+			return d.TableCacheHitRate
+		}},
+	} {
+		w.family(f.name, "gauge", f.help)
+		for _, name := range databases {
+			d := m.DatabaseHealth[name]
+			w.sample(f.name, f.value(d), "database", name, "kind", d.Kind)
+		}
+	}
+
+	// numeric fields of JSON app logs
+	fields := sortedKeys(m.FieldMetrics)
+	w.family("parseflow_field_observations_total", "counter", "Numeric values seen of the app log field.")
+	for _, field := range fields {
+		w.sample("parseflow_field_observations_total", float64(m.FieldMetrics[field].Count), "field", field)
+	}
+	w.family("parseflow_field_value", "gauge", "Summary of the numeric app log field.")
+	for _, field := range fields {
+		f := m.FieldMetrics[field]
+		w.sample("parseflow_field_value", f.Sum, "field", field, "stat", "sum")
+		w.sample("parseflow_field_value", f.Min, "field", field, "stat", "min")
+		w.sample("parseflow_field_value", f.Max, "field", field, "stat", "max")
+		w.sample("parseflow_field_value", f.Avg, "field", field, "stat", "avg")
+	}
+
+	// SLOs
+	for _, f := range []struct {
+		name, help string
+		value      func(SLOStatus) float64
+	}{
+		{"parseflow_slo_objective_percent", "Target share of good requests.", func(s SLOStatus) float64 { return s.Objective }},
+		{"parseflow_slo_compliance_percent", "Share of good requests over the SLO window.", func(s SLOStatus) float64 { return s.Compliance }},
+		{"parseflow_slo_error_budget_remaining_percent", "Error budget left over the SLO window, negative once overspent.", func(s SLOStatus) float64 { return s.BudgetRemaining }},
+	} {
+		w.family(f.name, "gauge", f.help)
+		for _, s := range m.SLOs {
+			w.sample(f.name, f.value(s), "slo", s.Name)
+		}
+	}
+	w.family("parseflow_slo_burn_rate", "gauge", "Error budget burn rate over the lookback window.")
+	for _, s := range m.SLOs {
+		for _, window := range sortedKeys(s.BurnRates) {
+			w.sample("parseflow_slo_burn_rate", s.BurnRates[window], "slo", s.Name, "window", window)
+		}
+	}
+
+	// alerts
+	active := make(map[[2]string]int)
+	for _, alert := range m.ActiveAlerts {
+		if !alert.Resolved && !alert.Silenced {
+			active[[2]string{alert.Type, alert.Severity}]++
+		}
+	}
+	keys := make([][2]string, 0, len(active))
+	for k := range active {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b [2]string) int { return strings.Compare(a[0]+"|"+a[1], b[0]+"|"+b[1]) })
+	w.family("parseflow_alerts_active", "gauge", "Firing alerts that are not silenced, by type and severity.")
+	for _, k := range keys {
+		w.sample("parseflow_alerts_active", float64(active[k]), "type", k[0], "severity", k[1])
+	}
+
+	// the pipeline itself
+	w.counter("parseflow_unparsed_logs_total", "Log lines no parser claimed.", float64(m.UnparsedLogs))
+	w.counter("parseflow_late_events_total", "Router lines behind the watermark, left out of the windows.", float64(m.EventTime.LateEvents))
+	w.counter("parseflow_zero_time_events_total", "Router lines without a usable timestamp.", float64(m.EventTime.ZeroTimeEvents))
+	w.counter("parseflow_future_events_total", "Router lines ahead of the clock by more than the allowed skew.", float64(m.EventTime.FutureEvents))
+	watermark := 0.0
+	if !m.EventTime.Watermark.IsZero() {
+		watermark = float64(m.EventTime.Watermark.UnixNano()) / float64(time.Second)
+	}
+	w.gauge("parseflow_event_watermark_seconds", "Event time watermark as a unix timestamp.", watermark)
+	w.gauge("parseflow_processing_rate", "Log lines processed per second.", m.ChannelHealth.ProcessingRate)
+	w.gauge("parseflow_backlog", "Log lines waiting to be parsed or aggregated.", float64(m.ChannelHealth.BacklogSize))
+	w.family("parseflow_channel_length", "gauge", "Items queued in the pipeline channel.")
+	for _, c := range channels {
+		w.sample("parseflow_channel_length", float64(c.length), "channel", c.name)
+	}
+	w.family("parseflow_channel_capacity", "gauge", "Capacity of the pipeline channel.")
+	for _, c := range channels {
+		w.sample("parseflow_channel_capacity", float64(c.limit), "channel", c.name)
+	}
+	w.gauge("parseflow_last_update_seconds", "Time of the last metrics update as a unix timestamp.", float64(m.Timestamp.UnixNano())/float64(time.Second))
+}
+
+// scrapers like Prometheus only send bearer tokens, the scrape endpoint alone
+// takes the api key that way too
+func (a *App) authorizeScrape(w http.ResponseWriter, r *http.Request) bool {
+	apiKey := r.Header.Get("X-API-KEY")
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); apiKey == "" && ok {
+		apiKey = token
+	}
+	return a.authorizeKey(w, apiKey)
+}
+
+// GET /metrics/prometheus, OpenMetrics with Accept: application/openmetrics-text or ?format=openmetrics
+func (a *App) PrometheusHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authorizeScrape(w, r) {
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format != "" && format != "prometheus" && format != "openmetrics" {
+		w.Header().Set("Content-Type", "application/json")
+		writeJSONError(w, http.StatusBadRequest, "format must be prometheus or openmetrics")
+		return
+	}
+	pw := &promWriter{
+		openMetrics: format == "openmetrics" ||
+			(format == "" && strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")),
+	}
+
+	a.writeExposition(pw, a.GetMetricsSnapshot())
+	if pw.openMetrics {
+		pw.b.WriteString("# EOF\n")
+		w.Header().Set("Content-Type", promOpenMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", promTextContentType)
+	}
+	w.Write([]byte(pw.b.String()))
+}
@@ -0,0 +1,203 @@
+package internal
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func createPrometheusTestApp() *App {
+	app := createTestAppForMetrics()
+	app.RateLimiter = NewRateLimiterMap(100, 10)
+	app.StartMetricsAggregator()
+	return app
+}
+
+func scrapePrometheus(app *App, accept, query string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/metrics/prometheus"+query, nil)
+	if accept != "" {
+		r.Header.Set("Accept", accept)
+	}
+	w := httptest.NewRecorder()
+	app.PrometheusHandler(w, r)
+	return w
+}
+
+// checks the exposition is well formed: families declared once, samples under
+// their family and cumulative histogram buckets, returns the samples by series
+func checkExposition(t *testing.T, body string, openMetrics bool) map[string]float64 {
+	t.Helper()
+	samples := make(map[string]float64)
+	families := make(map[string]string) // name | type
+	family, typ := "", ""
+	lastBucket := map[string]float64{}
+
+	lines := strings.Split(strings.TrimSuffix(body, "\n"), "\n")
+	if openMetrics {
+		if lines[len(lines)-1] != "# EOF" {
+			t.Fatalf("Expected # EOF last, got %q", lines[len(lines)-1])
+		}
+		lines = lines[:len(lines)-1]
+	}
+	for _, line := range lines {
+		if strings.HasPrefix(line, "# HELP ") {
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			parts := strings.Fields(line)
+			family, typ = parts[2], parts[3]
+			if _, dup := families[family]; dup {
+				t.Errorf("Family %s declared twice", family)
+			}
+			families[family] = typ
+			continue
+		}
+
+		i := strings.LastIndexByte(line, ' ')
+		series, value := line[:i], line[i+1:]
+		name := series
+		if j := strings.IndexByte(series, '{'); j >= 0 {
+			name = series[:j]
+		}
+		var allowed []string
+		switch {
+		case typ == "histogram":
+			allowed = []string{family + "_bucket", family + "_sum", family + "_count"}
+		case typ == "counter" && openMetrics:
+			allowed = []string{family + "_total"}
+		default:
+			allowed = []string{family}
+		}
+		if !strings.Contains(" "+strings.Join(allowed, " ")+" ", " "+name+" ") {
+			t.Errorf("Sample %s outside its family %s %s", name, family, typ)
+		}
+
+		v, err := strconv.ParseFloat(strings.Replace(value, "+Inf", "Inf", 1), 64)
+		if err != nil {
+			t.Errorf("Bad value in %q", line)
+		}
+		if strings.HasSuffix(name, "_bucket") {
+			key := series[:strings.Index(series, `le="`)]
+			if v < lastBucket[key] {
+				t.Errorf("Buckets of %s not cumulative at %q", key, line)
+			}
+			lastBucket[key] = v
+		}
+		samples[series] = v
+	}
+	return samples
+}
+
+func TestApp_PrometheusHandler(t *testing.T) {
+	app := createPrometheusTestApp()
+	app.MetricChan <- createTestParsedLog(200, "GET", "/users/1", "", "web.1", 40*time.Millisecond, false)
+	app.MetricChan <- createTestParsedLog(200, "POST", "/checkout", "", "web.1", 300*time.Millisecond, false)
+	app.MetricChan <- createTestParsedLog(503, "GET", "/checkout", "", "web.2", 6*time.Second, true)
+	// the overall histogram is refreshed with the percentiles
+	time.Sleep(percentileInterval)
+	app.MetricChan <- createTestParsedLog(200, "GET", "/users/2", "", "web.1", 20*time.Millisecond, false)
+	time.Sleep(100 * time.Millisecond)
+
+	w := scrapePrometheus(app, "", "")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != promTextContentType {
+		t.Fatalf("Expected the text format, got %d %s", w.Code, w.Header().Get("Content-Type"))
+	}
+	body := w.Body.String()
+	if !strings.Contains(body, "# TYPE parseflow_requests_total counter\n") {
+		t.Error("Expected counter families named with _total in the text format")
+	}
+	samples := checkExposition(t, body, false)
+
+	for series, want := range map[string]float64{
+		"parseflow_requests_total":                                                      4,
+		`parseflow_responses_total{class="5xx"}`:                                        1,
+		`parseflow_requests_by_method_total{method="POST"}`:                             1,
+		"parseflow_slow_requests_total":                                                 1,
+		`parseflow_response_time_seconds_bucket{le="0.05"}`:                             2,
+		`parseflow_response_time_seconds_bucket{le="0.5"}`:                              3,
+		`parseflow_response_time_seconds_bucket{le="+Inf"}`:                             4,
+		"parseflow_response_time_seconds_count":                                         4,
+		`parseflow_dyno_requests_total{dyno="web.1"}`:                                   3,
+		`parseflow_dyno_status{dyno="web.2",status="critical"}`:                         1,
+		`parseflow_endpoint_requests_total{route="/checkout",class="5xx"}`:              1,
+		`parseflow_endpoint_response_time_seconds_bucket{route="/checkout",le="10"}`:    2,
+		`parseflow_endpoint_response_time_seconds_bucket{route="/users/:id",le="+Inf"}`: 2,
+		`parseflow_window_requests_per_second{window="1m"}`:                             4.0 / 60,
+		`parseflow_channel_capacity{channel="metrics"}`:                                 100,
+	} {
+		if got, ok := samples[series]; !ok || got != want {
+			t.Errorf("%s: expected %g, got %g (present %v)", series, want, got, ok)
+		}
+	}
+	if got := samples["parseflow_response_time_seconds_sum"]; got < 6.35 || got > 6.37 {
+		t.Errorf("Expected a latency sum of 6.36s, got %g", got)
+	}
+}
+
+func TestApp_PrometheusHandler_OpenMetrics(t *testing.T) {
+	app := createPrometheusTestApp()
+	app.MetricChan <- createTestParsedLog(200, "GET", "/", "", "web.1", 10*time.Millisecond, false)
+	time.Sleep(100 * time.Millisecond)
+
+	for _, tt := range []struct{ accept, query string }{
+		{"application/openmetrics-text; version=1.0.0,text/plain;version=0.0.4;q=0.5", ""},
+		{"", "?format=openmetrics"},
+	} {
+		w := scrapePrometheus(app, tt.accept, tt.query)
+		if w.Header().Get("Content-Type") != promOpenMetricsContentType {
+			t.Fatalf("Expected OpenMetrics, got %s", w.Header().Get("Content-Type"))
+		}
+		body := w.Body.String()
+		if !strings.Contains(body, "# TYPE parseflow_requests counter\nparseflow_requests_total 1\n") {
+			t.Error("Expected counter families without _total in OpenMetrics")
+		}
+		checkExposition(t, body, true)
+	}
+
+	if w := scrapePrometheus(app, "", "?format=json"); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status 400, got %d", w.Code)
+	}
+}
+
+func TestApp_PrometheusHandler_BearerToken(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.RateLimiter = NewRateLimiterMap(100, 10)
+	t.Setenv("METRICS-API-KEY", "secret")
+
+	for token, want := range map[string]int{"secret": http.StatusOK, "nope": http.StatusUnauthorized} {
+		r := httptest.NewRequest(http.MethodGet, "/metrics/prometheus", nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		app.PrometheusHandler(w, r)
+		if w.Code != want {
+			t.Errorf("Bearer %s: expected status %d, got %d", token, want, w.Code)
+		}
+	}
+
+	// only the scrape endpoint takes bearer tokens
+	r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	r.Header.Set("Authorization", "Bearer secret")
+	w := httptest.NewRecorder()
+	app.MetricsHandler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected bearer tokens refused on /metrics, got %d", w.Code)
+	}
+	r = httptest.NewRequest(http.MethodGet, "/metrics/prometheus", nil)
+	r.Header.Set("Authorization", "secret")
+	w = httptest.NewRecorder()
+	app.PrometheusHandler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("Expected a bare Authorization header refused, got %d", w.Code)
+	}
+}
+
+func TestPromWriter_Escaping(t *testing.T) {
+	var w promWriter
+	w.sample("parseflow_endpoint_apdex", 0.5, "route", "/a\"b\\c\nd")
+	if got := w.b.String(); got != `parseflow_endpoint_apdex{route="/a\"b\\c\nd"} 0.5`+"\n" {
+		t.Errorf("Unexpected sample %q", got)
+	}
+}
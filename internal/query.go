@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// every stored line (router and app) sharing a request id, oldest first
+func (a *App) queryRequestLogs(db *sql.DB, requestId string) ([]*ParsedLog, error) {
+	rows, err := db.Query(
+		"SELECT log_data FROM raw_logs WHERE request_id = ? ORDER BY timestamp, id",
+		requestId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	logs := []*ParsedLog{}
+	for rows.Next() {
+		var logData string
+		if err := rows.Scan(&logData); err != nil {
+			return nil, err
+		}
+		var l ParsedLog
+		if err := json.Unmarshal([]byte(logData), &l); err != nil {
+			return nil, err
+		}
+		logs = append(logs, &l)
+	}
+	return logs, rows.Err()
+}
+
+// GET /requests/{id}
+func (a *App) RequestLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	requestId := r.PathValue("id")
+	if requestId == "" || a.LogDb == nil {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	logs, err := a.queryRequestLogs(a.LogDb, requestId)
+	if err != nil {
+		log.Printf("Failed to query request %s: %v", requestId, err)
+		http.Error(w, "Failed to query logs", http.StatusInternalServerError)
+		return
+	}
+	if len(logs) == 0 {
+		writeJSONError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"request_id": requestId,
+		"logs":       logs,
+	}); err != nil {
+		http.Error(w, "Failed to encode logs", http.StatusInternalServerError)
+		return
+	}
+}
@@ -0,0 +1,112 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func createQueryTestApp(t *testing.T) *App {
+	t.Helper()
+
+	db := createWriterTestDB(t)
+	db.SetMaxOpenConns(1) // every :memory: connection is its own database
+	t.Cleanup(func() { db.Close() })
+
+	app := createWriterTestApp(t)
+	if err := app.initTables(db); err != nil {
+		t.Fatalf("Failed to init tables: %v", err)
+	}
+	app.LogDb = db
+	app.RateLimiter = NewRateLimiterMap(100, 10)
+	return app
+}
+
+func TestQueryRequestLogs(t *testing.T) {
+	app := createQueryTestApp(t)
+	now := time.Now()
+
+	router := createWriterTestParsedLog(t)
+	router.Source = SourceRouter
+	router.ReqId = "abc-123"
+	router.Time = now.Add(time.Second)
+
+	appLine := &ParsedLog{Time: now, Source: SourceApp, ReqId: "abc-123", Message: "handling request"}
+	other := &ParsedLog{Time: now, Source: SourceApp, ReqId: "other"}
+	noId := &ParsedLog{Time: now, Source: SourceApp}
+
+	if err := app.writeBatchToDb(app.LogDb, []*ParsedLog{router, appLine, other, noId}); err != nil {
+		t.Fatalf("Failed to write batch: %v", err)
+	}
+
+	logs, err := app.queryRequestLogs(app.LogDb, "abc-123")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(logs) != 2 {
+		t.Fatalf("Expected 2 logs, got %d", len(logs))
+	}
+	if logs[0].Source != SourceApp || logs[1].Source != SourceRouter {
+		t.Errorf("Expected logs ordered by time, got %s then %s", logs[0].Source, logs[1].Source)
+	}
+
+	var nullCount int
+	if err := app.LogDb.QueryRow("SELECT COUNT(*) FROM raw_logs WHERE request_id IS NULL").Scan(&nullCount); err != nil {
+		t.Fatalf("Failed to count: %v", err)
+	}
+	if nullCount != 1 {
+		t.Errorf("Expected empty request id stored as NULL, got %d NULL rows", nullCount)
+	}
+}
+
+func TestApp_RequestLogsHandler(t *testing.T) {
+	app := createQueryTestApp(t)
+	l := createWriterTestParsedLog(t)
+	l.ReqId = "req-42"
+	if err := app.writeLogToDb(app.LogDb, l); err != nil {
+		t.Fatalf("Failed to write log: %v", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /requests/{id}", app.RequestLogsHandler)
+
+	t.Run("known request id", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/requests/req-42", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		var body struct {
+			RequestId string       `json:"request_id"`
+			Logs      []*ParsedLog `json:"logs"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if body.RequestId != "req-42" || len(body.Logs) != 1 {
+			t.Errorf("Expected 1 log for req-42, got %+v", body)
+		}
+	})
+
+	t.Run("unknown request id", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/requests/nope", nil))
+
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("unauthorized", func(t *testing.T) {
+		t.Setenv("METRICS-API-KEY", "secret")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/requests/req-42", nil))
+
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", w.Code)
+		}
+	})
+}
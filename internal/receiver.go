@@ -1,6 +1,9 @@
 package internal
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net/http"
@@ -8,6 +11,8 @@ import (
 	"strings"
 )
 
+var errNotFramed = errors.New("body is not octet-counted")
+
 // Handles a post request from the logplexer and verifies then writes the log to Raw Log Chan
 func (a *App) LogReceiver(w http.ResponseWriter, r *http.Request) {
 	//verify first - specific to heroku -- (Parser will be compliant to RFC5424 on https drains)
@@ -38,11 +43,74 @@ func (a *App) LogReceiver(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	msgs, err := SplitFrame(body, ml)
+	if err != nil {
+		log.Printf("Invalid frame %s: %v", requestId, err)
+		return
+	}
+
 	if a.Dc.Add(requestId) {
-		a.RawLogChan <- body
+		for _, m := range msgs {
+			a.RawLogChan <- m
+		}
 
 	} else {
 		log.Println("Already Processed")
 	}
 
 }
+
+// SplitFrame breaks a logplex body into its octet-counted messages ("<len> <msg>...")
+// and checks the result against the Logplex-Msg-Count header.
+// A single-message body without a length prefix is passed through as is.
+func SplitFrame(body []byte, count int) ([][]byte, error) {
+	msgs, err := splitOctetCounted(body)
+	if errors.Is(err, errNotFramed) && count == 1 {
+		return [][]byte{body}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(msgs) != count {
+		return nil, fmt.Errorf("expected %d messages, got %d", count, len(msgs))
+	}
+	return msgs, nil
+}
+
+func splitOctetCounted(body []byte) ([][]byte, error) {
+	var msgs [][]byte
+	rest := body
+	for {
+		// frames may be separated by newlines
+		rest = bytes.TrimLeft(rest, "\r\n")
+		if len(rest) == 0 {
+			break
+		}
+
+		sp := bytes.IndexByte(rest, ' ')
+		if sp < 1 {
+			if len(msgs) == 0 {
+				return nil, errNotFramed
+			}
+			return nil, fmt.Errorf("missing length prefix after message %d", len(msgs))
+		}
+		n, err := strconv.Atoi(string(rest[:sp]))
+		if err != nil || n < 0 {
+			if len(msgs) == 0 {
+				return nil, errNotFramed
+			}
+			return nil, fmt.Errorf("invalid length prefix %q after message %d", rest[:sp], len(msgs))
+		}
+
+		rest = rest[sp+1:]
+		if n > len(rest) {
+			return nil, fmt.Errorf("message %d declares %d bytes, only %d left", len(msgs)+1, n, len(rest))
+		}
+		msgs = append(msgs, bytes.TrimRight(rest[:n], "\r\n"))
+		rest = rest[n:]
+	}
+	if len(msgs) == 0 {
+		return nil, errNotFramed
+	}
+	return msgs, nil
+}
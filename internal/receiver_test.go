@@ -400,6 +400,103 @@ func TestApp_LogReceiver(t *testing.T) {
 	})
 }
 
+func TestSplitFrame(t *testing.T) {
+	msg1 := "<190>1 2025-07-19T10:30:45.123456+00:00 host heroku router - at=info method=GET path=\"/\" status=200"
+	msg2 := "<190>1 2025-07-19T10:30:46.123456+00:00 host app web.1 - Hello world"
+	frame := func(m string) string { return fmt.Sprintf("%d %s", len(m), m) }
+
+	tests := []struct {
+		name    string
+		body    string
+		count   int
+		want    []string
+		wantErr bool
+	}{
+		{"single framed message", frame(msg1), 1, []string{msg1}, false},
+		{"two framed messages", frame(msg1) + frame(msg2), 2, []string{msg1, msg2}, false},
+		{"newline separated frames", frame(msg1+"\n") + "\n" + frame(msg2), 2, []string{msg1, msg2}, false},
+		{"unframed single message", "2025-07-19T10:30:45Z at=info", 1, []string{"2025-07-19T10:30:45Z at=info"}, false},
+		{"empty body", "", 1, []string{""}, false},
+		{"count mismatch", frame(msg1) + frame(msg2), 3, nil, true},
+		{"unframed with count above one", "hello world", 2, nil, true},
+		{"truncated message", "500 " + msg1, 1, nil, true},
+		{"garbage after first frame", frame(msg1) + "xx yy", 2, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SplitFrame([]byte(tt.body), tt.count)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Expected %d messages, got %d", len(tt.want), len(got))
+			}
+			for i := range got {
+				if string(got[i]) != tt.want[i] {
+					t.Errorf("Message %d: expected %q, got %q", i, tt.want[i], got[i])
+				}
+			}
+		})
+	}
+}
+
+func TestApp_LogReceiver_MultiMessageFrame(t *testing.T) {
+	app := &App{
+		Dc:         NewDedupeCache(10),
+		RawLogChan: make(chan []byte, 10),
+	}
+
+	msgs := []string{
+		"<190>1 2025-07-19T10:30:45.123456+00:00 host heroku router - at=info method=GET status=200",
+		"<190>1 2025-07-19T10:30:46.123456+00:00 host heroku router - at=info method=POST status=201",
+		"<190>1 2025-07-19T10:30:47.123456+00:00 host app web.1 - done",
+	}
+	var body strings.Builder
+	for _, m := range msgs {
+		fmt.Fprintf(&body, "%d %s", len(m), m)
+	}
+
+	t.Run("emits one entry per message", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(body.String()))
+		req.Header.Set("Content-Type", "application/logplex-1")
+		req.Header.Set("User-Agent", "Logplex/v73")
+		req.Header.Set("Logplex-Msg-Count", "3")
+		req.Header.Set("Logplex-Frame-Id", "multi-frame")
+
+		app.LogReceiver(httptest.NewRecorder(), req)
+
+		if len(app.RawLogChan) != len(msgs) {
+			t.Fatalf("Expected %d entries on RawLogChan, got %d", len(msgs), len(app.RawLogChan))
+		}
+		for i, m := range msgs {
+			if got := string(<-app.RawLogChan); got != m {
+				t.Errorf("Entry %d: expected %q, got %q", i, m, got)
+			}
+		}
+	})
+
+	t.Run("rejects count mismatch", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodPost, "/logs", strings.NewReader(body.String()))
+		req.Header.Set("Content-Type", "application/logplex-1")
+		req.Header.Set("User-Agent", "Logplex/v73")
+		req.Header.Set("Logplex-Msg-Count", "2")
+		req.Header.Set("Logplex-Frame-Id", "mismatch-frame")
+
+		app.LogReceiver(httptest.NewRecorder(), req)
+
+		if len(app.RawLogChan) != 0 {
+			t.Errorf("Expected no entries for mismatched frame, got %d", len(app.RawLogChan))
+		}
+	})
+}
+
 // Helper type for simulating body read errors
 type errorReader struct {
 	err error
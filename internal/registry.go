@@ -0,0 +1,175 @@
+package internal
+
+import "strings"
+
+// Log sources, stored on ParsedLog.Source
+const (
+	SourceRouter    = "router"
+	SourceRuntime   = "runtime"
+	SourceLifecycle = "lifecycle" // dyno state changes, restarts, exits
+	SourceApp       = "app"
+	SourcePostgres  = "heroku-postgres"
+	SourceRedis     = "heroku-redis"
+	SourceRelease   = "release"
+	SourceRule      = "rule" // user defined extraction rules, see rules.go
+	SourceUnparsed  = "unparsed"
+)
+
+// A Parser turns one drain line into a ParsedLog for the sources it matches.
+// kv holds the key=value pairs found in msg.
+type Parser interface {
+	Source() string
+	Match(h *SyslogHeader) bool
+	Parse(h *SyslogHeader, msg string, kv map[string]string) *ParsedLog
+}
+
+// ParserRegistry dispatches a line to the first parser matching its header
+type ParserRegistry struct {
+	parsers []Parser
+}
+
+func NewParserRegistry(parsers ...Parser) *ParserRegistry {
+	return &ParserRegistry{parsers: parsers}
+}
+
+// Order matters, the add-on and api procids live under the "app" app-name
+func DefaultParserRegistry() *ParserRegistry {
+	return NewParserRegistry(
+		routerParser{},
+		runtimeParser{},
+		lifecycleParser{},
+		addonParser{source: SourcePostgres, kind: "postgres", procId: "heroku-postgres"},
+		addonParser{source: SourceRedis, kind: "redis", procId: "heroku-redis"},
+		releaseParser{},
+		appParser{},
+	)
+}
+
+var defaultParsers = DefaultParserRegistry()
+
+// Register adds p ahead of the existing parsers so it can override them
+func (r *ParserRegistry) Register(p Parser) {
+	r.parsers = append([]Parser{p}, r.parsers...)
+}
+
+func (r *ParserRegistry) Lookup(h *SyslogHeader) Parser {
+	for _, p := range r.parsers {
+		if p.Match(h) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Parse never returns nil, unmatched lines come back with SourceUnparsed.
+// A matching parser that returns nil passes the line on to the next one.
+func (r *ParserRegistry) Parse(h *SyslogHeader, msg string, kv map[string]string) *ParsedLog {
+	var l *ParsedLog
+	source := SourceUnparsed
+	for _, p := range r.parsers {
+		if !p.Match(h) {
+			continue
+		}
+		if l = p.Parse(h, msg, kv); l != nil {
+			source = p.Source()
+			break
+		}
+	}
+	if l == nil {
+		l = newSourceLog(h, msg)
+		source = SourceUnparsed
+	}
+	l.Source = source
+	applySyslogHeader(l, h)
+	return l
+}
+
+func newSourceLog(h *SyslogHeader, msg string) *ParsedLog {
+	return &ParsedLog{
+		Time:    parseLogTime(h.Timestamp),
+		Message: msg,
+	}
+}
+
+// heroku[router]: the request lines the metrics are built from
+type routerParser struct{}
+
+func (routerParser) Source() string { return SourceRouter }
+
+func (routerParser) Match(h *SyslogHeader) bool {
+	return h.AppName == "heroku" && h.ProcId == "router"
+}
+
+func (routerParser) Parse(h *SyslogHeader, msg string, kv map[string]string) *ParsedLog {
+	l := BuildParsedLog(kv)
+	l.Message = msg
+	return l
+}
+
+// app[api]: releases, deploys and config changes
+type releaseParser struct{}
+
+func (releaseParser) Source() string { return SourceRelease }
+
+func (releaseParser) Match(h *SyslogHeader) bool {
+	return h.AppName == "app" && h.ProcId == "api"
+}
+
+func (releaseParser) Parse(h *SyslogHeader, msg string, kv map[string]string) *ParsedLog {
+	l := newSourceLog(h, msg)
+	l.Deploy = parseDeployEvent(msg)
+	return l
+}
+
+// app[web.N], app[worker.N], ... our own output
+type appParser struct{}
+
+func (appParser) Source() string { return SourceApp }
+
+func (appParser) Match(h *SyslogHeader) bool {
+	return h.AppName == "app" && isDynoName(h.ProcId)
+}
+
+// JSON lines are flattened into Attrs, anything else keeps its key=value pairs
+func (appParser) Parse(h *SyslogHeader, msg string, kv map[string]string) *ParsedLog {
+	l := newSourceLog(h, msg)
+	l.SourceDyno = h.ProcId
+	if attrs := parseJSONPayload(msg); attrs != nil {
+		l.Attrs = attrs
+		l.Level, _ = attrs["level"].(string)
+		l.ReqId, _ = attrs["request_id"].(string)
+		return l
+	}
+	l.ReqId = kv["request_id"]
+	l.Fields = messageFields(kv)
+	return l
+}
+
+// kv without the timestamp ParseLog copies in from the header, nil if empty
+func messageFields(kv map[string]string) map[string]string {
+	var fields map[string]string
+	for k, v := range kv {
+		if k == "timestamp" {
+			continue
+		}
+		if fields == nil {
+			fields = make(map[string]string, len(kv))
+		}
+		fields[k] = v
+	}
+	return fields
+}
+
+// "web.1", "worker.12", "run.4821"
+func isDynoName(s string) bool {
+	dot := strings.LastIndexByte(s, '.')
+	if dot < 1 || dot == len(s)-1 {
+		return false
+	}
+	for _, c := range s[dot+1:] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
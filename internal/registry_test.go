@@ -0,0 +1,133 @@
+package internal
+
+import "testing"
+
+func TestParserRegistry_Parse(t *testing.T) {
+	registry := DefaultParserRegistry()
+
+	tests := []struct {
+		name       string
+		line       string
+		wantSource string
+	}{
+		{"router", `<158>1 2025-07-19T10:30:45Z host heroku router - at=info method=GET status=200`, SourceRouter},
+		{"app web dyno", `<190>1 2025-07-19T10:30:45Z host app web.1 - Hello world`, SourceApp},
+		{"app worker dyno", `<190>1 2025-07-19T10:30:45Z host app worker.3 - job done`, SourceApp},
+		{"postgres", `<134>1 2025-07-19T10:30:45Z host app heroku-postgres - source=DATABASE sample#db_size=8MB`, SourcePostgres},
+		{"redis", `<134>1 2025-07-19T10:30:45Z host app heroku-redis - source=REDIS sample#active-connections=3`, SourceRedis},
+		{"release", `<134>1 2025-07-19T10:30:45Z host app api - Release v123 created by user@example.com`, SourceRelease},
+		{"state change", `<134>1 2025-07-19T10:30:45Z host heroku web.1 - State changed from up to down`, SourceLifecycle},
+		{"platform line", `<134>1 2025-07-19T10:30:45Z host heroku web.1 - Process running mem=1028M(200.9%)`, SourceUnparsed},
+		{"unknown app", `<134>1 2025-07-19T10:30:45Z host other thing - something`, SourceUnparsed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, msg, err := ParseSyslog(tt.line)
+			if err != nil {
+				t.Fatalf("Unexpected syslog error: %v", err)
+			}
+			l := registry.Parse(h, msg, map[string]string{"timestamp": h.Timestamp})
+			if l == nil {
+				t.Fatal("Parse returned nil")
+			}
+			if l.Source != tt.wantSource {
+				t.Errorf("Expected source %q, got %q", tt.wantSource, l.Source)
+			}
+			if l.Time.IsZero() {
+				t.Error("Expected time to be set from header")
+			}
+			if l.ProcId != h.ProcId {
+				t.Errorf("Expected header to be applied, got procid %q", l.ProcId)
+			}
+		})
+	}
+}
+
+func TestParserRegistry_Fields(t *testing.T) {
+	app := &App{ParsedLogChan: make(chan *ParsedLog, 1)}
+
+	app.ParseLog([]byte(`<134>1 2025-07-19T10:30:45Z host app heroku-postgres - source=DATABASE sample#db_size=8MB`))
+
+	l := <-app.ParsedLogChan
+	if l.Source != SourcePostgres {
+		t.Fatalf("Expected postgres source, got %q", l.Source)
+	}
+	if l.Fields["sample#db_size"] != "8MB" || l.Fields["source"] != "DATABASE" {
+		t.Errorf("Expected sample fields, got %v", l.Fields)
+	}
+	if _, ok := l.Fields["timestamp"]; ok {
+		t.Error("Expected timestamp to be left out of fields")
+	}
+}
+
+func TestParserRegistry_AppRequestId(t *testing.T) {
+	app := &App{ParsedLogChan: make(chan *ParsedLog, 1)}
+
+	app.ParseLog([]byte(`<190>1 2025-07-19T10:30:45Z host app web.1 - request_id=abc-123 Completed 200 OK`))
+
+	l := <-app.ParsedLogChan
+	if l.ReqId != "abc-123" {
+		t.Errorf("Expected request id from app line, got %q", l.ReqId)
+	}
+}
+
+type stubParser struct{}
+
+func (stubParser) Source() string             { return "stub" }
+func (stubParser) Match(h *SyslogHeader) bool { return h.AppName == "heroku" }
+func (stubParser) Parse(h *SyslogHeader, msg string, kv map[string]string) *ParsedLog {
+	return &ParsedLog{Message: "stub"}
+}
+
+func TestParserRegistry_Register(t *testing.T) {
+	registry := DefaultParserRegistry()
+	registry.Register(stubParser{})
+
+	l := registry.Parse(&SyslogHeader{AppName: "heroku", ProcId: "router"}, "at=info", map[string]string{})
+	if l.Source != "stub" || l.Message != "stub" {
+		t.Errorf("Expected registered parser to take precedence, got source %q", l.Source)
+	}
+}
+
+func TestIsDynoName(t *testing.T) {
+	tests := map[string]bool{
+		"web.1":     true,
+		"worker.12": true,
+		"run.4821":  true,
+		"api":       false,
+		"web.":      false,
+		".1":        false,
+		"web.x":     false,
+		"heroku-pg": false,
+	}
+	for name, want := range tests {
+		if got := isDynoName(name); got != want {
+			t.Errorf("isDynoName(%q) = %v, expected %v", name, got, want)
+		}
+	}
+}
+
+func TestApp_FanOut_SkipsNonRouter(t *testing.T) {
+	app := &App{
+		ParsedLogChan:  make(chan *ParsedLog, 3),
+		MetricChan:     make(chan *ParsedLog, 3),
+		DbRawWriteChan: make(chan *ParsedLog, 3),
+	}
+	app.ParsedLogChan <- &ParsedLog{Source: SourceRouter}
+	app.ParsedLogChan <- &ParsedLog{Source: SourceApp}
+	app.ParsedLogChan <- &ParsedLog{Source: SourceUnparsed}
+	close(app.ParsedLogChan)
+
+	app.FanOut()
+
+	if len(app.MetricChan) != 1 {
+		t.Errorf("Expected only the router line on MetricChan, got %d", len(app.MetricChan))
+	}
+	if len(app.DbRawWriteChan) != 3 {
+		t.Errorf("Expected every line on DbRawWriteChan, got %d", len(app.DbRawWriteChan))
+	}
+	if app.UnparsedCount.Load() != 1 {
+		t.Errorf("Expected 1 unparsed line, got %d", app.UnparsedCount.Load())
+	}
+}
@@ -0,0 +1,146 @@
+package internal
+
+import "strings"
+
+// Route normalization, groups request paths into routes for the endpoint metrics.
+// "/users/123?page=2" and "/users/456" both become "/users/:id".
+
+const minHexSegment = 8 // shorter hex looking segments are more likely words
+
+// RouteNormalizer maps a request path to its route. Templates are tried in order
+// before the generic placeholders, a template segment ":name" matches any one
+// segment and a trailing "*" matches the rest of the path.
+type RouteNormalizer struct {
+	templates [][]string
+	raw       []string
+}
+
+func NewRouteNormalizer(templates []string) *RouteNormalizer {
+	n := &RouteNormalizer{}
+	for _, t := range templates {
+		t = strings.TrimSpace(t)
+		if t == "" {
+			continue
+		}
+		n.templates = append(n.templates, splitPath(t))
+		n.raw = append(n.raw, t)
+	}
+	return n
+}
+
+var defaultRoutes = NewRouteNormalizer(nil)
+
+func (a *App) routes() *RouteNormalizer {
+	if a.Routes != nil {
+		return a.Routes
+	}
+	return defaultRoutes
+}
+
+func (n *RouteNormalizer) Normalize(path string) string {
+	if i := strings.IndexAny(path, "?#"); i >= 0 {
+		path = path[:i]
+	}
+	segments := splitPath(path)
+	for i, t := range n.templates {
+		if matchTemplate(t, segments) {
+			return n.raw[i]
+		}
+	}
+	if len(segments) == 0 {
+		return "/"
+	}
+	for i, s := range segments {
+		segments[i] = normalizeSegment(s)
+	}
+	return "/" + strings.Join(segments, "/")
+}
+
+// empty segments from doubled or trailing slashes are dropped
+func splitPath(path string) []string {
+	var segments []string
+	for _, s := range strings.Split(path, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+func matchTemplate(template, segments []string) bool {
+	for i, t := range template {
+		if t == "*" && i == len(template)-1 {
+			return true
+		}
+		if i >= len(segments) {
+			return false
+		}
+		if !strings.HasPrefix(t, ":") && t != segments[i] {
+			return false
+		}
+	}
+	return len(template) == len(segments)
+}
+
+func normalizeSegment(s string) string {
+	switch {
+	case isDigits(s):
+		return ":id"
+	case isUUID(s):
+		return ":uuid"
+	case isHexId(s):
+		return ":hex"
+	}
+	return s
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// 8-4-4-4-12
+func isUUID(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		switch i {
+		case 8, 13, 18, 23:
+			if s[i] != '-' {
+				return false
+			}
+		default:
+			if !isHexDigit(s[i]) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// object ids, commit shas, tokens; needs a digit so "deadbeef"-like words survive
+func isHexId(s string) bool {
+	if len(s) < minHexSegment {
+		return false
+	}
+	digit := false
+	for i := 0; i < len(s); i++ {
+		if !isHexDigit(s[i]) {
+			return false
+		}
+		digit = digit || (s[i] >= '0' && s[i] <= '9')
+	}
+	return digit
+}
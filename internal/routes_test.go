@@ -0,0 +1,71 @@
+package internal
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRouteNormalizer_Normalize(t *testing.T) {
+	n := NewRouteNormalizer([]string{"/api/v1/orgs/:org/members", "/assets/*", " ", "/health"})
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/", "/"},
+		{"", "/"},
+		{"/?utm=1", "/"},
+		{"/login", "/login"},
+		{"/users/123", "/users/:id"},
+		{"/users/456?page=2#top", "/users/:id"},
+		{"/users/123/", "/users/:id"},
+		{"//users//123", "/users/:id"},
+		{"/orders/6f1c2b7e-9d3a-4c1e-8b2f-0a1b2c3d4e5f/items", "/orders/:uuid/items"},
+		{"/commits/1a2b3c4d5e6f7a8b9c0d", "/commits/:hex"},
+		{"/objects/507f1f77bcf86cd799439011", "/objects/:hex"},
+		{"/tags/deadbeef", "/tags/deadbeef"},
+		{"/tags/abc123", "/tags/abc123"},
+		{"/v2/users", "/v2/users"},
+		{"/api/v1/orgs/acme/members", "/api/v1/orgs/:org/members"},
+		{"/api/v1/orgs/acme/members/7", "/api/v1/orgs/acme/members/:id"},
+		{"/assets/css/app.css", "/assets/*"},
+		{"/assets", "/assets/*"},
+		{"/health?probe=1", "/health"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := n.Normalize(tt.path); got != tt.expected {
+				t.Errorf("Normalize(%q) = %q, expected %q", tt.path, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestApp_TopEndpointsUseRoutes(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.Routes = NewRouteNormalizer([]string{"/search"})
+	app.StartMetricsAggregator()
+	go app.FanOut()
+
+	for _, path := range []string{"/users/1", "/users/2?x=1", "/users/3", "/search?q=a", "/search?q=b"} {
+		l := createTestParsedLog(200, "GET", path, "", "web.1", 10*time.Millisecond, false)
+		l.Source = SourceRouter
+		app.ParsedLogChan <- l
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	metrics := app.GetMetricsSnapshot()
+	if len(metrics.TopEndpoints) != 2 {
+		t.Errorf("Expected 2 routes, got %v", metrics.TopEndpoints)
+	}
+	if metrics.TopEndpoints["/users/:id"] != 3 || metrics.TopEndpoints["/search"] != 2 {
+		t.Errorf("Unexpected routes %v", metrics.TopEndpoints)
+	}
+
+	stored := <-app.DbRawWriteChan
+	if stored.Route != "/users/:id" || stored.Path != "/users/1" {
+		t.Errorf("Expected stored line to keep path and carry route, got %q %q", stored.Path, stored.Route)
+	}
+}
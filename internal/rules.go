@@ -0,0 +1,360 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// User defined extraction rules, loaded from a JSON file:
+//
+//	{
+//	  "patterns": {"JOBID": "[0-9a-f]{24}"},
+//	  "rules": [
+//	    {"name": "sidekiq", "proc_id": "worker.*", "grok": "%{WORD:job} JID-%{JOBID:jid} %{WORD:state}: %{NUMBER:secs} sec"},
+//	    {"name": "nginx", "app_name": "app", "proc_id": "web.*", "regex": "(?P<client>\\S+) \"(?P<method>\\w+) (?P<path>\\S+)"}
+//	  ]
+//	}
+//
+// The first rule bound to the line's app-name/procid whose pattern matches wins,
+// its named captures end up in ParsedLog.Fields. Rules run ahead of the built-in
+// parsers, so each rule must name an app_name or proc_id and none may bind to the
+// heroku router lines the metrics are built from.
+
+const maxGrokDepth = 10 // nested %{PATTERN} references
+
+var grokPatterns = map[string]string{
+	"WORD":              `\b\w+\b`,
+	"NOTSPACE":          `\S+`,
+	"SPACE":             `\s*`,
+	"DATA":              `.*?`,
+	"GREEDYDATA":        `.*`,
+	"INT":               `[+-]?[0-9]+`,
+	"NUMBER":            `[+-]?(?:[0-9]+(?:\.[0-9]+)?|\.[0-9]+)`,
+	"BASE16NUM":         `(?:0[xX])?[0-9A-Fa-f]+`,
+	"UUID":              `[0-9A-Fa-f]{8}-(?:[0-9A-Fa-f]{4}-){3}[0-9A-Fa-f]{12}`,
+	"IPV4":              `(?:[0-9]{1,3}\.){3}[0-9]{1,3}`,
+	"IP":                `(?:(?:[0-9]{1,3}\.){3}[0-9]{1,3}|[0-9A-Fa-f]*:[0-9A-Fa-f:.]+)`,
+	"QUOTEDSTRING":      `"(?:[^"\\]|\\.)*"`,
+	"URIPATH":           `/[^\s?#]*`,
+	"URIPATHPARAM":      `/[^\s#]*`,
+	"HTTPMETHOD":        `(?:GET|HEAD|POST|PUT|PATCH|DELETE|OPTIONS|CONNECT|TRACE)`,
+	"LOGLEVEL":          `(?i:trace|debug|info|notice|warn(?:ing)?|err(?:or)?|crit(?:ical)?|fatal|alert|emerg(?:ency)?)`,
+	"TIMESTAMP_ISO8601": `[0-9]{4}-[0-9]{2}-[0-9]{2}[T ][0-9]{2}:[0-9]{2}:[0-9]{2}(?:\.[0-9]+)?(?:Z|[+-][0-9]{2}:?[0-9]{2})?`,
+}
+
+// %{PATTERN} or %{PATTERN:field}
+var grokRef = regexp.MustCompile(`%\{(\w+)(?::(\w+))?\}`)
+
+type RuleConfig struct {
+	Name    string `json:"name"`
+	AppName string `json:"app_name,omitempty"` // exact, empty matches any
+	ProcId  string `json:"proc_id,omitempty"`  // glob ("web.*"), empty matches any, one of the two is required
+	Regex   string `json:"regex,omitempty"`
+	Grok    string `json:"grok,omitempty"`
+}
+
+type RulesFile struct {
+	Patterns map[string]string `json:"patterns,omitempty"`
+	Rules    []RuleConfig      `json:"rules"`
+}
+
+type Rule struct {
+	RuleConfig
+	re *regexp.Regexp
+}
+
+// RuleSet is an immutable compiled rules file
+type RuleSet struct {
+	Rules []*Rule
+}
+
+// CompileRules validates a rules file, errors name the offending rule
+func CompileRules(f *RulesFile) (*RuleSet, error) {
+	patterns := make(map[string]string, len(grokPatterns)+len(f.Patterns))
+	for k, v := range grokPatterns {
+		patterns[k] = v
+	}
+	for k, v := range f.Patterns {
+		patterns[k] = v
+	}
+
+	set := &RuleSet{}
+	seen := make(map[string]struct{}, len(f.Rules))
+	for _, rc := range f.Rules {
+		if rc.Name == "" {
+			return nil, errors.New("rule without a name")
+		}
+		if _, dup := seen[rc.Name]; dup {
+			return nil, fmt.Errorf("rule %q: duplicate name", rc.Name)
+		}
+		seen[rc.Name] = struct{}{}
+
+		if (rc.Regex == "") == (rc.Grok == "") {
+			return nil, fmt.Errorf("rule %q: exactly one of regex or grok is required", rc.Name)
+		}
+		if rc.ProcId != "" {
+			if _, err := path.Match(rc.ProcId, ""); err != nil {
+				return nil, fmt.Errorf("rule %q: bad proc_id glob: %w", rc.Name, err)
+			}
+		}
+
+		expr := rc.Regex
+		if rc.Grok != "" {
+			var err error
+			expr, err = expandGrok(rc.Grok, patterns, 0)
+			if err != nil {
+				return nil, fmt.Errorf("rule %q: %w", rc.Name, err)
+			}
+		}
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rc.Name, err)
+		}
+		named := false
+		for _, n := range re.SubexpNames() {
+			named = named || n != ""
+		}
+		if !named {
+			return nil, fmt.Errorf("rule %q: pattern has no named fields", rc.Name)
+		}
+		rule := &Rule{RuleConfig: rc, re: re}
+		if rc.AppName == "" && rc.ProcId == "" {
+			return nil, fmt.Errorf("rule %q: app_name or proc_id is required", rc.Name)
+		}
+		if rule.bound(routerHeader) {
+			return nil, fmt.Errorf("rule %q: must not bind to heroku router lines", rc.Name)
+		}
+		set.Rules = append(set.Rules, rule)
+	}
+	return set, nil
+}
+
+func expandGrok(expr string, patterns map[string]string, depth int) (string, error) {
+	if depth > maxGrokDepth {
+		return "", errors.New("grok patterns nested too deep")
+	}
+	var err error
+	out := grokRef.ReplaceAllStringFunc(expr, func(ref string) string {
+		if err != nil {
+			return ""
+		}
+		m := grokRef.FindStringSubmatch(ref)
+		p, ok := patterns[m[1]]
+		if !ok {
+			err = fmt.Errorf("unknown grok pattern %s", m[1])
+			return ""
+		}
+		var inner string
+		inner, err = expandGrok(p, patterns, depth+1)
+		if m[2] != "" {
+			return "(?P<" + m[2] + ">" + inner + ")"
+		}
+		return "(?:" + inner + ")"
+	})
+	return out, err
+}
+
+// router lines always go to routerParser
+var routerHeader = &SyslogHeader{AppName: "heroku", ProcId: "router"}
+
+func (r *Rule) bound(h *SyslogHeader) bool {
+	if r.AppName != "" && r.AppName != h.AppName {
+		return false
+	}
+	if r.ProcId != "" {
+		ok, _ := path.Match(r.ProcId, h.ProcId)
+		return ok
+	}
+	return true
+}
+
+// named captures of msg, nil when the pattern does not match
+func (r *Rule) extract(msg string) map[string]string {
+	m := r.re.FindStringSubmatch(msg)
+	if m == nil {
+		return nil
+	}
+	fields := make(map[string]string)
+	for i, name := range r.re.SubexpNames() {
+		if name != "" && m[i] != "" {
+			fields[name] = m[i]
+		}
+	}
+	return fields
+}
+
+// Rules is the live rule set of a file, swapped in place on reload
+type Rules struct {
+	path    string
+	current atomic.Pointer[RuleSet]
+	modTime time.Time
+	size    int64
+}
+
+func LoadRules(path string) (*Rules, error) {
+	r := &Rules{path: path}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// rules of a single set, for the dry run
+func staticRules(set *RuleSet) *Rules {
+	r := &Rules{}
+	r.current.Store(set)
+	return r
+}
+
+func readRulesFile(p string) (*RulesFile, error) {
+	b, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var f RulesFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// Reload recompiles the file if it changed since the last load. On error the
+// previous rules stay active.
+func (r *Rules) Reload() (bool, error) {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false, err
+	}
+	if r.current.Load() != nil && info.ModTime().Equal(r.modTime) && info.Size() == r.size {
+		return false, nil
+	}
+	f, err := readRulesFile(r.path)
+	if err != nil {
+		return false, err
+	}
+	set, err := CompileRules(f)
+	if err != nil {
+		return false, err
+	}
+	r.current.Store(set)
+	r.modTime = info.ModTime()
+	r.size = info.Size()
+	return true, nil
+}
+
+// Watch polls the rules file every interval, run it in its own go routine
+func (r *Rules) Watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		reloaded, err := r.Reload()
+		if err != nil {
+			log.Printf("Failed to reload rules from %s, keeping previous rules: %v", r.path, err)
+			continue
+		}
+		if reloaded {
+			log.Printf("Reloaded %d rules from %s", len(r.current.Load().Rules), r.path)
+		}
+	}
+}
+
+func (r *Rules) Parser() Parser {
+	return rulesParser{rules: r}
+}
+
+type rulesParser struct {
+	rules *Rules
+}
+
+func (rulesParser) Source() string { return SourceRule }
+
+func (p rulesParser) Match(h *SyslogHeader) bool {
+	for _, rule := range p.rules.current.Load().Rules {
+		if rule.bound(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lines no rule pattern matches go on to the built-in parsers
+func (p rulesParser) Parse(h *SyslogHeader, msg string, kv map[string]string) *ParsedLog {
+	for _, rule := range p.rules.current.Load().Rules {
+		if !rule.bound(h) {
+			continue
+		}
+		fields := rule.extract(msg)
+		if fields == nil {
+			continue
+		}
+		l := newSourceLog(h, msg)
+		l.Rule = rule.Name
+		l.Fields = fields
+		l.ReqId = fields["request_id"]
+		if isDynoName(h.ProcId) {
+			l.SourceDyno = h.ProcId
+		}
+		return l
+	}
+	return nil
+}
+
+// POST /rules/dry-run {"line": "<drain line>", "rules": {optional rules file}}
+// Parses the line with the given rules, or with the live parsers when omitted.
+func (a *App) RulesDryRunHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+
+	var req struct {
+		Line  string     `json:"line"`
+		Rules *RulesFile `json:"rules"`
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil || json.Unmarshal(body, &req) != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Line == "" {
+		writeJSONError(w, http.StatusBadRequest, "line is required")
+		return
+	}
+
+	target := a
+	if req.Rules != nil {
+		set, err := CompileRules(req.Rules)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		registry := DefaultParserRegistry()
+		registry.Register(staticRules(set).Parser())
+		target = &App{Parsers: registry}
+	}
+
+	l, _ := target.parseLine(req.Line)
+	if l == nil {
+		writeJSONError(w, http.StatusBadRequest, "malformed line")
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"source": l.Source,
+		"rule":   l.Rule,
+		"fields": l.Fields,
+		"parsed": l,
+	}); err != nil {
+		http.Error(w, "Failed to encode result", http.StatusInternalServerError)
+		return
+	}
+}
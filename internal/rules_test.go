@@ -0,0 +1,217 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testRulesFile = `{
+	"patterns": {"JOBID": "[0-9a-f]{6}"},
+	"rules": [
+		{"name": "sidekiq", "app_name": "app", "proc_id": "worker.*", "grok": "%{WORD:job} JID-%{JOBID:jid} %{WORD:state}: %{NUMBER:secs} sec"},
+		{"name": "access", "app_name": "app", "proc_id": "web.*", "regex": "^(?P<client>\\S+) \"(?P<method>\\w+) (?P<path>\\S+)\""}
+	]
+}`
+
+func writeRulesFile(t *testing.T, dir, content string) string {
+	t.Helper()
+	p := filepath.Join(dir, "rules.json")
+	if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write rules file: %v", err)
+	}
+	return p
+}
+
+func TestCompileRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   RulesFile
+		wantErr string
+	}{
+		{"grok", RulesFile{Rules: []RuleConfig{{Name: "a", AppName: "app", Grok: "%{IP:client} %{INT:status}"}}}, ""},
+		{"nested custom pattern", RulesFile{
+			Patterns: map[string]string{"STATUS": "%{INT}", "PAIR": "%{WORD}=%{STATUS}"},
+			Rules:    []RuleConfig{{Name: "a", ProcId: "worker.*", Grok: "%{PAIR:pair}"}},
+		}, ""},
+		{"no name", RulesFile{Rules: []RuleConfig{{Regex: "(?P<a>x)"}}}, "without a name"},
+		{"duplicate", RulesFile{Rules: []RuleConfig{{Name: "a", AppName: "app", Regex: "(?P<a>x)"}, {Name: "a", AppName: "app", Regex: "(?P<a>y)"}}}, "duplicate"},
+		{"regex and grok", RulesFile{Rules: []RuleConfig{{Name: "a", Regex: "(?P<a>x)", Grok: "%{INT:n}"}}}, "exactly one"},
+		{"unknown pattern", RulesFile{Rules: []RuleConfig{{Name: "a", Grok: "%{NOPE:x}"}}}, "unknown grok pattern NOPE"},
+		{"cycle", RulesFile{Patterns: map[string]string{"LOOP": "%{LOOP}"}, Rules: []RuleConfig{{Name: "a", Grok: "%{LOOP:x}"}}}, "nested too deep"},
+		{"bad regex", RulesFile{Rules: []RuleConfig{{Name: "a", Regex: "(?P<a>x"}}}, "missing closing"},
+		{"no named fields", RulesFile{Rules: []RuleConfig{{Name: "a", Regex: "x+"}}}, "no named fields"},
+		{"bad glob", RulesFile{Rules: []RuleConfig{{Name: "a", ProcId: "web[", Regex: "(?P<a>x)"}}}, "bad proc_id glob"},
+		{"unbound", RulesFile{Rules: []RuleConfig{{Name: "a", Regex: "(?P<a>x)"}}}, "app_name or proc_id is required"},
+		{"router", RulesFile{Rules: []RuleConfig{{Name: "a", AppName: "heroku", ProcId: "router", Regex: `request_id=(?P<rid>\S+)`}}}, "heroku router"},
+		{"router glob", RulesFile{Rules: []RuleConfig{{Name: "a", ProcId: "*", Regex: "(?P<a>x)"}}}, "heroku router"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileRules(&tt.rules)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestRulesParser(t *testing.T) {
+	rules, err := LoadRules(writeRulesFile(t, t.TempDir(), testRulesFile))
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+	registry := DefaultParserRegistry()
+	registry.Register(rules.Parser())
+	app := &App{Parsers: registry}
+
+	tests := []struct {
+		name       string
+		line       string
+		wantSource string
+		wantRule   string
+		wantFields map[string]string
+	}{
+		{
+			name:       "grok rule",
+			line:       `<190>1 2025-07-19T10:30:45Z host app worker.2 - HardJob JID-a1b2c3 done: 1.25 sec`,
+			wantSource: SourceRule,
+			wantRule:   "sidekiq",
+			wantFields: map[string]string{"job": "HardJob", "jid": "a1b2c3", "state": "done", "secs": "1.25"},
+		},
+		{
+			name:       "regex rule",
+			line:       `<190>1 2025-07-19T10:30:45Z host app web.1 - 10.0.0.1 "GET /users/42"`,
+			wantSource: SourceRule,
+			wantRule:   "access",
+			wantFields: map[string]string{"client": "10.0.0.1", "method": "GET", "path": "/users/42"},
+		},
+		{
+			name:       "bound but no match falls through",
+			line:       `<190>1 2025-07-19T10:30:45Z host app web.1 - Hello world`,
+			wantSource: SourceApp,
+		},
+		{
+			name:       "not bound",
+			line:       `<158>1 2025-07-19T10:30:45Z host heroku router - at=info method=GET path="/" status=200`,
+			wantSource: SourceRouter,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := app.parseLine(tt.line)
+			if l == nil {
+				t.Fatal("parseLine returned nil")
+			}
+			if l.Source != tt.wantSource || l.Rule != tt.wantRule {
+				t.Fatalf("Expected %s/%q, got %s/%q", tt.wantSource, tt.wantRule, l.Source, l.Rule)
+			}
+			for k, v := range tt.wantFields {
+				if l.Fields[k] != v {
+					t.Errorf("Fields[%q] = %q, expected %q", k, l.Fields[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestRules_Reload(t *testing.T) {
+	dir := t.TempDir()
+	p := writeRulesFile(t, dir, testRulesFile)
+	rules, err := LoadRules(p)
+	if err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+
+	reloaded, err := rules.Reload()
+	if err != nil || reloaded {
+		t.Errorf("Expected unchanged file to be skipped, got %v %v", reloaded, err)
+	}
+
+	// broken edits keep the previous rules
+	writeRulesFile(t, dir, `{"rules": [{"name": "x"}]}`)
+	os.Chtimes(p, time.Now(), time.Now().Add(time.Second))
+	if _, err := rules.Reload(); err == nil {
+		t.Error("Expected error for invalid rules")
+	}
+	if n := len(rules.current.Load().Rules); n != 2 {
+		t.Errorf("Expected previous 2 rules kept, got %d", n)
+	}
+
+	writeRulesFile(t, dir, `{"rules": [{"name": "only", "app_name": "app", "regex": "(?P<word>\\w+)"}]}`)
+	os.Chtimes(p, time.Now(), time.Now().Add(2*time.Second))
+	reloaded, err = rules.Reload()
+	if err != nil || !reloaded {
+		t.Fatalf("Expected reload, got %v %v", reloaded, err)
+	}
+	if set := rules.current.Load(); len(set.Rules) != 1 || set.Rules[0].Name != "only" {
+		t.Errorf("Expected new rule set, got %+v", set.Rules)
+	}
+}
+
+func TestApp_RulesDryRunHandler(t *testing.T) {
+	app := &App{RateLimiter: NewRateLimiterMap(100, 10)}
+
+	post := func(body any) *httptest.ResponseRecorder {
+		b, _ := json.Marshal(body)
+		w := httptest.NewRecorder()
+		app.RulesDryRunHandler(w, httptest.NewRequest(http.MethodPost, "/rules/dry-run", bytes.NewReader(b)))
+		return w
+	}
+
+	t.Run("inline rules", func(t *testing.T) {
+		var rules RulesFile
+		json.Unmarshal([]byte(testRulesFile), &rules)
+		w := post(map[string]any{
+			"line":  `<190>1 2025-07-19T10:30:45Z host app worker.1 - Mailer JID-ffffff fail: 0.5 sec`,
+			"rules": rules,
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body)
+		}
+		var body struct {
+			Source string            `json:"source"`
+			Rule   string            `json:"rule"`
+			Fields map[string]string `json:"fields"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if body.Source != SourceRule || body.Rule != "sidekiq" || body.Fields["state"] != "fail" {
+			t.Errorf("Unexpected dry run result %+v", body)
+		}
+	})
+
+	t.Run("live parsers", func(t *testing.T) {
+		w := post(map[string]any{"line": `<158>1 2025-07-19T10:30:45Z host heroku router - at=info status=200`})
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"source":"router"`) {
+			t.Errorf("Expected router parse, got %d %s", w.Code, w.Body)
+		}
+	})
+
+	t.Run("bad requests", func(t *testing.T) {
+		for _, body := range []any{
+			map[string]any{},
+			map[string]any{"line": "garbage"},
+			map[string]any{"line": "x y", "rules": map[string]any{"rules": []any{map[string]any{"name": "bad"}}}},
+		} {
+			if w := post(body); w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400 for %v, got %d", body, w.Code)
+			}
+		}
+	})
+}
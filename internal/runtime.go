@@ -0,0 +1,194 @@
+package internal
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heroku[web.N]: lines from log-runtime-metrics and dyno R-code errors
+
+const memoryErrorWindow = 5 * time.Minute
+
+// RuntimeSample is one log-runtime-metrics line, memory in MB
+type RuntimeSample struct {
+	MemoryTotal float64 `json:"memory_total"`
+	MemoryRSS   float64 `json:"memory_rss"`
+	MemoryCache float64 `json:"memory_cache"`
+	MemorySwap  float64 `json:"memory_swap"`
+	MemoryQuota float64 `json:"memory_quota"`
+	LoadAvg1m   float64 `json:"load_avg_1m"`
+	LoadAvg5m   float64 `json:"load_avg_5m"`
+	LoadAvg15m  float64 `json:"load_avg_15m"`
+	HasMemory   bool    `json:"has_memory"`
+	HasLoad     bool    `json:"has_load"`
+}
+
+type runtimeParser struct{}
+
+func (runtimeParser) Source() string { return SourceRuntime }
+
+func (runtimeParser) Match(h *SyslogHeader) bool {
+	return h.AppName == "heroku" && isDynoName(h.ProcId)
+}
+
+// Anything that is neither a sample nor an R-code error is left to the other parsers
+func (runtimeParser) Parse(h *SyslogHeader, msg string, kv map[string]string) *ParsedLog {
+	l := newSourceLog(h, msg)
+	l.SourceDyno = h.ProcId
+	if code, desc, ok := parseDynoError(msg); ok {
+		l.ErrorCode = code
+		l.ErrorDesc = desc
+		return l
+	}
+	if sample := parseRuntimeSample(kv); sample != nil {
+		l.Runtime = sample
+		return l
+	}
+	return nil
+}
+
+// "Error R14 (Memory quota exceeded)"
+func parseDynoError(msg string) (code, desc string, ok bool) {
+	i := strings.Index(msg, "Error R")
+	if i < 0 {
+		return "", "", false
+	}
+	rest := msg[i+len("Error "):]
+	code, rest = nextSyslogToken(rest)
+	if len(code) < 2 {
+		return "", "", false
+	}
+	if _, err := strconv.Atoi(code[1:]); err != nil {
+		return "", "", false
+	}
+	if open, end := strings.IndexByte(rest, '('), strings.IndexByte(rest, ')'); open >= 0 && end > open {
+		desc = rest[open+1 : end]
+	}
+	return code, desc, true
+}
+
+func parseRuntimeSample(kv map[string]string) *RuntimeSample {
+	s := &RuntimeSample{}
+	for k, v := range kv {
+		name, ok := strings.CutPrefix(k, "sample#")
+		if !ok {
+			continue
+		}
+		var dst *float64
+		switch name {
+		case "memory_total":
+			dst, s.HasMemory = &s.MemoryTotal, true
+		case "memory_rss":
+			dst, s.HasMemory = &s.MemoryRSS, true
+		case "memory_cache":
+			dst, s.HasMemory = &s.MemoryCache, true
+		case "memory_swap":
+			dst, s.HasMemory = &s.MemorySwap, true
+		case "memory_quota":
+			dst, s.HasMemory = &s.MemoryQuota, true
+		case "load_avg_1m":
+			dst, s.HasLoad = &s.LoadAvg1m, true
+		case "load_avg_5m":
+			dst, s.HasLoad = &s.LoadAvg5m, true
+		case "load_avg_15m":
+			dst, s.HasLoad = &s.LoadAvg15m, true
+		default:
+			continue
+		}
+		if f, err := strconv.ParseFloat(strings.TrimSuffix(v, "MB"), 64); err == nil {
+			*dst = f
+		}
+	}
+	if !s.HasMemory && !s.HasLoad {
+		return nil
+	}
+	return s
+}
+
+func isMemoryError(code string) bool {
+	return code == "R14" || code == "R15"
+}
+
+// called by the aggregator with MetricsMu held
+func (a *App) applyRuntimeLog(l *ParsedLog, now time.Time) {
+	if l.SourceDyno == "" {
+		return
+	}
+	dynoMetric, exists := a.Metric.DynoPerformance[l.SourceDyno]
+	if !exists {
+		dynoMetric = DynoMetric{
+			Name:   l.SourceDyno,
+			Status: "healthy",
+		}
+	}
+
+	if s := l.Runtime; s != nil {
+		if s.HasMemory {
+			dynoMetric.MemoryTotal = s.MemoryTotal
+			dynoMetric.MemoryRSS = s.MemoryRSS
+			dynoMetric.MemoryCache = s.MemoryCache
+			dynoMetric.MemorySwap = s.MemorySwap
+			dynoMetric.MemoryQuota = s.MemoryQuota
+		}
+		if s.HasLoad {
+			dynoMetric.LoadAvg1m = s.LoadAvg1m
+			dynoMetric.LoadAvg5m = s.LoadAvg5m
+			dynoMetric.LoadAvg15m = s.LoadAvg15m
+		}
+	}
+
+	if l.ErrorCode != "" {
+		if dynoMetric.ErrorCodes == nil {
+			dynoMetric.ErrorCodes = make(map[string]int64)
+		}
+		dynoMetric.ErrorCodes[l.ErrorCode]++
+		if isMemoryError(l.ErrorCode) {
+			dynoMetric.LastMemoryError = l.ErrorCode
+			dynoMetric.LastMemoryErrorAt = now
+		}
+	}
+
+	a.Metric.DynoPerformance[l.SourceDyno] = dynoMetric
+}
+
+// R14 raises a warning and R15 a critical alert per dyno, for memoryErrorWindow
+func (a *App) generateMemoryAlerts(currentTime time.Time) {
+	names := make([]string, 0, len(a.Metric.DynoPerformance))
+	for name := range a.Metric.DynoPerformance {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		dm := a.Metric.DynoPerformance[name]
+		if dm.LastMemoryError == "" || currentTime.Sub(dm.LastMemoryErrorAt) > memoryErrorWindow {
+			a.resolveAlert(func(e Alert) bool {
+				return e.Type == "memory_quota" && e.Dyno == name
+			}, currentTime)
+			continue
+		}
+		alert := Alert{
+			Type:      "memory_quota",
+			Code:      dm.LastMemoryError,
+			Dyno:      name,
+			Severity:  "warning",
+			Message:   fmt.Sprintf("%s: %s Memory quota exceeded", name, dm.LastMemoryError),
+			Timestamp: currentTime,
+			Resolved:  false,
+		}
+		if dm.MemoryQuota > 0 {
+			alert.Message += fmt.Sprintf(" (%.0fMB of %.0fMB)", dm.MemoryTotal, dm.MemoryQuota)
+		}
+		if dm.LastMemoryError == "R15" {
+			alert.Severity = "critical"
+			alert.Message = fmt.Sprintf("%s: R15 Memory quota vastly exceeded, process killed", name)
+		}
+		// an R15 replaces the R14 alert of the same dyno
+		a.upsertAlert(alert, func(e Alert) bool {
+			return e.Type == alert.Type && e.Dyno == alert.Dyno
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRuntimeParser(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantSource string
+		check      func(t *testing.T, l *ParsedLog)
+	}{
+		{
+			name:       "memory sample",
+			line:       `<45>1 2025-07-19T10:30:45Z host heroku web.1 - source=web.1 dyno=heroku.2808254.d97d0ea7 sample#memory_total=421.00MB sample#memory_rss=400.50MB sample#memory_cache=20.50MB sample#memory_swap=1.25MB sample#memory_pgpgin=348836pages sample#memory_quota=512.00MB`,
+			wantSource: SourceRuntime,
+			check: func(t *testing.T, l *ParsedLog) {
+				if l.Runtime == nil || !l.Runtime.HasMemory || l.Runtime.HasLoad {
+					t.Fatalf("Expected memory sample, got %+v", l.Runtime)
+				}
+				if l.Runtime.MemoryTotal != 421 || l.Runtime.MemoryRSS != 400.5 || l.Runtime.MemorySwap != 1.25 || l.Runtime.MemoryQuota != 512 {
+					t.Errorf("Unexpected memory values %+v", l.Runtime)
+				}
+				if l.SourceDyno != "web.1" {
+					t.Errorf("Expected dyno web.1, got %q", l.SourceDyno)
+				}
+			},
+		},
+		{
+			name:       "load sample",
+			line:       `<45>1 2025-07-19T10:30:45Z host heroku worker.2 - source=worker.2 dyno=heroku.1 sample#load_avg_1m=2.46 sample#load_avg_5m=1.06 sample#load_avg_15m=0.99`,
+			wantSource: SourceRuntime,
+			check: func(t *testing.T, l *ParsedLog) {
+				if l.Runtime == nil || !l.Runtime.HasLoad || l.Runtime.HasMemory {
+					t.Fatalf("Expected load sample, got %+v", l.Runtime)
+				}
+				if l.Runtime.LoadAvg1m != 2.46 || l.Runtime.LoadAvg5m != 1.06 || l.Runtime.LoadAvg15m != 0.99 {
+					t.Errorf("Unexpected load values %+v", l.Runtime)
+				}
+			},
+		},
+		{
+			name:       "R14",
+			line:       `<45>1 2025-07-19T10:30:45Z host heroku web.1 - Error R14 (Memory quota exceeded)`,
+			wantSource: SourceRuntime,
+			check: func(t *testing.T, l *ParsedLog) {
+				if l.ErrorCode != "R14" || l.ErrorDesc != "Memory quota exceeded" {
+					t.Errorf("Expected R14 Memory quota exceeded, got %q %q", l.ErrorCode, l.ErrorDesc)
+				}
+			},
+		},
+		{
+			name:       "other dyno line",
+			line:       `<45>1 2025-07-19T10:30:45Z host heroku web.1 - Process running mem=1028M(200.9%)`,
+			wantSource: SourceUnparsed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{ParsedLogChan: make(chan *ParsedLog, 1)}
+			app.ParseLog([]byte(tt.line))
+
+			l := <-app.ParsedLogChan
+			if l.Source != tt.wantSource {
+				t.Fatalf("Expected source %q, got %q", tt.wantSource, l.Source)
+			}
+			if tt.check != nil {
+				tt.check(t, l)
+			}
+		})
+	}
+}
+
+func TestParseDynoError(t *testing.T) {
+	tests := []struct {
+		msg  string
+		code string
+		desc string
+		ok   bool
+	}{
+		{"Error R14 (Memory quota exceeded)", "R14", "Memory quota exceeded", true},
+		{"Error R15 (Memory quota vastly exceeded)", "R15", "Memory quota vastly exceeded", true},
+		{"Error R10 (Boot timeout) -> Web process failed to bind", "R10", "Boot timeout", true},
+		{"Error Rx (nope)", "", "", false},
+		{"State changed from up to down", "", "", false},
+	}
+	for _, tt := range tests {
+		code, desc, ok := parseDynoError(tt.msg)
+		if code != tt.code || desc != tt.desc || ok != tt.ok {
+			t.Errorf("parseDynoError(%q) = (%q, %q, %v), expected (%q, %q, %v)", tt.msg, code, desc, ok, tt.code, tt.desc, tt.ok)
+		}
+	}
+}
+
+func TestApp_RuntimeAggregation(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.StartMetricsAggregator()
+
+	app.MetricChan <- createTestParsedLog(200, "GET", "/test", "", "web.1", 50*time.Millisecond, false)
+	app.MetricChan <- &ParsedLog{
+		Source:     SourceRuntime,
+		SourceDyno: "web.1",
+		Runtime:    &RuntimeSample{MemoryTotal: 600, MemoryRSS: 550, MemoryCache: 30, MemorySwap: 50, MemoryQuota: 512, HasMemory: true},
+	}
+	app.MetricChan <- &ParsedLog{
+		Source:     SourceRuntime,
+		SourceDyno: "web.1",
+		Runtime:    &RuntimeSample{LoadAvg1m: 1.5, HasLoad: true},
+	}
+	app.MetricChan <- &ParsedLog{Source: SourceRuntime, SourceDyno: "web.1", ErrorCode: "R14"}
+
+	time.Sleep(100 * time.Millisecond)
+
+	metrics := app.GetMetricsSnapshot()
+	if metrics.TotalRequests != 1 {
+		t.Errorf("Expected runtime lines to stay out of request counters, got %d requests", metrics.TotalRequests)
+	}
+	dm := metrics.DynoPerformance["web.1"]
+	if dm.RequestCount != 1 {
+		t.Errorf("Expected 1 request on web.1, got %d", dm.RequestCount)
+	}
+	if dm.MemoryRSS != 550 || dm.MemoryCache != 30 || dm.MemorySwap != 50 || dm.MemoryQuota != 512 {
+		t.Errorf("Expected memory kept after load sample, got rss %v cache %v swap %v quota %v", dm.MemoryRSS, dm.MemoryCache, dm.MemorySwap, dm.MemoryQuota)
+	}
+	if dm.LoadAvg1m != 1.5 {
+		t.Errorf("Expected load avg 1.5, got %v", dm.LoadAvg1m)
+	}
+	if dm.ErrorCodes["R14"] != 1 || dm.LastMemoryError != "R14" {
+		t.Errorf("Expected R14 recorded, got %v %q", dm.ErrorCodes, dm.LastMemoryError)
+	}
+
+	var memoryAlert *Alert
+	for i := range metrics.ActiveAlerts {
+		if metrics.ActiveAlerts[i].Type == "memory_quota" {
+			memoryAlert = &metrics.ActiveAlerts[i]
+		}
+	}
+	if memoryAlert == nil {
+		t.Fatal("Expected memory_quota alert")
+	}
+	if memoryAlert.Dyno != "web.1" || memoryAlert.Severity != "warning" {
+		t.Errorf("Expected warning for web.1, got %+v", memoryAlert)
+	}
+}
+
+func TestApp_generateMemoryAlerts(t *testing.T) {
+	now := time.Now()
+
+	t.Run("R15 replaces R14", func(t *testing.T) {
+		app := createTestAppForMetrics()
+		app.Metric = &Metric{
+			DynoPerformance: map[string]DynoMetric{
+				"web.1": {Name: "web.1", LastMemoryError: "R15", LastMemoryErrorAt: now},
+			},
+			ActiveAlerts: []Alert{{Type: "memory_quota", Code: "R14", Dyno: "web.1", Severity: "warning"}},
+		}
+
+		app.generateAlerts()
+
+		if len(app.Metric.ActiveAlerts) != 1 {
+			t.Fatalf("Expected 1 alert, got %d", len(app.Metric.ActiveAlerts))
+		}
+		alert := app.Metric.ActiveAlerts[0]
+		if alert.Code != "R15" || alert.Severity != "critical" {
+			t.Errorf("Expected critical R15, got %s %s", alert.Code, alert.Severity)
+		}
+		if !strings.Contains(alert.Message, "web.1") {
+			t.Errorf("Expected dyno in message, got %q", alert.Message)
+		}
+	})
+
+	t.Run("old memory errors do not alert", func(t *testing.T) {
+		app := createTestAppForMetrics()
+		app.Metric = &Metric{
+			DynoPerformance: map[string]DynoMetric{
+				"web.1": {Name: "web.1", LastMemoryError: "R14", LastMemoryErrorAt: now.Add(-time.Hour)},
+			},
+			ActiveAlerts: []Alert{},
+		}
+
+		app.generateAlerts()
+
+		if len(app.Metric.ActiveAlerts) != 0 {
+			t.Errorf("Expected no alerts, got %d", len(app.Metric.ActiveAlerts))
+		}
+	})
+}
@@ -0,0 +1,287 @@
+package internal
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// DDSketch (Masson et al.), a quantile sketch with a relative error bound.
+// Values land in log spaced bins, so memory only depends on the value range and
+// two sketches with the same Alpha merge by adding their bins.
+
+const (
+	defaultLatencyWindow = 5 * time.Minute
+	latencyWindowSlots   = 5
+	percentileInterval   = 1 * time.Second
+
+	defaultSketchAlpha = 0.01 // quantiles within 1% of the true value
+	maxSketchBins      = 2048 // lowest bins are collapsed past this
+	minSketchValue     = 1.0  // ns, anything below counts as zero
+)
+
+// LatencySketch holds durations in nanoseconds. It is JSON encodable so the
+// sketches stored with the snapshots can be merged later.
+type LatencySketch struct {
+	Alpha float64        `json:"alpha"`
+	Bins  map[int]uint64 `json:"bins"` // bin index | count
+	Zero  uint64         `json:"zero"`
+	Count uint64         `json:"count"`
+	Sum   float64        `json:"sum"`
+	Min   float64        `json:"min"`
+	Max   float64        `json:"max"`
+
+	logGamma float64
+}
+
+func NewLatencySketch() *LatencySketch {
+	return NewLatencySketchWithAlpha(defaultSketchAlpha)
+}
+
+func NewLatencySketchWithAlpha(alpha float64) *LatencySketch {
+	return &LatencySketch{Alpha: alpha, Bins: make(map[int]uint64)}
+}
+
+// gamma is derived, sketches decoded from JSON only carry Alpha
+func (s *LatencySketch) gamma() float64 {
+	if s.logGamma == 0 {
+		if s.Alpha <= 0 || s.Alpha >= 1 {
+			s.Alpha = defaultSketchAlpha
+		}
+		s.logGamma = math.Log((1 + s.Alpha) / (1 - s.Alpha))
+	}
+	if s.Bins == nil {
+		s.Bins = make(map[int]uint64)
+	}
+	return s.logGamma
+}
+
+func (s *LatencySketch) Add(d time.Duration) {
+	logGamma := s.gamma()
+	v := float64(d)
+	if s.Count == 0 || v < s.Min {
+		s.Min = v
+	}
+	if s.Count == 0 || v > s.Max {
+		s.Max = v
+	}
+	s.Count++
+	s.Sum += v
+
+	if v < minSketchValue {
+		s.Zero++
+		return
+	}
+	s.Bins[int(math.Ceil(math.Log(v)/logGamma))]++
+	if len(s.Bins) > maxSketchBins {
+		s.collapse()
+	}
+}
+
+// folds the lowest bins into one, only the low quantiles lose accuracy
+func (s *LatencySketch) collapse() {
+	keys := s.sortedKeys()
+	excess := len(keys) - maxSketchBins
+	target := keys[excess]
+	for _, k := range keys[:excess] {
+		s.Bins[target] += s.Bins[k]
+		delete(s.Bins, k)
+	}
+}
+
+func (s *LatencySketch) sortedKeys() []int {
+	keys := make([]int, 0, len(s.Bins))
+	for k := range s.Bins {
+		keys = append(keys, k)
+	}
+	sort.Ints(keys)
+	return keys
+}
+
+// Merge adds o into s, both must use the same Alpha
+func (s *LatencySketch) Merge(o *LatencySketch) {
+	if o == nil || o.Count == 0 {
+		return
+	}
+	s.gamma()
+	if s.Count == 0 || o.Min < s.Min {
+		s.Min = o.Min
+	}
+	if s.Count == 0 || o.Max > s.Max {
+		s.Max = o.Max
+	}
+	s.Count += o.Count
+	s.Sum += o.Sum
+	s.Zero += o.Zero
+	for k, c := range o.Bins {
+		s.Bins[k] += c
+	}
+	if len(s.Bins) > maxSketchBins {
+		s.collapse()
+	}
+}
+
+func (s *LatencySketch) Clone() *LatencySketch {
+	if s == nil {
+		return nil
+	}
+	c := *s
+	c.Bins = make(map[int]uint64, len(s.Bins))
+	for k, v := range s.Bins {
+		c.Bins[k] = v
+	}
+	return &c
+}
+
+func (s *LatencySketch) Reset() {
+	s.Bins = make(map[int]uint64)
+	s.Zero, s.Count = 0, 0
+	s.Sum, s.Min, s.Max = 0, 0, 0
+}
+
+func (s *LatencySketch) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return time.Duration(s.Sum / float64(s.Count))
+}
+
+// Quantile of q in [0, 1], 0 for an empty sketch
+func (s *LatencySketch) Quantile(q float64) time.Duration {
+	return s.Quantiles(q)[0]
+}
+
+// Quantiles answers several qs with a single pass over the bins
+func (s *LatencySketch) Quantiles(qs ...float64) []time.Duration {
+	out := make([]time.Duration, len(qs))
+	if s.Count == 0 {
+		return out
+	}
+	logGamma := s.gamma()
+	keys := s.sortedKeys()
+	for i, q := range qs {
+		out[i] = s.quantile(q, keys, logGamma)
+	}
+	return out
+}
+
+// CountAtMost counts the values up to each of bounds (ascending), a bin counts
+// towards a bound when its midpoint does, as for the quantiles
+func (s *LatencySketch) CountAtMost(bounds ...time.Duration) []uint64 {
+	out := make([]uint64, len(bounds))
+	if s.Count == 0 {
+		return out
+	}
+	logGamma := s.gamma()
+	keys := s.sortedKeys()
+	seen, k := s.Zero, 0
+	for i, bound := range bounds {
+		for ; k < len(keys); k++ {
+			v := 2 * math.Exp(float64(keys[k])*logGamma) / (1 + math.Exp(logGamma))
+			if v > float64(bound) {
+				break
+			}
+			seen += s.Bins[keys[k]]
+		}
+		out[i] = seen
+	}
+	return out
+}
+
+func (s *LatencySketch) quantile(q float64, keys []int, logGamma float64) time.Duration {
+	switch {
+	case q <= 0:
+		return time.Duration(s.Min)
+	case q >= 1:
+		return time.Duration(s.Max)
+	}
+
+	rank := uint64(q * float64(s.Count-1))
+	seen := s.Zero
+	if rank < seen {
+		return 0
+	}
+	for _, k := range keys {
+		seen += s.Bins[k]
+		if rank < seen {
+			// midpoint of the bin in relative terms, clamped to what was seen
+			v := 2 * math.Exp(float64(k)*logGamma) / (1 + math.Exp(logGamma))
+			v = math.Max(s.Min, math.Min(s.Max, v))
+			return time.Duration(math.Round(v))
+		}
+	}
+	return time.Duration(s.Max)
+}
+
+func (a *App) newLatencyWindow() *windowedSketch {
+	window := defaultLatencyWindow
+	if a.Config != nil && a.Config.LatencyWindow > 0 {
+		window = a.Config.LatencyWindow
+	}
+	return newWindowedSketch(window, window/latencyWindowSlots)
+}
+
+// windowedSketch keeps one sketch per slot of span, the window is the merge of
+// the slots younger than len(slots)*span
+type windowedSketch struct {
+	span  time.Duration
+	slots []*LatencySketch
+	epoch []int64 // slot number each sketch was last written for
+}
+
+func newWindowedSketch(window, span time.Duration) *windowedSketch {
+	n := int(window / span)
+	if n < 1 {
+		n = 1
+	}
+	w := &windowedSketch{
+		span:  span,
+		slots: make([]*LatencySketch, n),
+		epoch: make([]int64, n),
+	}
+	for i := range w.slots {
+		w.slots[i] = NewLatencySketch()
+	}
+	return w
+}
+
+func (w *windowedSketch) Add(now time.Time, d time.Duration) {
+	e := now.UnixNano() / int64(w.span)
+	i := int(e % int64(len(w.slots)))
+	if w.epoch[i] > e {
+		return // older than the window, the slot already holds newer values
+	}
+	if w.epoch[i] != e {
+		w.slots[i].Reset()
+		w.epoch[i] = e
+	}
+	w.slots[i].Add(d)
+}
+
+// mean over the window without merging the bins
+func (w *windowedSketch) Mean(now time.Time) time.Duration {
+	e := now.UnixNano() / int64(w.span)
+	var count uint64
+	var sum float64
+	for i, s := range w.slots {
+		if e-w.epoch[i] < int64(len(w.slots)) {
+			count += s.Count
+			sum += s.Sum
+		}
+	}
+	if count == 0 {
+		return 0
+	}
+	return time.Duration(sum / float64(count))
+}
+
+func (w *windowedSketch) Merged(now time.Time) *LatencySketch {
+	e := now.UnixNano() / int64(w.span)
+	merged := NewLatencySketch()
+	for i, s := range w.slots {
+		if e-w.epoch[i] < int64(len(w.slots)) {
+			merged.Merge(s)
+		}
+	}
+	return merged
+}
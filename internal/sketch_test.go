@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"encoding/json"
+	"math/rand"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestLatencySketch_Accuracy(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	s := NewLatencySketch()
+	values := make([]time.Duration, 20000)
+	for i := range values {
+		// log-normal like spread, 1ms to a few seconds
+		values[i] = time.Duration(rng.ExpFloat64()*float64(80*time.Millisecond)) + time.Millisecond
+		s.Add(values[i])
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99, 0.999} {
+		expected := values[int(q*float64(len(values)-1))]
+		assertDurationNear(t, "quantile", s.Quantile(q), expected)
+	}
+	if s.Quantile(0) != values[0] || s.Quantile(1) != values[len(values)-1] {
+		t.Errorf("Expected exact min/max, got %v %v", s.Quantile(0), s.Quantile(1))
+	}
+	if len(s.Bins) > 1000 {
+		t.Errorf("Expected a few hundred bins, got %d", len(s.Bins))
+	}
+}
+
+func TestLatencySketch_Empty(t *testing.T) {
+	s := NewLatencySketch()
+	if s.Quantile(0.99) != 0 || s.Mean() != 0 {
+		t.Errorf("Expected zero values for an empty sketch")
+	}
+	s.Add(0)
+	if s.Zero != 1 || s.Quantile(0.5) != 0 {
+		t.Errorf("Expected zero duration counted in the zero bin, got %+v", s)
+	}
+}
+
+func TestLatencySketch_CountAtMost(t *testing.T) {
+	s := NewLatencySketch()
+	if got := s.CountAtMost(time.Second); got[0] != 0 {
+		t.Errorf("Expected nothing counted in an empty sketch, got %v", got)
+	}
+	s.Add(0)
+	for i := 1; i <= 1000; i++ {
+		s.Add(time.Duration(i) * time.Millisecond)
+	}
+
+	got := s.CountAtMost(time.Millisecond/2, 100*time.Millisecond, 500*time.Millisecond, time.Hour)
+	if got[0] != 1 || got[3] != s.Count {
+		t.Errorf("Expected the zero bin alone and then everything, got %v", got)
+	}
+	// within the 1% of the bins
+	for i, want := range []float64{101, 501} {
+		if c := float64(got[i+1]); c < want*0.98 || c > want*1.02 {
+			t.Errorf("Expected ~%g at the %d. bound, got %g", want, i+2, c)
+		}
+	}
+}
+
+func TestLatencySketch_Merge(t *testing.T) {
+	web1, web2, all := NewLatencySketch(), NewLatencySketch(), NewLatencySketch()
+	for i := 1; i <= 500; i++ {
+		d := time.Duration(i) * time.Millisecond
+		web1.Add(d)
+		all.Add(d)
+		d = time.Duration(i) * 10 * time.Millisecond
+		web2.Add(d)
+		all.Add(d)
+	}
+
+	// sketches stored with snapshots come back through JSON
+	b, err := json.Marshal(web2)
+	if err != nil {
+		t.Fatalf("Failed to marshal sketch: %v", err)
+	}
+	var decoded LatencySketch
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatalf("Failed to unmarshal sketch: %v", err)
+	}
+
+	merged := web1.Clone()
+	merged.Merge(&decoded)
+
+	if merged.Count != all.Count || merged.Min != all.Min || merged.Max != all.Max {
+		t.Errorf("Expected merged summary to match, got %d/%v/%v", merged.Count, merged.Min, merged.Max)
+	}
+	for _, q := range []float64{0.5, 0.95, 0.99} {
+		if merged.Quantile(q) != all.Quantile(q) {
+			t.Errorf("q%.2f: merged %v, direct %v", q, merged.Quantile(q), all.Quantile(q))
+		}
+	}
+	if web1.Count != 500 {
+		t.Errorf("Expected Clone to leave the source untouched, got %d", web1.Count)
+	}
+}
+
+func TestLatencySketch_BoundedBins(t *testing.T) {
+	s := NewLatencySketch()
+	// every value in its own bin, 1ns to hours
+	for d := time.Duration(1); d < 10*time.Hour; d = d*102/100 + 1 {
+		s.Add(d)
+	}
+	if len(s.Bins) > maxSketchBins {
+		t.Errorf("Expected at most %d bins, got %d", maxSketchBins, len(s.Bins))
+	}
+	if s.Quantile(1) < 9*time.Hour {
+		t.Errorf("Expected max kept after collapsing, got %v", s.Quantile(1))
+	}
+}
+
+func TestWindowedSketch(t *testing.T) {
+	w := newWindowedSketch(5*time.Minute, time.Minute)
+	start := time.Date(2025, 7, 19, 10, 0, 0, 0, time.UTC)
+
+	w.Add(start, 100*time.Millisecond)
+	w.Add(start.Add(2*time.Minute), 300*time.Millisecond)
+
+	if got := w.Merged(start.Add(3 * time.Minute)).Count; got != 2 {
+		t.Errorf("Expected 2 values in the window, got %d", got)
+	}
+	if got := w.Mean(start.Add(3 * time.Minute)); got != 200*time.Millisecond {
+		t.Errorf("Expected mean 200ms, got %v", got)
+	}
+	if got := w.Merged(start.Add(6 * time.Minute)).Count; got != 1 {
+		t.Errorf("Expected the first minute to expire, got %d values", got)
+	}
+
+	// the slot of start is reused five minutes later
+	w.Add(start.Add(5*time.Minute), 50*time.Millisecond)
+	merged := w.Merged(start.Add(5 * time.Minute))
+	if merged.Count != 2 || merged.Quantile(0) != 50*time.Millisecond {
+		t.Errorf("Expected reused slot to be reset, got %d values min %v", merged.Count, merged.Quantile(0))
+	}
+}
@@ -0,0 +1,328 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Declarative SLOs over the router traffic, loaded from a JSON file:
+//
+//	{
+//	  "slos": [
+//	    {"name": "availability", "objective": 99.9},
+//	    {"name": "checkout-latency", "objective": 99.5, "latency": "500ms", "window": "30d", "route": "/checkout"}
+//	  ]
+//	}
+//
+// A request is good when it is not a 5xx and, with a latency set, answered
+// within it. Burn rate is the error rate over the rate the objective allows;
+// alerts use the multi-window pairs from the SRE workbook so a short spike
+// alone does not page and a recovered service resolves quickly.
+
+const (
+	defaultSLOWindow = 30 * 24 * time.Hour
+	maxSLOWindow     = 90 * 24 * time.Hour
+	sloFineSpan      = time.Minute // burn rate buckets
+	sloFineWindow    = 6 * time.Hour
+	sloCoarseSpan    = time.Hour // error budget buckets
+)
+
+// long and short window, both have to burn faster than rate
+var sloBurnAlerts = []struct {
+	severity    string
+	long, short time.Duration
+	rate        float64
+}{
+	{"critical", time.Hour, 5 * time.Minute, 14.4},
+	{"warning", 6 * time.Hour, 30 * time.Minute, 6},
+}
+
+// reported with every status
+var sloBurnWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"5m", 5 * time.Minute},
+	{"30m", 30 * time.Minute},
+	{"1h", time.Hour},
+	{"6h", 6 * time.Hour},
+}
+
+type SLOConfig struct {
+	Name      string  `json:"name"`
+	Objective float64 `json:"objective"`         // percent of good requests, 99.5
+	Latency   string  `json:"latency,omitempty"` // "500ms", empty for availability only
+	Window    string  `json:"window,omitempty"`  // "30d", "720h", defaults to 30d
+	Route     string  `json:"route,omitempty"`   // normalized route, empty for all traffic
+}
+
+type SLOFile struct {
+	SLOs []SLOConfig `json:"slos"`
+}
+
+type SLO struct {
+	Name      string
+	Objective float64
+	Latency   time.Duration
+	Window    time.Duration
+	Route     string
+}
+
+type SLOStatus struct {
+	Name            string             `json:"name"`
+	Route           string             `json:"route,omitempty"`
+	Objective       float64            `json:"objective"`
+	Latency         time.Duration      `json:"latency,omitempty"`
+	Window          time.Duration      `json:"window"`
+	Total           int64              `json:"total"`
+	Good            int64              `json:"good"`
+	Compliance      float64            `json:"compliance"`       // percent good over the window
+	ErrorBudget     float64            `json:"error_budget"`     // bad requests the window allows
+	BudgetRemaining float64            `json:"budget_remaining"` // percent of the budget left, negative once overspent
+	BurnRates       map[string]float64 `json:"burn_rates"`       // "5m", "30m", "1h", "6h"
+}
+
+// CompileSLOs validates an SLO file, errors name the offending SLO
+func CompileSLOs(f *SLOFile) ([]SLO, error) {
+	slos := make([]SLO, 0, len(f.SLOs))
+	seen := make(map[string]struct{}, len(f.SLOs))
+	for _, c := range f.SLOs {
+		if c.Name == "" {
+			return nil, errors.New("slo without a name")
+		}
+		if _, dup := seen[c.Name]; dup {
+			return nil, fmt.Errorf("slo %q: duplicate name", c.Name)
+		}
+		seen[c.Name] = struct{}{}
+
+		if c.Objective <= 0 || c.Objective >= 100 {
+			return nil, fmt.Errorf("slo %q: objective must be between 0 and 100", c.Name)
+		}
+		slo := SLO{Name: c.Name, Objective: c.Objective, Window: defaultSLOWindow, Route: c.Route}
+		if c.Latency != "" {
+			d, err := time.ParseDuration(c.Latency)
+			if err != nil || d <= 0 {
+				return nil, fmt.Errorf("slo %q: bad latency %q", c.Name, c.Latency)
+			}
+			slo.Latency = d
+		}
+		if c.Window != "" {
+			d, err := parseSLOWindow(c.Window)
+			if err != nil || d < sloCoarseSpan || d > maxSLOWindow {
+				return nil, fmt.Errorf("slo %q: window must be between 1h and 90d", c.Name)
+			}
+			slo.Window = d
+		}
+		slos = append(slos, slo)
+	}
+	return slos, nil
+}
+
+// time.ParseDuration plus a "d" suffix for days
+func parseSLOWindow(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, err
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func LoadSLOs(path string) ([]SLO, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f SLOFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, err
+	}
+	return CompileSLOs(&f)
+}
+
+type sloBucket struct {
+	epoch int64 // bucket number since the unix epoch, 0 when unused
+	good  int64
+	total int64
+}
+
+// ring of good/total counters, buckets older than the ring are overwritten
+type sloRing struct {
+	span    time.Duration
+	buckets []sloBucket
+}
+
+func newSLORing(span, window time.Duration) *sloRing {
+	return &sloRing{span: span, buckets: make([]sloBucket, int(window/span))}
+}
+
+func (r *sloRing) bucket(e int64) *sloBucket {
+	n := int64(len(r.buckets))
+	return &r.buckets[(e%n+n)%n]
+}
+
+func (r *sloRing) add(at time.Time, good bool) {
+	e := at.UnixNano() / int64(r.span)
+	b := r.bucket(e)
+	if b.epoch > e {
+		return
+	}
+	if b.epoch != e {
+		*b = sloBucket{epoch: e}
+	}
+	b.total++
+	if good {
+		b.good++
+	}
+}
+
+// counters of the last d before now, the current bucket included
+func (r *sloRing) sum(now time.Time, d time.Duration) (good, total int64) {
+	last := now.UnixNano() / int64(r.span)
+	n := int64(d / r.span)
+	if n > int64(len(r.buckets)) {
+		n = int64(len(r.buckets))
+	}
+	for e := last - n + 1; e <= last; e++ {
+		b := r.bucket(e)
+		if b.epoch == e {
+			good += b.good
+			total += b.total
+		}
+	}
+	return good, total
+}
+
+type sloState struct {
+	SLO
+	fine   *sloRing // burn rates
+	coarse *sloRing // compliance and budget
+}
+
+// SLOTracker is written by the aggregator and read with the snapshot, both under MetricsMu
+type SLOTracker struct {
+	slos []*sloState
+}
+
+func NewSLOTracker(slos []SLO) *SLOTracker {
+	t := &SLOTracker{}
+	for _, slo := range slos {
+		t.slos = append(t.slos, &sloState{
+			SLO:    slo,
+			fine:   newSLORing(sloFineSpan, sloFineWindow),
+			coarse: newSLORing(sloCoarseSpan, slo.Window),
+		})
+	}
+	return t
+}
+
+// Record counts a router line against every SLO covering route
+func (t *SLOTracker) Record(at time.Time, route string, l *ParsedLog) {
+	if t == nil {
+		return
+	}
+	for _, s := range t.slos {
+		if s.Route != "" && s.Route != route {
+			continue
+		}
+		good := l.Status < 500 && (s.Latency == 0 || l.ResponseTime <= s.Latency)
+		s.fine.add(at, good)
+		s.coarse.add(at, good)
+	}
+}
+
+func (t *SLOTracker) Status(now time.Time) []SLOStatus {
+	if t == nil {
+		return nil
+	}
+	statuses := make([]SLOStatus, 0, len(t.slos))
+	for _, s := range t.slos {
+		allowed := 1 - s.Objective/100
+		good, total := s.coarse.sum(now, s.Window)
+		status := SLOStatus{
+			Name:            s.Name,
+			Route:           s.Route,
+			Objective:       s.Objective,
+			Latency:         s.Latency,
+			Window:          s.Window,
+			Total:           total,
+			Good:            good,
+			Compliance:      100,
+			ErrorBudget:     float64(total) * allowed,
+			BudgetRemaining: 100,
+			BurnRates:       make(map[string]float64, len(sloBurnWindows)),
+		}
+		if total > 0 {
+			status.Compliance = float64(good) / float64(total) * 100
+			status.BudgetRemaining = (1 - float64(total-good)/status.ErrorBudget) * 100
+		}
+		for _, w := range sloBurnWindows {
+			status.BurnRates[w.name] = s.burnRate(now, w.d)
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// error rate over d relative to the rate the objective allows
+func (s *sloState) burnRate(now time.Time, d time.Duration) float64 {
+	good, total := s.fine.sum(now, d)
+	if total == 0 {
+		return 0
+	}
+	return float64(total-good) / float64(total) / (1 - s.Objective/100)
+}
+
+// statuses and burn alerts on the alert engine tick, so they keep moving while
+// no router lines arrive
+func (a *App) evaluateSLOs(now time.Time) {
+	if a.SLOs == nil {
+		return
+	}
+	a.Metric.SLOs = a.SLOs.Status(now)
+	a.generateSLOAlerts(now)
+}
+
+// burn rate alerts per SLO, resolved once no window pair burns anymore
+func (a *App) generateSLOAlerts(currentTime time.Time) {
+	for _, status := range a.Metric.SLOs {
+		same := func(e Alert) bool { return e.Type == "slo_burn" && e.SLO == status.Name }
+
+		fired := false
+		for _, rule := range sloBurnAlerts {
+			long := status.BurnRates[burnWindowName(rule.long)]
+			short := status.BurnRates[burnWindowName(rule.short)]
+			if long <= rule.rate || short <= rule.rate {
+				continue
+			}
+			a.upsertAlert(Alert{
+				Type:      "slo_burn",
+				SLO:       status.Name,
+				Severity:  rule.severity,
+				Message:   fmt.Sprintf("SLO %s is burning its error budget %.1fx too fast over %s", status.Name, long, burnWindowName(rule.long)),
+				Timestamp: currentTime,
+			}, same)
+			fired = true
+			break
+		}
+		if !fired {
+			a.resolveAlert(same, currentTime)
+		}
+	}
+}
+
+func burnWindowName(d time.Duration) string {
+	for _, w := range sloBurnWindows {
+		if w.d == d {
+			return w.name
+		}
+	}
+	return d.String()
+}
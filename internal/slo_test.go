@@ -0,0 +1,206 @@
+package internal
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCompileSLOs(t *testing.T) {
+	tests := []struct {
+		name    string
+		slos    SLOFile
+		wantErr string
+	}{
+		{"availability", SLOFile{SLOs: []SLOConfig{{Name: "a", Objective: 99.9}}}, ""},
+		{"latency", SLOFile{SLOs: []SLOConfig{{Name: "a", Objective: 99.5, Latency: "500ms", Window: "7d", Route: "/checkout"}}}, ""},
+		{"no name", SLOFile{SLOs: []SLOConfig{{Objective: 99}}}, "without a name"},
+		{"duplicate", SLOFile{SLOs: []SLOConfig{{Name: "a", Objective: 99}, {Name: "a", Objective: 98}}}, "duplicate"},
+		{"objective", SLOFile{SLOs: []SLOConfig{{Name: "a", Objective: 100}}}, "objective"},
+		{"bad latency", SLOFile{SLOs: []SLOConfig{{Name: "a", Objective: 99, Latency: "fast"}}}, "bad latency"},
+		{"short window", SLOFile{SLOs: []SLOConfig{{Name: "a", Objective: 99, Window: "5m"}}}, "window"},
+		{"long window", SLOFile{SLOs: []SLOConfig{{Name: "a", Objective: 99, Window: "365d"}}}, "window"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := CompileSLOs(&tt.slos)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("Unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %v", tt.wantErr, err)
+			}
+		})
+	}
+}
+
+func TestLoadSLOs(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "slos.json")
+	os.WriteFile(p, []byte(`{"slos": [{"name": "latency", "objective": 99.5, "latency": "500ms", "window": "30d"}]}`), 0o644)
+
+	slos, err := LoadSLOs(p)
+	if err != nil {
+		t.Fatalf("Failed to load SLOs: %v", err)
+	}
+	if len(slos) != 1 || slos[0].Latency != 500*time.Millisecond || slos[0].Window != 30*24*time.Hour {
+		t.Errorf("Unexpected SLOs %+v", slos)
+	}
+
+	if _, err := LoadSLOs(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected an error for a missing file")
+	}
+}
+
+func TestSLOTracker_Status(t *testing.T) {
+	tracker := NewSLOTracker([]SLO{
+		{Name: "availability", Objective: 99, Window: 24 * time.Hour},
+		{Name: "checkout", Objective: 90, Latency: 500 * time.Millisecond, Window: 24 * time.Hour, Route: "/checkout"},
+	})
+	now := time.Date(2025, 7, 19, 12, 0, 30, 0, time.UTC)
+
+	// a quiet morning, then 2 errors in the last minute
+	for i := 0; i < 198; i++ {
+		tracker.Record(now.Add(-3*time.Hour), "/", &ParsedLog{Status: 200})
+	}
+	tracker.Record(now, "/", &ParsedLog{Status: 500})
+	tracker.Record(now, "/", &ParsedLog{Status: 502})
+	// only the slow checkout is bad for the latency SLO
+	tracker.Record(now, "/checkout", &ParsedLog{Status: 200, ResponseTime: 100 * time.Millisecond})
+	tracker.Record(now, "/checkout", &ParsedLog{Status: 200, ResponseTime: time.Second})
+
+	statuses := tracker.Status(now)
+	availability, checkout := statuses[0], statuses[1]
+
+	if availability.Total != 202 || availability.Good != 200 {
+		t.Errorf("Unexpected availability counters %+v", availability)
+	}
+	// 202 requests allow 2.02 bad ones, 2 are spent
+	if math.Abs(availability.BudgetRemaining-(1-2/2.02)*100) > 1e-9 {
+		t.Errorf("Unexpected budget remaining %f", availability.BudgetRemaining)
+	}
+	// all 4 requests of the last 5 minutes, 2 of them bad, at 1% allowed
+	if math.Abs(availability.BurnRates["5m"]-50) > 1e-9 {
+		t.Errorf("Expected 5m burn rate 50, got %f", availability.BurnRates["5m"])
+	}
+	if math.Abs(availability.BurnRates["6h"]-2/2.02) > 1e-9 {
+		t.Errorf("Expected 6h burn rate ~0.99, got %f", availability.BurnRates["6h"])
+	}
+
+	if checkout.Total != 2 || checkout.Good != 1 || checkout.Compliance != 50 {
+		t.Errorf("Unexpected checkout status %+v", checkout)
+	}
+	if checkout.BudgetRemaining >= 0 {
+		t.Errorf("Expected the checkout budget overspent, got %f", checkout.BudgetRemaining)
+	}
+
+	// everything rolls out of the window
+	later := tracker.Status(now.Add(48 * time.Hour))
+	if later[0].Total != 0 || later[0].BudgetRemaining != 100 || later[0].BurnRates["1h"] != 0 {
+		t.Errorf("Expected an empty window two days later, got %+v", later[0])
+	}
+}
+
+func TestApp_GenerateSLOAlerts(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.Metric = &Metric{ActiveAlerts: []Alert{}}
+	now := time.Now()
+
+	burning := func(short, long float64) []SLOStatus {
+		return []SLOStatus{{
+			Name:      "availability",
+			BurnRates: map[string]float64{"5m": short, "30m": short, "1h": long, "6h": long},
+		}}
+	}
+	findSLOAlert := func() *Alert {
+		for i := range app.Metric.ActiveAlerts {
+			if a := &app.Metric.ActiveAlerts[i]; a.Type == "slo_burn" && a.SLO == "availability" && !a.Resolved {
+				return a
+			}
+		}
+		return nil
+	}
+
+	// a spike alone does not page
+	app.Metric.SLOs = burning(50, 2)
+	app.generateSLOAlerts(now)
+	if alert := findSLOAlert(); alert != nil {
+		t.Errorf("Expected no alert for a short spike, got %+v", alert)
+	}
+
+	app.Metric.SLOs = burning(20, 15)
+	app.generateSLOAlerts(now)
+	if alert := findSLOAlert(); alert == nil || alert.Severity != "critical" {
+		t.Fatalf("Expected a critical burn alert, got %+v", alert)
+	}
+
+	app.Metric.SLOs = burning(8, 7)
+	app.generateSLOAlerts(now)
+	if alert := findSLOAlert(); alert == nil || alert.Severity != "warning" {
+		t.Fatalf("Expected the alert downgraded to warning, got %+v", alert)
+	}
+	if len(app.Metric.ActiveAlerts) != 1 {
+		t.Errorf("Expected one alert per SLO, got %d", len(app.Metric.ActiveAlerts))
+	}
+
+	// resolves once the burn stayed low for alertResolveAfter
+	app.Metric.SLOs = burning(0, 3)
+	app.generateSLOAlerts(now)
+	if alert := findSLOAlert(); alert == nil {
+		t.Fatal("Expected the alert to hold until the burn stays low")
+	}
+	app.generateSLOAlerts(now.Add(defaultAlertResolveAfter))
+	if alert := findSLOAlert(); alert != nil {
+		t.Errorf("Expected the alert resolved, got %+v", alert)
+	}
+}
+
+func TestMetricsAggregator_SLOs(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.SLOs = NewSLOTracker([]SLO{{Name: "availability", Objective: 99, Window: defaultSLOWindow}})
+	app.StartMetricsAggregator()
+
+	app.MetricChan <- createTestParsedLog(500, "GET", "/test", "", "web.1", 10*time.Millisecond, false)
+	time.Sleep(100 * time.Millisecond)
+
+	snapshot := app.GetMetricsSnapshot()
+	if len(snapshot.SLOs) != 1 || snapshot.SLOs[0].Total != 1 || snapshot.SLOs[0].Good != 0 {
+		t.Fatalf("Expected the request counted against the SLO, got %+v", snapshot.SLOs)
+	}
+	alert := findAlert(snapshot.ActiveAlerts, "slo_burn", "")
+	if alert == nil || alert.Severity != "critical" || alert.SLO != "availability" {
+		t.Errorf("Expected a critical burn alert, got %+v", alert)
+	}
+}
+
+func TestApp_EvaluateSLOs_WithoutTraffic(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.Metric = &Metric{ActiveAlerts: []Alert{}}
+	app.SLOs = NewSLOTracker([]SLO{{Name: "availability", Objective: 99, Window: defaultSLOWindow}})
+	now := time.Now()
+	for i := 0; i < 10; i++ {
+		app.SLOs.Record(now, "/", createTestParsedLog(500, "GET", "/", "", "web.1", 10*time.Millisecond, false))
+	}
+
+	app.evaluateSLOs(now)
+	if alert := findAlert(app.Metric.ActiveAlerts, "slo_burn", ""); alert == nil || alert.Resolved {
+		t.Fatalf("Expected a burn alert, got %+v", app.Metric.ActiveAlerts)
+	}
+
+	// the outage ends with no further lines, the short windows drain on the tick
+	later := now.Add(2 * time.Hour)
+	app.evaluateSLOs(later)
+	app.evaluateSLOs(later.Add(defaultAlertResolveAfter))
+	if alert := findAlert(app.Metric.ActiveAlerts, "slo_burn", ""); alert == nil || !alert.Resolved {
+		t.Errorf("Expected the burn alert resolved, got %+v", alert)
+	}
+	if app.Metric.SLOs[0].BurnRates["5m"] != 0 {
+		t.Errorf("Expected the statuses refreshed, got %+v", app.Metric.SLOs[0])
+	}
+}
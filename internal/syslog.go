@@ -0,0 +1,196 @@
+package internal
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// RFC 5424 header as sent by logplex
+// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] MSG
+type SyslogHeader struct {
+	Facility       int
+	Severity       int
+	Version        int
+	Timestamp      string
+	Hostname       string
+	AppName        string
+	ProcId         string
+	MsgId          string
+	StructuredData string
+}
+
+const syslogNil = "-"
+
+var errBadSyslog = errors.New("malformed syslog header")
+
+// ParseSyslog splits an RFC 5424 line into its header and the free form message.
+// Heroku sends a single "-" in place of MSGID and STRUCTURED-DATA, so after a nil
+// MSGID the rest is the message unless it starts with SD-ELEMENTs carrying
+// params, tagged lines like "[7f3e2a] Started GET" keep their tag.
+func ParseSyslog(line string) (*SyslogHeader, string, error) {
+	if !strings.HasPrefix(line, "<") {
+		return nil, "", errBadSyslog
+	}
+	end := strings.IndexByte(line, '>')
+	if end < 2 || end > 4 {
+		return nil, "", errBadSyslog
+	}
+	pri, err := strconv.Atoi(line[1:end])
+	if err != nil || pri < 0 || pri > 191 {
+		return nil, "", errBadSyslog
+	}
+	h := &SyslogHeader{
+		Facility: pri / 8,
+		Severity: pri % 8,
+	}
+
+	rest := line[end+1:]
+	fields := make([]string, 6) // version timestamp hostname app procid msgid
+	for i := range fields {
+		var tok string
+		tok, rest = nextSyslogToken(rest)
+		if tok == "" {
+			return nil, "", errBadSyslog
+		}
+		fields[i] = tok
+	}
+	h.Version, err = strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, "", errBadSyslog
+	}
+	h.Timestamp = nilToEmpty(fields[1])
+	h.Hostname = nilToEmpty(fields[2])
+	h.AppName = nilToEmpty(fields[3])
+	h.ProcId = nilToEmpty(fields[4])
+	h.MsgId = nilToEmpty(fields[5])
+
+	switch {
+	case rest == syslogNil:
+		rest = ""
+	case h.MsgId == "":
+		if n := sdElementsLen(rest); n > 0 {
+			h.StructuredData = rest[:n]
+			rest = strings.TrimPrefix(rest[n:], " ")
+		}
+	case strings.HasPrefix(rest, syslogNil+" "):
+		rest = rest[len(syslogNil)+1:]
+	case strings.HasPrefix(rest, "["):
+		n := structuredDataLen(rest)
+		if n < 0 {
+			return nil, "", errBadSyslog
+		}
+		h.StructuredData = rest[:n]
+		rest = strings.TrimPrefix(rest[n:], " ")
+	}
+
+	return h, rest, nil
+}
+
+func nextSyslogToken(s string) (string, string) {
+	i := strings.IndexByte(s, ' ')
+	if i < 0 {
+		return s, ""
+	}
+	return s[:i], s[i+1:]
+}
+
+func nilToEmpty(s string) string {
+	if s == syslogNil {
+		return ""
+	}
+	return s
+}
+
+// length of one or more consecutive SD-ELEMENTs, -1 if unterminated
+func structuredDataLen(s string) int {
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		inQuote := false
+		closed := false
+		for i++; i < len(s); i++ {
+			c := s[i]
+			if inQuote {
+				if c == '\\' {
+					i++
+				} else if c == '"' {
+					inQuote = false
+				}
+				continue
+			}
+			if c == '"' {
+				inQuote = true
+			} else if c == ']' {
+				closed = true
+				i++
+				break
+			}
+		}
+		if !closed {
+			return -1
+		}
+	}
+	return i
+}
+
+// length of well formed SD-ELEMENTs, each an SD-ID with at least one
+// name="value" param, -1 if s does not start with them
+func sdElementsLen(s string) int {
+	i := 0
+	for i < len(s) && s[i] == '[' {
+		j := i + 1
+		n := sdNameLen(s[j:])
+		if n == 0 {
+			return -1
+		}
+		j += n
+		params := 0
+		for j < len(s) && s[j] == ' ' {
+			j++
+			n = sdNameLen(s[j:])
+			if n == 0 || !strings.HasPrefix(s[j+n:], `="`) {
+				return -1
+			}
+			for j += n + 2; j < len(s) && s[j] != '"'; j++ {
+				if s[j] == '\\' {
+					j++
+				}
+			}
+			if j >= len(s) {
+				return -1
+			}
+			j++
+			params++
+		}
+		if params == 0 || j >= len(s) || s[j] != ']' {
+			return -1
+		}
+		i = j + 1
+	}
+	if i == 0 || (i < len(s) && s[i] != ' ') {
+		return -1
+	}
+	return i
+}
+
+// SD-NAME, printable ASCII up to 32 chars without '=', ' ', ']' or '"'
+func sdNameLen(s string) int {
+	n := 0
+	for n < len(s) && n < 32 && s[n] > ' ' && s[n] < 127 && s[n] != '=' && s[n] != ']' && s[n] != '"' {
+		n++
+	}
+	return n
+}
+
+// Heroku's own rendering of a line (`heroku logs`) uses "app[proc]:" instead of
+// separate APP-NAME and PROCID fields
+func parseSourceTag(tag string) (app, proc string, ok bool) {
+	if !strings.HasSuffix(tag, "]:") {
+		return "", "", false
+	}
+	open := strings.IndexByte(tag, '[')
+	if open < 1 {
+		return "", "", false
+	}
+	return tag[:open], tag[open+1 : len(tag)-2], true
+}
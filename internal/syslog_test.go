@@ -0,0 +1,143 @@
+package internal
+
+import "testing"
+
+func TestParseSyslog(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    SyslogHeader
+		wantMsg string
+		wantErr bool
+	}{
+		{
+			name: "heroku router line without structured data",
+			line: `<158>1 2025-07-19T10:30:45.123456+00:00 host heroku router - at=info method=GET path="/" status=200`,
+			want: SyslogHeader{
+				Facility: 19, Severity: 6, Version: 1,
+				Timestamp: "2025-07-19T10:30:45.123456+00:00", Hostname: "host",
+				AppName: "heroku", ProcId: "router",
+			},
+			wantMsg: `at=info method=GET path="/" status=200`,
+		},
+		{
+			name: "app line",
+			line: `<190>1 2025-07-19T10:30:45Z d.1234-abcd app web.1 - Hello world`,
+			want: SyslogHeader{
+				Facility: 23, Severity: 6, Version: 1,
+				Timestamp: "2025-07-19T10:30:45Z", Hostname: "d.1234-abcd",
+				AppName: "app", ProcId: "web.1",
+			},
+			wantMsg: "Hello world",
+		},
+		{
+			name: "nil structured data",
+			line: `<13>1 2025-07-19T10:30:45Z myhost myapp 42 ID7 - the message`,
+			want: SyslogHeader{
+				Facility: 1, Severity: 5, Version: 1,
+				Timestamp: "2025-07-19T10:30:45Z", Hostname: "myhost",
+				AppName: "myapp", ProcId: "42", MsgId: "ID7",
+			},
+			wantMsg: "the message",
+		},
+		{
+			name: "structured data with escaped bracket",
+			line: `<165>1 2025-07-19T10:30:45Z host app web.1 ID47 [exampleSDID@32473 iut="3" note="a\]b"][meta seq="1"] body`,
+			want: SyslogHeader{
+				Facility: 20, Severity: 5, Version: 1,
+				Timestamp: "2025-07-19T10:30:45Z", Hostname: "host",
+				AppName: "app", ProcId: "web.1", MsgId: "ID47",
+				StructuredData: `[exampleSDID@32473 iut="3" note="a\]b"][meta seq="1"]`,
+			},
+			wantMsg: "body",
+		},
+		{
+			name: "heroku line with a tagged message",
+			line: `<190>1 2025-07-19T10:30:45Z host app web.1 - [7f3e2a] Started GET "/"`,
+			want: SyslogHeader{
+				Facility: 23, Severity: 6, Version: 1,
+				Timestamp: "2025-07-19T10:30:45Z", Hostname: "host",
+				AppName: "app", ProcId: "web.1",
+			},
+			wantMsg: `[7f3e2a] Started GET "/"`,
+		},
+		{
+			name: "heroku line with a dash prefixed message",
+			line: `<190>1 2025-07-19T10:30:45Z host app web.1 - - dash prefixed`,
+			want: SyslogHeader{
+				Facility: 23, Severity: 6, Version: 1,
+				Timestamp: "2025-07-19T10:30:45Z", Hostname: "host",
+				AppName: "app", ProcId: "web.1",
+			},
+			wantMsg: "- dash prefixed",
+		},
+		{
+			name: "structured data after a nil msgid",
+			line: `<190>1 2025-07-19T10:30:45Z host app web.1 - [origin ip="10.0.0.1"] body`,
+			want: SyslogHeader{
+				Facility: 23, Severity: 6, Version: 1,
+				Timestamp: "2025-07-19T10:30:45Z", Hostname: "host",
+				AppName: "app", ProcId: "web.1",
+				StructuredData: `[origin ip="10.0.0.1"]`,
+			},
+			wantMsg: "body",
+		},
+		{
+			name: "nil fields",
+			line: `<0>1 - - - - - -`,
+			want: SyslogHeader{Version: 1},
+		},
+		{name: "no pri", line: `2025-07-19T10:30:45Z heroku[router]: at=info`, wantErr: true},
+		{name: "pri out of range", line: `<192>1 2025-07-19T10:30:45Z h a p - msg`, wantErr: true},
+		{name: "non numeric version", line: `<13>x 2025-07-19T10:30:45Z h a p - msg`, wantErr: true},
+		{name: "truncated header", line: `<13>1 2025-07-19T10:30:45Z host`, wantErr: true},
+		{name: "unterminated structured data", line: `<13>1 2025-07-19T10:30:45Z h a p m [id k="v" msg`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, msg, err := ParseSyslog(tt.line)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got header %+v", h)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if *h != tt.want {
+				t.Errorf("Expected header %+v, got %+v", tt.want, *h)
+			}
+			if msg != tt.wantMsg {
+				t.Errorf("Expected message %q, got %q", tt.wantMsg, msg)
+			}
+		})
+	}
+}
+
+func TestParseSourceTag(t *testing.T) {
+	tests := []struct {
+		tag      string
+		app      string
+		proc     string
+		expectOk bool
+	}{
+		{"heroku[router]:", "heroku", "router", true},
+		{"app[web.1]:", "app", "web.1", true},
+		{"app[api]:", "app", "api", true},
+		{"at=info", "", "", false},
+		{"[router]:", "", "", false},
+		{"heroku[router]", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			app, proc, ok := parseSourceTag(tt.tag)
+			if ok != tt.expectOk || app != tt.app || proc != tt.proc {
+				t.Errorf("parseSourceTag(%q) = (%q, %q, %v), expected (%q, %q, %v)",
+					tt.tag, app, proc, ok, tt.app, tt.proc, tt.expectOk)
+			}
+		})
+	}
+}
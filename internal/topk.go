@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"container/heap"
+	"sort"
+)
+
+// Streaming heavy hitters with the Space-Saving algorithm (Metwally et al.).
+// Memory is bounded by the counter capacity, a key that is not tracked evicts
+// the smallest counter and inherits its count as the error bound.
+
+const (
+	defaultTopK         = 5
+	topKCapacityPerSlot = 10 // counters per reported slot when no capacity is configured
+)
+
+// HeavyHitter is one reported key. Count overestimates the true count by at
+// most Error; Guaranteed means the key is in the top K whatever the error.
+type HeavyHitter struct {
+	Key        string `json:"key"`
+	Count      int64  `json:"count"`
+	Error      int64  `json:"error"`
+	Guaranteed bool   `json:"guaranteed"`
+}
+
+type ssCounter struct {
+	key   string
+	count int64
+	err   int64
+	index int
+}
+
+// min-heap on count
+type ssHeap []*ssCounter
+
+func (h ssHeap) Len() int           { return len(h) }
+func (h ssHeap) Less(i, j int) bool { return h[i].count < h[j].count }
+func (h ssHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *ssHeap) Push(x any) {
+	c := x.(*ssCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+func (h *ssHeap) Pop() any {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
+
+type SpaceSaving struct {
+	capacity int
+	counters map[string]*ssCounter
+	heap     ssHeap
+}
+
+func NewSpaceSaving(capacity int) *SpaceSaving {
+	if capacity < 1 {
+		capacity = 1
+	}
+	return &SpaceSaving{
+		capacity: capacity,
+		counters: make(map[string]*ssCounter, capacity),
+		heap:     make(ssHeap, 0, capacity),
+	}
+}
+
+func (s *SpaceSaving) Add(key string) {
+	if c, ok := s.counters[key]; ok {
+		c.count++
+		heap.Fix(&s.heap, c.index)
+		return
+	}
+	if len(s.heap) < s.capacity {
+		c := &ssCounter{key: key, count: 1}
+		s.counters[key] = c
+		heap.Push(&s.heap, c)
+		return
+	}
+	// replace the minimum, the new key may have been seen up to min times before
+	min := s.heap[0]
+	delete(s.counters, min.key)
+	min.key = key
+	min.err = min.count
+	min.count++
+	s.counters[key] = min
+	heap.Fix(&s.heap, 0)
+}
+
+// Top returns the k largest counters, highest first
+func (s *SpaceSaving) Top(k int) []HeavyHitter {
+	sorted := make([]*ssCounter, len(s.heap))
+	copy(sorted, s.heap)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].key < sorted[j].key
+	})
+
+	// guaranteed when the lower bound beats every count outside the top k
+	var next int64
+	if k < len(sorted) {
+		next = sorted[k].count
+	}
+	if k > len(sorted) {
+		k = len(sorted)
+	}
+	top := make([]HeavyHitter, k)
+	for i, c := range sorted[:k] {
+		top[i] = HeavyHitter{
+			Key:        c.key,
+			Count:      c.count,
+			Error:      c.err,
+			Guaranteed: c.count-c.err >= next,
+		}
+	}
+	return top
+}
+
+func heavyHitterCounts(top []HeavyHitter) map[string]int64 {
+	counts := make(map[string]int64, len(top))
+	for _, h := range top {
+		counts[h.Key] = h.Count
+	}
+	return counts
+}
+
+func (a *App) topK() (k, capacity int) {
+	k = defaultTopK
+	if a.Config != nil && a.Config.TopK > 0 {
+		k = a.Config.TopK
+	}
+	capacity = k * topKCapacityPerSlot
+	if a.Config != nil && a.Config.TopKCapacity >= k {
+		capacity = a.Config.TopKCapacity
+	}
+	return k, capacity
+}
+
+// HeavyHitters counts routes and countries per line without sorting, the
+// ranking happens once per snapshot in GetMetricsSnapshot under the read lock
+type HeavyHitters struct {
+	k         int
+	endpoints *SpaceSaving
+	countries *SpaceSaving
+}
+
+func (a *App) newHeavyHitters() *HeavyHitters {
+	k, capacity := a.topK()
+	return &HeavyHitters{k: k, endpoints: NewSpaceSaving(capacity), countries: NewSpaceSaving(capacity)}
+}
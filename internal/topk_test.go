@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSpaceSaving_Exact(t *testing.T) {
+	s := NewSpaceSaving(10)
+	for i, n := range []int{5, 3, 8, 1} {
+		for j := 0; j < n; j++ {
+			s.Add(fmt.Sprintf("key%d", i))
+		}
+	}
+
+	top := s.Top(2)
+	if len(top) != 2 {
+		t.Fatalf("Expected 2 hitters, got %d", len(top))
+	}
+	if top[0].Key != "key2" || top[0].Count != 8 || top[1].Key != "key0" || top[1].Count != 5 {
+		t.Errorf("Unexpected top %+v", top)
+	}
+	for _, h := range top {
+		if h.Error != 0 || !h.Guaranteed {
+			t.Errorf("Expected exact guaranteed counts below capacity, got %+v", h)
+		}
+	}
+	if all := s.Top(10); len(all) != 4 {
+		t.Errorf("Expected Top to cap at tracked keys, got %d", len(all))
+	}
+}
+
+func TestSpaceSaving_LateHeavyHitter(t *testing.T) {
+	// the old aggregator kept the first five keys forever, a key that
+	// becomes popular later must still make it into the top
+	s := NewSpaceSaving(20)
+	for i := 0; i < 1000; i++ {
+		s.Add(fmt.Sprintf("/noise/%d", i))
+	}
+	for i := 0; i < 500; i++ {
+		s.Add("/hot")
+		s.Add(fmt.Sprintf("/noise/late/%d", i))
+	}
+
+	top := s.Top(3)
+	if top[0].Key != "/hot" {
+		t.Fatalf("Expected /hot first, got %+v", top)
+	}
+	if top[0].Count < 500 || top[0].Count-top[0].Error > 500 {
+		t.Errorf("Expected 500 within the error bound, got %+v", top[0])
+	}
+	if !top[0].Guaranteed {
+		t.Errorf("Expected /hot guaranteed, got %+v", top[0])
+	}
+	if len(s.counters) != 20 || len(s.heap) != 20 {
+		t.Errorf("Expected memory bounded by capacity, got %d counters", len(s.counters))
+	}
+}
+
+func TestSpaceSaving_ErrorBound(t *testing.T) {
+	s := NewSpaceSaving(5)
+	truth := map[string]int64{}
+	for i := 0; i < 2000; i++ {
+		key := fmt.Sprintf("k%d", (i*i)%37)
+		if i%3 == 0 {
+			key = "heavy"
+		}
+		truth[key]++
+		s.Add(key)
+	}
+	for _, h := range s.Top(5) {
+		if h.Count < truth[h.Key] || h.Count-h.Error > truth[h.Key] {
+			t.Errorf("%s: true count %d outside [%d, %d]", h.Key, truth[h.Key], h.Count-h.Error, h.Count)
+		}
+	}
+}
+
+func TestApp_TopK(t *testing.T) {
+	tests := []struct {
+		name         string
+		config       *Config
+		wantK        int
+		wantCapacity int
+	}{
+		{"no config", nil, 5, 50},
+		{"k only", &Config{TopK: 20}, 20, 200},
+		{"capacity", &Config{TopK: 3, TopKCapacity: 64}, 3, 64},
+		{"capacity below k", &Config{TopK: 10, TopKCapacity: 4}, 10, 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, capacity := (&App{Config: tt.config}).topK()
+			if k != tt.wantK || capacity != tt.wantCapacity {
+				t.Errorf("Expected %d/%d, got %d/%d", tt.wantK, tt.wantCapacity, k, capacity)
+			}
+		})
+	}
+}
+
+func TestApp_TopEndpointsRanked(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.Config = &Config{TopK: 2}
+	app.StartMetricsAggregator()
+
+	paths := []string{"/a", "/b", "/c", "/d", "/e", "/f", "/late", "/late", "/late", "/b"}
+	for _, path := range paths {
+		app.MetricChan <- createTestParsedLog(200, "GET", path, "", "", 10*time.Millisecond, false)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+
+	metrics := app.GetMetricsSnapshot()
+	if len(metrics.TopEndpointsRanked) != 2 {
+		t.Fatalf("Expected 2 ranked endpoints, got %+v", metrics.TopEndpointsRanked)
+	}
+	if metrics.TopEndpointsRanked[0].Key != "/late" || metrics.TopEndpointsRanked[1].Key != "/b" {
+		t.Errorf("Unexpected ranking %+v", metrics.TopEndpointsRanked)
+	}
+	if len(metrics.TopEndpoints) != 2 || metrics.TopEndpoints["/late"] != 3 {
+		t.Errorf("Expected TopEndpoints to mirror the ranking, got %v", metrics.TopEndpoints)
+	}
+}
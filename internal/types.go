@@ -1,7 +1,9 @@
 package internal
 
 import (
+	"database/sql"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	ip2 "github.com/ip2location/ip2location-go"
@@ -12,6 +14,7 @@ type App struct {
 	RawLogChan     chan []byte
 	ParsedLogChan  chan *ParsedLog
 	GeoDb          *ip2.DB
+	LogDb          *sql.DB // shared by the DbWriter and the query handlers
 	Metric         *Metric
 	MetricsMu      sync.RWMutex // protect Metric field
 	DbWriteChan    chan *Metric
@@ -19,6 +22,20 @@ type App struct {
 	MetricChan     chan *ParsedLog
 	RateLimiter    *RateLimiterMap
 	Config         *Config
+	Parsers        *ParserRegistry
+	Routes         *RouteNormalizer
+	Windows        *TimeWindows        // rolling buckets, guarded by MetricsMu
+	Endpoints      *EndpointStats      // per route breakdown, guarded by MetricsMu
+	HeavyHitters   *HeavyHitters       // top routes and countries, guarded by MetricsMu
+	RouterErrors   *RouterErrorTracker // H-codes over the last minute, guarded by MetricsMu
+	SLOs           *SLOTracker         // nil without SLO_PATH, guarded by MetricsMu
+	AlertEngine    *AlertEngine        // evaluated by StartAlertEngine, guarded by MetricsMu
+	AlertChan      chan Alert          // lifecycle changes for alert_history
+	Silences       []Silence           // guarded by MetricsMu
+	Notifiers      *Notifiers          // nil without NOTIFIERS_PATH
+	Anomalies      *AnomalyDetector    // nil with ANOMALY_THRESHOLD=0, guarded by MetricsMu
+	OTLP           *OTLPExporter       // nil without OTEL_EXPORTER_OTLP_ENDPOINT
+	UnparsedCount  atomic.Int64        // lines no parser claimed
 }
 type DedupeCache struct {
 	Buffer   []string //ring buffer
@@ -37,6 +54,7 @@ type ParsedLog struct {
 	Host         string
 	Method       string
 	Path         string
+	Route        string // Path normalized, see routes.go
 	Protocol     string
 	ReqId        string
 	ResponseTime time.Duration
@@ -44,6 +62,28 @@ type ParsedLog struct {
 	Success      bool
 	Threshold    string
 	IsSlow       bool
+	ErrorCode    string // heroku error code, H-codes from the router, R-codes from dynos
+	ErrorDesc    string
+
+	// syslog header
+	Facility       int
+	Severity       int
+	Hostname       string
+	AppName        string // "heroku" or "app"
+	ProcId         string // "router", "web.1", ...
+	MsgId          string
+	StructuredData string
+
+	Source  string            // parser that produced the record, see registry.go
+	Message string            // free form part after the syslog header
+	Fields  map[string]string // key=value pairs for non router sources, captures of a rule
+	Rule    string            `json:",omitempty"` // extraction rule that matched
+	Attrs   map[string]any    `json:",omitempty"` // flattened JSON payload of app lines
+
+	Runtime   *RuntimeSample  `json:",omitempty"` // log-runtime-metrics
+	Lifecycle *LifecycleEvent `json:",omitempty"` // dyno manager state changes
+	Database  *DatabaseSample `json:",omitempty"` // heroku-postgres / heroku-redis metrics
+	Deploy    *DeployEvent    `json:",omitempty"` // app[api] release markers
 }
 type Metric struct {
 	Timestamp         time.Time     `json:"timestamp"`
@@ -53,8 +93,10 @@ type Metric struct {
 	ErrorRate         float64       `json:"error_rate"`
 	AvgResponseTime   time.Duration `json:"avg_response_time"`
 	P50ResponseTime   time.Duration `json:"p50_response_time"`
+	P90ResponseTime   time.Duration `json:"p90_response_time"`
 	P95ResponseTime   time.Duration `json:"p95_response_time"`
 	P99ResponseTime   time.Duration `json:"p99_response_time"`
+	P999ResponseTime  time.Duration `json:"p999_response_time"`
 	SlowRequestCount  int64         `json:"slow_request_count"`
 	Status2xx         int64         `json:"status_2xx"`
 	Status3xx         int64         `json:"status_3xx"`
@@ -65,21 +107,68 @@ type Metric struct {
 	PutRequests       int64         `json:"put_requests"`
 	DeleteRequests    int64         `json:"delete_requests"`
 	OtherRequests     int64         `json:"other_requests"`
+	UnparsedLogs      int64         `json:"unparsed_logs"`
+
+	RouterErrors       map[string]int64 `json:"router_errors"`        // H-code | count
+	RecentRouterErrors map[string]int64 `json:"recent_router_errors"` // H-code | count in the last minute
 
-	TopCountries map[string]int64 `json:"top_countries"` // Country| count
+	DatabaseHealth map[string]DatabaseHealth `json:"database_health"` // add-on attachment | latest sample
+
+	FieldMetrics map[string]FieldMetric `json:"field_metrics"` // numeric JSON app log field | summary
+
+	TopCountries       map[string]int64 `json:"top_countries"` // Country| count
+	TopCountriesRanked []HeavyHitter    `json:"top_countries_ranked"`
 
 	DynoPerformance map[string]DynoMetric `json:"dyno_performance"`
-	TopEndpoints    map[string]int64      `json:"top_endpoints"`
+	TopEndpoints    map[string]int64      `json:"top_endpoints"` // route | estimated count, see topk.go
 	ChannelHealth   ChannelHealth         `json:"channel_health"`
 	ActiveAlerts    []Alert               `json:"active_alerts"`
+
+	TopEndpointsRanked []HeavyHitter `json:"top_endpoints_ranked"` // highest first, with error bounds
+
+	LatencySketch *LatencySketch `json:"latency_sketch,omitempty"` // response times since start, see sketch.go
+
+	Windows map[string]WindowMetric `json:"windows"`          // "1m", "5m", "15m", "1h"
+	Window  *WindowMetric           `json:"window,omitempty"` // the ?window= view
+
+	EventTime EventTimeStats `json:"event_time"` // watermark and late lines, see eventtime.go
+
+	Apdex float64     `json:"apdex"`          // see apdex.go
+	SLOs  []SLOStatus `json:"slos,omitempty"` // see slo.go
 }
 
 type DynoMetric struct {
 	Name            string        `json:"name"`
 	RequestCount    int64         `json:"request_count"`
 	AvgResponseTime time.Duration `json:"avg_response_time"`
+	P50ResponseTime time.Duration `json:"p50_response_time"`
+	P95ResponseTime time.Duration `json:"p95_response_time"`
+	P99ResponseTime time.Duration `json:"p99_response_time"`
 	ErrorRate       float64       `json:"error_rate"`
-	Status          string        `json:"status"` // "healthy", "warning", "critical"
+	Apdex           float64       `json:"apdex"`
+	Status          string        `json:"status"` // "healthy", "warning", "critical", "down"
+
+	ErrorCodes map[string]int64 `json:"error_codes,omitempty"` // heroku H/R-code | count
+
+	// log-runtime-metrics, memory in MB
+	MemoryTotal       float64   `json:"memory_total_mb"`
+	MemoryRSS         float64   `json:"memory_rss_mb"`
+	MemoryCache       float64   `json:"memory_cache_mb"`
+	MemorySwap        float64   `json:"memory_swap_mb"`
+	MemoryQuota       float64   `json:"memory_quota_mb"`
+	LoadAvg1m         float64   `json:"load_avg_1m"`
+	LoadAvg5m         float64   `json:"load_avg_5m"`
+	LoadAvg15m        float64   `json:"load_avg_15m"`
+	LastMemoryError   string    `json:"last_memory_error,omitempty"` // "R14", "R15"
+	LastMemoryErrorAt time.Time `json:"last_memory_error_at"`
+
+	// dyno manager lifecycle
+	State          string           `json:"state,omitempty"` // "starting", "up", "crashed", "down"
+	StateChangedAt time.Time        `json:"state_changed_at"`
+	Restarts       int64            `json:"restarts"`
+	Crashes        int64            `json:"crashes"`
+	LastExitStatus int              `json:"last_exit_status,omitempty"`
+	Transitions    []DynoTransition `json:"transitions,omitempty"` // latest maxDynoTransitions
 }
 
 type ChannelHealth struct {
@@ -90,9 +179,26 @@ type ChannelHealth struct {
 }
 
 type Alert struct {
-	Type      string    `json:"type"`     // "higherrorrate", "slowResponse", "dynoDown"
-	Severity  string    `json:"severity"` // "warning", "critical"
+	Type      string    `json:"type"`           // "higherrorrate", "slowResponse", "dynoDown"
+	Code      string    `json:"code,omitempty"` // heroku H/R-code
+	Dyno      string    `json:"dyno,omitempty"`
+	Database  string    `json:"database,omitempty"`
+	SLO       string    `json:"slo,omitempty"`
+	Endpoint  string    `json:"endpoint,omitempty"`
+	Metric    string    `json:"metric,omitempty"` // anomaly alerts, see anomaly.go
+	Severity  string    `json:"severity"`         // "warning", "critical"
 	Message   string    `json:"message"`
-	Timestamp time.Time `json:"timestamp"`
+	Timestamp time.Time `json:"timestamp"` // last update
 	Resolved  bool      `json:"resolved"`
+
+	// lifecycle, see alerts.go
+	ID         string    `json:"id"`
+	FiredAt    time.Time `json:"fired_at"`
+	ResolvedAt time.Time `json:"resolved_at"`
+	Acked      bool      `json:"acked"`
+	AckedAt    time.Time `json:"acked_at"`
+	AckedBy    string    `json:"acked_by,omitempty"`
+	Silenced   bool      `json:"silenced"`
+
+	clearedAt time.Time // condition first seen clear, zero while firing
 }
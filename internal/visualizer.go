@@ -6,6 +6,8 @@ import (
 	"maps"
 	"net/http"
 	"os"
+	"slices"
+	"time"
 )
 
 func (a *App) GetMetricsSnapshot() *Metric {
@@ -25,8 +27,10 @@ func (a *App) GetMetricsSnapshot() *Metric {
 		ErrorRate:         a.Metric.ErrorRate,
 		AvgResponseTime:   a.Metric.AvgResponseTime,
 		P50ResponseTime:   a.Metric.P50ResponseTime,
+		P90ResponseTime:   a.Metric.P90ResponseTime,
 		P95ResponseTime:   a.Metric.P95ResponseTime,
 		P99ResponseTime:   a.Metric.P99ResponseTime,
+		P999ResponseTime:  a.Metric.P999ResponseTime,
 		SlowRequestCount:  a.Metric.SlowRequestCount,
 		Status2xx:         a.Metric.Status2xx,
 		Status3xx:         a.Metric.Status3xx,
@@ -38,34 +42,65 @@ func (a *App) GetMetricsSnapshot() *Metric {
 		DeleteRequests:    a.Metric.DeleteRequests,
 		OtherRequests:     a.Metric.OtherRequests,
 		ChannelHealth:     a.Metric.ChannelHealth,
+		UnparsedLogs:      a.UnparsedCount.Load(),
+		LatencySketch:     a.Metric.LatencySketch.Clone(),
+		EventTime:         a.Metric.EventTime,
+		Apdex:             a.Metric.Apdex,
 	}
 
 	// copy maps and slices
-	snapshot.TopCountries = make(map[string]int64)
-	maps.Copy(snapshot.TopCountries, a.Metric.TopCountries)
-
 	snapshot.DynoPerformance = make(map[string]DynoMetric)
-	maps.Copy(snapshot.DynoPerformance, a.Metric.DynoPerformance)
+	for name, dm := range a.Metric.DynoPerformance {
+		dm.ErrorCodes = maps.Clone(dm.ErrorCodes)
+		dm.Transitions = slices.Clone(dm.Transitions)
+		snapshot.DynoPerformance[name] = dm
+	}
+
+	// heavy hitters are ranked here rather than per line
+	if h := a.HeavyHitters; h != nil {
+		snapshot.TopEndpointsRanked = h.endpoints.Top(h.k)
+		snapshot.TopCountriesRanked = h.countries.Top(h.k)
+	} else {
+		snapshot.TopEndpointsRanked = slices.Clone(a.Metric.TopEndpointsRanked)
+		snapshot.TopCountriesRanked = slices.Clone(a.Metric.TopCountriesRanked)
+	}
+	snapshot.TopEndpoints = heavyHitterCounts(snapshot.TopEndpointsRanked)
+	snapshot.TopCountries = heavyHitterCounts(snapshot.TopCountriesRanked)
+	snapshot.SLOs = slices.Clone(a.Metric.SLOs) // statuses are replaced, never modified
+
+	snapshot.RouterErrors = make(map[string]int64)
+	maps.Copy(snapshot.RouterErrors, a.Metric.RouterErrors)
+
+	snapshot.RecentRouterErrors = make(map[string]int64)
+	maps.Copy(snapshot.RecentRouterErrors, a.Metric.RecentRouterErrors)
 
-	snapshot.TopEndpoints = make(map[string]int64)
-	maps.Copy(snapshot.TopEndpoints, a.Metric.TopEndpoints)
+	snapshot.DatabaseHealth = make(map[string]DatabaseHealth)
+	maps.Copy(snapshot.DatabaseHealth, a.Metric.DatabaseHealth)
+
+	snapshot.FieldMetrics = make(map[string]FieldMetric)
+	maps.Copy(snapshot.FieldMetrics, a.Metric.FieldMetrics)
 
 	snapshot.ActiveAlerts = make([]Alert, len(a.Metric.ActiveAlerts))
 	copy(snapshot.ActiveAlerts, a.Metric.ActiveAlerts)
 
+	if a.Windows != nil {
+		snapshot.Windows = a.Windows.Views(time.Now())
+	}
+
 	return snapshot
 }
 
-func (a *App) MetricsHandler(w http.ResponseWriter, r *http.Request) {
-	apiKey := r.Header.Get("X-API-KEY")
+// checks the api key and rate limit, writes the error response and returns false on failure
+func (a *App) authorize(w http.ResponseWriter, r *http.Request) bool {
+	return a.authorizeKey(w, r.Header.Get("X-API-KEY"))
+}
+
+func (a *App) authorizeKey(w http.ResponseWriter, apiKey string) bool {
 	expectedKey := os.Getenv("METRICS-API-KEY")
 	if !hmac.Equal([]byte(apiKey), []byte(expectedKey)) {
 		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusUnauthorized)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "unauthorized",
-		})
-		return
+		writeJSONError(w, http.StatusUnauthorized, "unauthorized")
+		return false
 	}
 	bucket := a.RateLimiter.GetBucket(apiKey)
 	if !bucket.Allow() {
@@ -75,12 +110,42 @@ func (a *App) MetricsHandler(w http.ResponseWriter, r *http.Request) {
 			"error":       "rate limit exceeded",
 			"retry_after": "1",
 		})
+		return false
+	}
+	return true
+}
+
+// writes the {"error": msg} body of a failed request
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{
+		"error": msg,
+	})
+}
+
+func (a *App) MetricsHandler(w http.ResponseWriter, r *http.Request) {
+	if !a.authorize(w, r) {
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 
+	var window *WindowMetric
+	if v := r.URL.Query().Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err == nil {
+			var view WindowMetric
+			view, err = a.windowView(d)
+			window = &view
+		}
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, errBadWindow.Error())
+			return
+		}
+	}
+
 	snapshot := a.GetMetricsSnapshot()
+	snapshot.Window = window
 
 	if err := json.NewEncoder(w).Encode(snapshot); err != nil {
 		http.Error(w, "Failed to encode metrics", http.StatusInternalServerError)
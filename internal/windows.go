@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"errors"
+	"time"
+)
+
+// Rolling windows over the router traffic. The aggregator records every request
+// into a ring of fixed size buckets, a view of the last N minutes merges the
+// buckets it covers at read time so a quiet period shows up as a drop.
+
+const (
+	windowBucketSpan = 10 * time.Second
+	maxWindow        = 1 * time.Hour
+	windowBuckets    = int(maxWindow / windowBucketSpan)
+)
+
+// reported with every snapshot
+var standardWindows = []struct {
+	name string
+	d    time.Duration
+}{
+	{"1m", time.Minute},
+	{"5m", 5 * time.Minute},
+	{"15m", 15 * time.Minute},
+	{"1h", time.Hour},
+}
+
+var errBadWindow = errors.New("window must be between 10s and 1h")
+
+// WindowMetric is the traffic of one rolling window
+type WindowMetric struct {
+	Window            string        `json:"window"`
+	From              time.Time     `json:"from"`
+	To                time.Time     `json:"to"`
+	TotalRequests     int64         `json:"total_requests"`
+	RequestsPerSecond float64       `json:"requests_per_second"`
+	SuccessRate       float64       `json:"success_rate"`
+	ErrorRate         float64       `json:"error_rate"`
+	SlowRequestCount  int64         `json:"slow_request_count"`
+	Status2xx         int64         `json:"status_2xx"`
+	Status3xx         int64         `json:"status_3xx"`
+	Status4xx         int64         `json:"status_4xx"`
+	Status5xx         int64         `json:"status_5xx"`
+	AvgResponseTime   time.Duration `json:"avg_response_time"`
+	P50ResponseTime   time.Duration `json:"p50_response_time"`
+	P90ResponseTime   time.Duration `json:"p90_response_time"`
+	P95ResponseTime   time.Duration `json:"p95_response_time"`
+	P99ResponseTime   time.Duration `json:"p99_response_time"`
+	Apdex             float64       `json:"apdex"`
+}
+
+type windowBucket struct {
+	epoch     int64 // bucket number since the unix epoch, 0 when unused
+	requests  int64
+	status2xx int64
+	status3xx int64
+	status4xx int64
+	status5xx int64
+	slow      int64
+	apdex     ApdexCounts
+	latency   *LatencySketch
+}
+
+// TimeWindows is written by the aggregator and read by the handlers, both under MetricsMu
+type TimeWindows struct {
+	buckets []windowBucket
+	last    int64 // newest bucket written
+}
+
+func NewTimeWindows() *TimeWindows {
+	return &TimeWindows{buckets: make([]windowBucket, windowBuckets)}
+}
+
+func bucketEpoch(t time.Time) int64 {
+	return t.UnixNano() / int64(windowBucketSpan)
+}
+
+func (w *TimeWindows) bucket(e int64) *windowBucket {
+	n := int64(len(w.buckets))
+	return &w.buckets[(e%n+n)%n]
+}
+
+// Record adds a router line to the bucket of at, lines older than the ring are dropped
+func (w *TimeWindows) Record(at time.Time, l *ParsedLog) {
+	e := bucketEpoch(at)
+	b := w.bucket(e)
+	if b.epoch > e {
+		return
+	}
+	if b.epoch != e {
+		*b = windowBucket{epoch: e, latency: NewLatencySketch()}
+	}
+	if e > w.last {
+		w.last = e
+	}
+
+	b.requests++
+	switch {
+	case l.Status >= 200 && l.Status < 300:
+		b.status2xx++
+	case l.Status >= 300 && l.Status < 400:
+		b.status3xx++
+	case l.Status >= 400 && l.Status < 500:
+		b.status4xx++
+	case l.Status >= 500:
+		b.status5xx++
+	}
+	if l.IsSlow {
+		b.slow++
+	}
+	b.apdex.Add(l.Status, l.ResponseTime)
+	b.latency.Add(l.ResponseTime)
+}
+
+// true once nothing was recorded for a full maxWindow
+func (w *TimeWindows) Idle(now time.Time) bool {
+	return bucketEpoch(now)-w.last >= int64(windowBuckets)
+}
+
+// View merges the buckets of the last d before now, the current bucket included
+func (w *TimeWindows) View(now time.Time, d time.Duration) (WindowMetric, error) {
+	if d < windowBucketSpan || d > maxWindow {
+		return WindowMetric{}, errBadWindow
+	}
+	n := int64((d + windowBucketSpan - 1) / windowBucketSpan)
+	last := bucketEpoch(now)
+
+	view := WindowMetric{
+		Window: d.String(),
+		From:   now.Add(-d),
+		To:     now,
+	}
+	latency := NewLatencySketch()
+	var apdex ApdexCounts
+	for e := last - n + 1; e <= last; e++ {
+		b := w.bucket(e)
+		if b.epoch != e {
+			continue
+		}
+		view.TotalRequests += b.requests
+		view.Status2xx += b.status2xx
+		view.Status3xx += b.status3xx
+		view.Status4xx += b.status4xx
+		view.Status5xx += b.status5xx
+		view.SlowRequestCount += b.slow
+		apdex.Satisfied += b.apdex.Satisfied
+		apdex.Tolerating += b.apdex.Tolerating
+		apdex.Frustrated += b.apdex.Frustrated
+		latency.Merge(b.latency)
+	}
+
+	view.RequestsPerSecond = float64(view.TotalRequests) / d.Seconds()
+	if view.TotalRequests > 0 {
+		view.SuccessRate = float64(view.Status2xx) / float64(view.TotalRequests) * 100
+		view.ErrorRate = float64(view.Status4xx+view.Status5xx) / float64(view.TotalRequests) * 100
+	}
+	view.Apdex = apdex.Score()
+	view.AvgResponseTime = latency.Mean()
+	p := latency.Quantiles(0.5, 0.9, 0.95, 0.99)
+	view.P50ResponseTime = p[0]
+	view.P90ResponseTime = p[1]
+	view.P95ResponseTime = p[2]
+	view.P99ResponseTime = p[3]
+	return view, nil
+}
+
+// view of d over the live windows, takes MetricsMu
+func (a *App) windowView(d time.Duration) (WindowMetric, error) {
+	a.MetricsMu.RLock()
+	defer a.MetricsMu.RUnlock()
+	windows := a.Windows
+	if windows == nil {
+		windows = NewTimeWindows()
+	}
+	return windows.View(time.Now(), d)
+}
+
+// the standard windows, keyed by name
+func (w *TimeWindows) Views(now time.Time) map[string]WindowMetric {
+	views := make(map[string]WindowMetric, len(standardWindows))
+	for _, sw := range standardWindows {
+		view, _ := w.View(now, sw.d)
+		view.Window = sw.name
+		views[sw.name] = view
+	}
+	return views
+}
@@ -0,0 +1,129 @@
+package internal
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeWindows_View(t *testing.T) {
+	w := NewTimeWindows()
+	now := time.Date(2025, 7, 19, 12, 0, 0, 0, time.UTC)
+
+	// an hour ago: a burst of errors, the last minute: healthy traffic
+	for i := 0; i < 50; i++ {
+		w.Record(now.Add(-50*time.Minute), &ParsedLog{Status: 500, ResponseTime: time.Second, IsSlow: true})
+	}
+	// the 1m view is the current bucket and the five before it
+	now = now.Add(windowBucketSpan - time.Second)
+	for i := 0; i < 60; i++ {
+		w.Record(now.Add(-time.Duration(i)*time.Second), &ParsedLog{Status: 200, ResponseTime: 20 * time.Millisecond})
+	}
+
+	oneMinute, err := w.View(now, time.Minute)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if oneMinute.TotalRequests != 60 || oneMinute.Status2xx != 60 || oneMinute.ErrorRate != 0 {
+		t.Errorf("Expected 60 clean requests in the last minute, got %+v", oneMinute)
+	}
+	if oneMinute.RequestsPerSecond != 1 {
+		t.Errorf("Expected 1 req/s, got %f", oneMinute.RequestsPerSecond)
+	}
+	assertDurationNear(t, "1m p99", oneMinute.P99ResponseTime, 20*time.Millisecond)
+
+	oneHour, err := w.View(now, time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if oneHour.TotalRequests != 110 || oneHour.Status5xx != 50 || oneHour.SlowRequestCount != 50 {
+		t.Errorf("Expected the error burst in the hour window, got %+v", oneHour)
+	}
+	assertDurationNear(t, "1h p99", oneHour.P99ResponseTime, time.Second)
+
+	// after a quiet hour everything has rolled out
+	quiet, _ := w.View(now.Add(2*time.Hour), time.Hour)
+	if quiet.TotalRequests != 0 || quiet.RequestsPerSecond != 0 {
+		t.Errorf("Expected an empty window after a quiet hour, got %+v", quiet)
+	}
+
+	for _, d := range []time.Duration{time.Second, 2 * time.Hour} {
+		if _, err := w.View(now, d); err != errBadWindow {
+			t.Errorf("View(%v): expected errBadWindow, got %v", d, err)
+		}
+	}
+}
+
+func TestTimeWindows_BucketReuse(t *testing.T) {
+	w := NewTimeWindows()
+	now := time.Date(2025, 7, 19, 12, 0, 0, 0, time.UTC)
+
+	w.Record(now, &ParsedLog{Status: 500})
+	// same ring slot one lap later
+	w.Record(now.Add(maxWindow), &ParsedLog{Status: 200})
+
+	view, _ := w.View(now.Add(maxWindow), windowBucketSpan)
+	if view.TotalRequests != 1 || view.Status5xx != 0 {
+		t.Errorf("Expected the reused bucket reset, got %+v", view)
+	}
+}
+
+func TestApp_MetricsHandler_Window(t *testing.T) {
+	app := createTestAppForMetrics()
+	app.RateLimiter = NewRateLimiterMap(100, 10)
+	app.StartMetricsAggregator()
+
+	for i := 0; i < 4; i++ {
+		app.MetricChan <- createTestParsedLog(200, "GET", "/test", "", "web.1", 10*time.Millisecond, false)
+	}
+	app.MetricChan <- createTestParsedLog(503, "GET", "/test", "", "web.1", 10*time.Millisecond, false)
+	time.Sleep(100 * time.Millisecond)
+
+	t.Run("window view", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		app.MetricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics?window=5m", nil))
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		var metrics Metric
+		if err := json.NewDecoder(w.Body).Decode(&metrics); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if metrics.Window == nil || metrics.Window.Window != "5m0s" {
+			t.Fatalf("Expected a 5m window, got %+v", metrics.Window)
+		}
+		if metrics.Window.TotalRequests != 5 || metrics.Window.ErrorRate != 20 {
+			t.Errorf("Expected 5 requests at 20%% errors, got %+v", metrics.Window)
+		}
+		for _, name := range []string{"1m", "5m", "15m", "1h"} {
+			if metrics.Windows[name].TotalRequests != 5 {
+				t.Errorf("Expected 5 requests in the %s window, got %+v", name, metrics.Windows[name])
+			}
+		}
+	})
+
+	t.Run("no window", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		app.MetricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+		var metrics Metric
+		json.NewDecoder(w.Body).Decode(&metrics)
+		if metrics.Window != nil {
+			t.Errorf("Expected no window view, got %+v", metrics.Window)
+		}
+	})
+
+	for _, v := range []string{"abc", "1s", "2h"} {
+		t.Run("bad window "+v, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			app.MetricsHandler(w, httptest.NewRequest(http.MethodGet, "/metrics?window="+v, nil))
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status 400, got %d", w.Code)
+			}
+		})
+	}
+}
@@ -9,8 +9,8 @@ import (
 	_ "github.com/mattn/go-sqlite3"
 )
 
-// consume from DB chans and also trigger snapshots
-func (a *App) StartDbWriter() {
+// Opens the log store and creates/migrates its tables
+func (a *App) OpenDb() (*sql.DB, error) {
 	dbPath := "./logs.db" // default
 	if a.Config != nil {
 		dbPath = a.Config.DatabasePath
@@ -18,13 +18,13 @@ func (a *App) StartDbWriter() {
 
 	db, err := sql.Open("sqlite3", dbPath+"?cache=shared&mode=rwc")
 	if err != nil {
-		log.Fatal("Failed to open database:", err)
+		return nil, err
 	}
-	defer db.Close()
 
 	err = a.initTables(db)
 	if err != nil {
-		log.Fatal("Failed to create tables:", err)
+		db.Close()
+		return nil, err
 	}
 
 	// Ensure database is in read-write mode
@@ -32,6 +32,20 @@ func (a *App) StartDbWriter() {
 	if err != nil {
 		log.Printf("Warning: Failed to set WAL mode: %v", err)
 	}
+	return db, nil
+}
+
+// consume from DB chans and also trigger snapshots
+func (a *App) StartDbWriter() {
+	db := a.LogDb
+	if db == nil {
+		var err error
+		db, err = a.OpenDb()
+		if err != nil {
+			log.Fatal("Failed to open database:", err)
+		}
+		defer db.Close()
+	}
 
 	const batchSize = 100
 	const flushInterval = 5 * time.Second
@@ -57,6 +71,12 @@ func (a *App) StartDbWriter() {
 				batch = batch[:0]
 			}
 
+		case alert := <-a.AlertChan:
+			err := a.writeAlertToDb(db, alert)
+			if err != nil {
+				log.Printf("Failed to write alert %s to DB: %v", alert.ID, err)
+			}
+
 		case <-flushTicker.C:
 			if len(batch) > 0 {
 				err := a.writeBatchToDb(db, batch)
@@ -90,7 +110,8 @@ func (a *App) initTables(db *sql.DB) error {
 		id INTEGER PRIMARY KEY AUTOINCREMENT,
 		timestamp DATETIME,
 		log_data TEXT, 
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		request_id TEXT
 	);`
 
 	snapshotTable := `
@@ -101,26 +122,163 @@ func (a *App) initTables(db *sql.DB) error {
 		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
 	);`
 
+	deployTable := `
+	CREATE TABLE IF NOT EXISTS deploy_markers (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		event_time DATETIME,
+		kind TEXT,
+		version TEXT,
+		commit_sha TEXT,
+		actor TEXT,
+		message TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);`
+
+	alertTable := `
+	CREATE TABLE IF NOT EXISTS alert_history (
+		id TEXT PRIMARY KEY,
+		type TEXT,
+		severity TEXT,
+		fired_at DATETIME,
+		resolved_at DATETIME,
+		acked_at DATETIME,
+		alert_data TEXT
+	);`
+
+	fieldTable := `
+	CREATE TABLE IF NOT EXISTS log_fields (
+		log_id INTEGER REFERENCES raw_logs (id),
+		key TEXT,
+		value_text TEXT,
+		value_num REAL
+	);`
+
 	_, err := db.Exec(logTable)
 	if err != nil {
 		return err
 	}
 
+	// tables created before request_id was a column
+	err = ensureColumn(db, "raw_logs", "request_id", "TEXT")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_raw_logs_request_id ON raw_logs (request_id)")
+	if err != nil {
+		return err
+	}
+	// the deploy windows scan raw_logs by time
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_raw_logs_timestamp ON raw_logs (timestamp)")
+	if err != nil {
+		return err
+	}
+
 	_, err = db.Exec(snapshotTable)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(deployTable)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_deploy_markers_event_time ON deploy_markers (event_time)")
+	if err != nil {
+		return err
+	}
+
+	// JSON app log fields, one row per flattened key
+	_, err = db.Exec(fieldTable)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_log_fields_text ON log_fields (key, value_text)")
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_log_fields_num ON log_fields (key, value_num)")
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec(alertTable)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec("CREATE INDEX IF NOT EXISTS idx_alert_history_fired_at ON alert_history (fired_at)")
+	return err
+}
+
+func ensureColumn(db *sql.DB, table, column, decl string) error {
+	rows, err := db.Query("SELECT name FROM pragma_table_info(?)", table)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		if name == column {
+			return nil
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	_, err = db.Exec("ALTER TABLE " + table + " ADD COLUMN " + column + " " + decl)
 	return err
 }
 
+// empty strings are stored as NULL so they stay out of the indexes
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
 func (a *App) writeLogToDb(db *sql.DB, logEntry *ParsedLog) error {
 	logJSON, err := json.Marshal(logEntry)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(
-		"INSERT INTO raw_logs (timestamp, log_data) VALUES (?, ?)",
-		logEntry.Time,
+	res, err := db.Exec(
+		"INSERT INTO raw_logs (timestamp, log_data, request_id) VALUES (?, ?, ?)",
+		logEntry.Time.UTC(),
 		string(logJSON),
+		nullIfEmpty(logEntry.ReqId),
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	if len(logEntry.Attrs) > 0 {
+		logId, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		stmt, err := db.Prepare(insertLogField)
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+		err = writeLogFields(stmt, logId, logEntry.Attrs)
+		if err != nil {
+			return err
+		}
+	}
+
+	if logEntry.Deploy == nil {
+		return nil
+	}
+	stmt, err := db.Prepare(insertDeployMarker)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	return writeDeployMarker(stmt, logEntry)
 }
 
 func (a *App) writeBatchToDb(db *sql.DB, batch []*ParsedLog) error {
@@ -134,22 +292,52 @@ func (a *App) writeBatchToDb(db *sql.DB, batch []*ParsedLog) error {
 	}
 	defer tx.Rollback()
 
-	stmt, err := tx.Prepare("INSERT INTO raw_logs (timestamp, log_data) VALUES (?, ?)")
+	stmt, err := tx.Prepare("INSERT INTO raw_logs (timestamp, log_data, request_id) VALUES (?, ?, ?)")
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
+	deployStmt, err := tx.Prepare(insertDeployMarker)
+	if err != nil {
+		return err
+	}
+	defer deployStmt.Close()
+
+	fieldStmt, err := tx.Prepare(insertLogField)
+	if err != nil {
+		return err
+	}
+	defer fieldStmt.Close()
+
 	for _, logEntry := range batch {
 		logJSON, err := json.Marshal(logEntry)
 		if err != nil {
 			return err
 		}
 
-		_, err = stmt.Exec(logEntry.Time, string(logJSON))
+		res, err := stmt.Exec(logEntry.Time.UTC(), string(logJSON), nullIfEmpty(logEntry.ReqId))
 		if err != nil {
 			return err
 		}
+
+		if len(logEntry.Attrs) > 0 {
+			logId, err := res.LastInsertId()
+			if err != nil {
+				return err
+			}
+			err = writeLogFields(fieldStmt, logId, logEntry.Attrs)
+			if err != nil {
+				return err
+			}
+		}
+
+		if logEntry.Deploy != nil {
+			err = writeDeployMarker(deployStmt, logEntry)
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return tx.Commit()
@@ -163,7 +351,7 @@ func (a *App) writeSnapshotToDb(db *sql.DB, snapshot *Metric) error {
 
 	_, err = db.Exec(
 		"INSERT INTO metric_snapshots (snapshot_time, metrics_data) VALUES (?, ?)",
-		snapshot.Timestamp,
+		snapshot.Timestamp.UTC(),
 		string(snapshotJSON),
 	)
 	return err
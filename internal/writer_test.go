@@ -156,6 +156,42 @@ func TestInitTables(t *testing.T) {
 	}
 }
 
+func TestInitTables_RequestIdMigration(t *testing.T) {
+	app := createWriterTestApp(t)
+	db := createWriterTestDB(t)
+	db.SetMaxOpenConns(1)
+	defer db.Close()
+
+	// raw_logs as created before request_id existed
+	_, err := db.Exec(`CREATE TABLE raw_logs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		timestamp DATETIME,
+		log_data TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		t.Fatalf("Failed to create legacy table: %v", err)
+	}
+
+	if err := app.initTables(db); err != nil {
+		t.Fatalf("initTables() on legacy table: %v", err)
+	}
+	// running it twice must be a no-op
+	if err := app.initTables(db); err != nil {
+		t.Fatalf("initTables() second run: %v", err)
+	}
+
+	var count int
+	err = db.QueryRow("SELECT COUNT(*) FROM pragma_table_info('raw_logs') WHERE name = 'request_id'").Scan(&count)
+	if err != nil || count != 1 {
+		t.Errorf("Expected request_id column after migration, got %d (%v)", count, err)
+	}
+	err = db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type='index' AND name='idx_raw_logs_request_id'").Scan(&count)
+	if err != nil || count != 1 {
+		t.Errorf("Expected request_id index, got %d (%v)", count, err)
+	}
+}
+
 func TestWriteLogToDb(t *testing.T) {
 	tests := []struct {
 		name     string